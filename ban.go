@@ -1,10 +1,7 @@
 package traffic
 
 import (
-	"context"
 	"fmt"
-	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"net"
 	"strings"
 	"time"
@@ -14,28 +11,116 @@ import (
 
 // BanItem BanItem
 type BanItem struct {
-	IP       net.IP    `json:"ip"`
+	IP       Network   `json:"ip"`
 	Until    time.Time `json:"up_to"`
 	ByUserID int       `json:"by_user_id"`
 	Reason   string    `json:"reason"`
+	// Offences number of bans of IP during offence clean period, including this one
+	Offences int `json:"offences"`
+}
+
+// BanHistoryItem recorded ban
+type BanHistoryItem struct {
+	BanItem
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BanChange change of ban of network recorded by repository
+type BanChange struct {
+	ID int64   `json:"id"`
+	IP Network `json:"ip"`
+	// Item current ban of network, nil when it is removed
+	Item *BanItem `json:"item"`
+}
+
+// BanSortUpTo orders bans by end of ban
+const BanSortUpTo = "up_to"
+
+// BanSorts fields bans can be listed by, first one is default
+var BanSorts = []string{ListSortIP, BanSortUpTo}
+
+// BanListQuery filter, order and page of bans listing. Only bans which are not expired yet are listed
+type BanListQuery struct {
+	Reason   string
+	ByUserID *int
+	// ExpiresAfter and ExpiresBefore bound end of ban when not zero
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	// Contains selects bans which contain network
+	Contains *Network
+	// Within selects bans which are subnets of network or equal to it
+	Within *Network
+	Sort   ListSort
+	After  *ListCursor
+	Limit  int
+}
+
+// BanListPage page of bans listing
+type BanListPage struct {
+	Items []BanItem `json:"items"`
+	// Total number of bans matching filter
+	Total int `json:"total"`
+	// NextCursor continues listing, empty on last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// BanRepository storage of banned IPs
+type BanRepository interface {
+	// Add inserts ban of network or replaces existing one and records it to offence history
+	Add(network Network, until time.Time, byUserID int, reason string) error
+	// Remove deletes bans of network and of all its subnets
+	Remove(network Network) error
+	// RemoveByReason deletes ban of exactly this network if it has given reason
+	RemoveByReason(network Network, reason string) error
+	// ExtendByReason sets end of ban of exactly this network if it has given reason. Offence history is not recorded
+	ExtendByReason(network Network, until time.Time, reason string) error
+	// ListByReason returns bans with given reason
+	ListByReason(reason string) ([]BanItem, error)
+	// List returns bans which are not expired yet
+	List() ([]BanItem, error)
+	// Find returns up to query.Limit bans matching query after its cursor and total number of matching bans
+	Find(query BanListQuery) ([]BanItem, int, error)
+	// Exists reports ban which is not expired yet and contains network
+	Exists(network Network) (bool, error)
+	// Get returns most specific ban which is not expired yet and contains network or nil
+	Get(network Network) (*BanItem, error)
+	// History returns up to limit recorded bans of networks which contain network, most recent first
+	History(network Network, limit int) ([]BanHistoryItem, error)
+	// Offences counts bans of exactly this network recorded since given time
+	Offences(network Network, since time.Time) (int, error)
+	// GC deletes expired bans
+	GC() (int64, error)
+	// GCHistory deletes offence history recorded before given time
+	GCHistory(before time.Time) (int64, error)
+	// Clear removes bans and offence history
+	Clear() error
+	// LastChange returns cursor of most recent change
+	LastChange() (int64, error)
+	// Changes returns up to limit changes recorded after cursor in order of recording
+	Changes(cursor int64, limit int) ([]BanChange, error)
+	// GCChanges deletes changes recorded before given time
+	GCChanges(before time.Time) (int64, error)
 }
 
 // Ban Main Object
 type Ban struct {
-	db     *pgxpool.Pool
-	logger *util.Logger
+	repository    BanRepository
+	logger        *util.Logger
+	cleanPeriod   time.Duration
+	minIPv4Prefix int
+	minIPv6Prefix int
 }
 
 // NewBan constructor
-func NewBan(db *pgxpool.Pool, logger *util.Logger) (*Ban, error) {
+func NewBan(repository BanRepository, logger *util.Logger) (*Ban, error) {
 
-	if db == nil {
-		return nil, fmt.Errorf("database connection is nil")
+	if repository == nil {
+		return nil, fmt.Errorf("ban repository is nil")
 	}
 
 	s := &Ban{
-		db:     db,
-		logger: logger,
+		repository: repository,
+		logger:     logger,
 	}
 
 	return s, nil
@@ -43,90 +128,182 @@ func NewBan(db *pgxpool.Pool, logger *util.Logger) (*Ban, error) {
 
 // Add IP to list of banned
 func (s *Ban) Add(ip net.IP, duration time.Duration, byUserID int, reason string) error {
+	return s.AddNetwork(NetworkFromIP(ip), duration, byUserID, reason)
+}
+
+// AddNetwork adds network to list of banned
+func (s *Ban) AddNetwork(network Network, duration time.Duration, byUserID int, reason string) error {
+	if err := s.CheckNetwork(network); err != nil {
+		return err
+	}
+
 	reason = strings.TrimSpace(reason)
 	upTo := time.Now().Add(duration)
 
-	ct, err := s.db.Exec(context.Background(), `
-		INSERT INTO ip_ban (ip, until, by_user_id, reason)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT(ip) DO UPDATE SET until=EXCLUDED.until, by_user_id=EXCLUDED.by_user_id, reason=EXCLUDED.reason
-	`, ip, upTo, byUserID, reason)
+	err := s.repository.Add(network, upTo, byUserID, reason)
 	if err != nil {
 		return err
 	}
 
-	affected := ct.RowsAffected()
+	s.logger.Warningf("%v was banned. Reason: %s", network.String(), reason)
 
-	if affected == 1 {
-		s.logger.Warningf("%v was banned. Reason: %s", ip.String(), reason)
+	return nil
+}
+
+// SetMinPrefixes sets shortest prefixes of IPv4 and IPv6 networks which can be banned
+func (s *Ban) SetMinPrefixes(ipv4 int, ipv6 int) {
+	s.minIPv4Prefix = ipv4
+	s.minIPv6Prefix = ipv6
+}
+
+// CheckNetwork returns error when network is too wide to be banned
+func (s *Ban) CheckNetwork(network Network) error {
+	ones, bits := network.Mask.Size()
+
+	minPrefix := s.minIPv6Prefix
+	if bits == net.IPv4len*8 {
+		minPrefix = s.minIPv4Prefix
+	}
+
+	if ones < minPrefix {
+		return fmt.Errorf("network %v is wider than /%d", network, minPrefix)
 	}
 
 	return nil
 }
 
+// SetOffenceCleanPeriod sets period after which offence is forgotten. Zero means never
+func (s *Ban) SetOffenceCleanPeriod(cleanPeriod time.Duration) {
+	s.cleanPeriod = cleanPeriod
+}
+
+func (s *Ban) offencesSince() time.Time {
+	if s.cleanPeriod <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(-s.cleanPeriod)
+}
+
+// Offences number of bans of IP during clean period
+func (s *Ban) Offences(ip net.IP) (int, error) {
+	return s.OffencesNetwork(NetworkFromIP(ip))
+}
+
+// OffencesNetwork number of bans of network during clean period
+func (s *Ban) OffencesNetwork(network Network) (int, error) {
+	return s.repository.Offences(network, s.offencesSince())
+}
+
+// History of bans of IP and of networks which contain it, most recent first
+func (s *Ban) History(ip net.IP, limit int) ([]BanHistoryItem, error) {
+	return s.repository.History(NetworkFromIP(ip), limit)
+}
+
 // Remove IP from list of banned
 func (s *Ban) Remove(ip net.IP) error {
+	return s.RemoveNetwork(NetworkFromIP(ip))
+}
 
-	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban WHERE ip = $1", ip)
+// RemoveNetwork removes bans of network and of its subnets
+func (s *Ban) RemoveNetwork(network Network) error {
+	return s.repository.Remove(network)
+}
 
-	return err
+// List bans which are not expired yet
+func (s *Ban) List() ([]BanItem, error) {
+	return s.repository.List()
 }
 
-// Exists ban list already contains IP
-func (s *Ban) Exists(ip net.IP) (bool, error) {
+// Find page of bans matching query
+func (s *Ban) Find(query BanListQuery) (BanListPage, error) {
+	if len(query.Sort.Field) == 0 {
+		query.Sort.Field = ListSortIP
+	}
+
+	limit := listLimit(query.Limit)
+	// one more item tells whether next page exists
+	query.Limit = limit + 1
 
-	var exists bool
-	err := s.db.QueryRow(context.Background(), `
-		SELECT true
-		FROM ip_ban
-		WHERE ip = $1 AND until >= NOW()
-	`, ip).Scan(&exists)
+	items, total, err := s.repository.Find(query)
 	if err != nil {
-		if err != pgx.ErrNoRows {
-			return false, err
-		}
+		return BanListPage{}, err
+	}
 
-		return false, nil
+	page := BanListPage{Items: items, Total: total}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = banListKey(items[limit-1]).String()
 	}
 
-	return true, nil
+	return page, nil
+}
+
+// banListKey sort key of ban
+func banListKey(item BanItem) ListCursor {
+	return ListCursor{IP: item.IP, Time: item.Until}
+}
+
+// Exists ban list already contains IP
+func (s *Ban) Exists(ip net.IP) (bool, error) {
+	return s.ExistsNetwork(NetworkFromIP(ip))
+}
+
+// ExistsNetwork ban list contains whole network
+func (s *Ban) ExistsNetwork(network Network) (bool, error) {
+	return s.repository.Exists(network)
 }
 
-// Get ban info
+// Get ban info of entry which contains IP
 func (s *Ban) Get(ip net.IP) (*BanItem, error) {
+	return s.GetNetwork(NetworkFromIP(ip))
+}
 
-	item := BanItem{}
-	err := s.db.QueryRow(context.Background(), `
-		SELECT ip, until, reason, by_user_id
-		FROM ip_ban
-		WHERE ip = $1 AND until >= NOW()
-	`, ip).Scan(&item.IP, &item.Until, &item.Reason, &item.ByUserID)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil
-		}
+// GetNetwork ban info of most specific entry which contains network
+func (s *Ban) GetNetwork(network Network) (*BanItem, error) {
+	item, err := s.repository.Get(network)
+	if err != nil || item == nil {
+		return item, err
+	}
 
+	item.Offences, err = s.OffencesNetwork(item.IP)
+	if err != nil {
 		return nil, err
 	}
 
-	return &item, nil
+	return item, nil
 }
 
 // GC Garbage Collect
 func (s *Ban) GC() (int64, error) {
-	ct, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban WHERE until < NOW()")
-	if err != nil {
-		return 0, err
+	return s.repository.GC()
+}
+
+// GCHistory deletes offences older than clean period
+func (s *Ban) GCHistory() (int64, error) {
+	if s.cleanPeriod <= 0 {
+		return 0, nil
 	}
 
-	affected := ct.RowsAffected()
+	return s.repository.GCHistory(s.offencesSince())
+}
+
+// LastChange cursor of most recent change of bans
+func (s *Ban) LastChange() (int64, error) {
+	return s.repository.LastChange()
+}
+
+// Changes of bans recorded after cursor
+func (s *Ban) Changes(cursor int64, limit int) ([]BanChange, error) {
+	return s.repository.Changes(cursor, limit)
+}
 
-	return affected, nil
+// GCChanges deletes change log recorded before given time
+func (s *Ban) GCChanges(before time.Time) (int64, error) {
+	return s.repository.GCChanges(before)
 }
 
 // Clear removes all collected data
 func (s *Ban) Clear() error {
-	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban")
-
-	return err
+	return s.repository.Clear()
 }
@@ -0,0 +1,344 @@
+package traffic
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryBanRepository keeps bans in process memory
+type MemoryBanRepository struct {
+	mutex   sync.RWMutex
+	items   map[string]BanItem
+	history map[string][]BanHistoryItem
+	changes memoryChangeLog
+}
+
+// NewMemoryBanRepository constructor
+func NewMemoryBanRepository() *MemoryBanRepository {
+	return &MemoryBanRepository{
+		items:   make(map[string]BanItem),
+		history: make(map[string][]BanHistoryItem),
+	}
+}
+
+// Add ban or replace existing
+func (s *MemoryBanRepository) Add(network Network, until time.Time, byUserID int, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	item := BanItem{
+		IP:       network,
+		Until:    until,
+		ByUserID: byUserID,
+		Reason:   reason,
+	}
+
+	key := network.String()
+	s.items[key] = item
+	s.history[key] = append(s.history[key], BanHistoryItem{BanItem: item, CreatedAt: time.Now()})
+	s.changes.record(network)
+
+	return nil
+}
+
+// Remove bans of network and of its subnets
+func (s *MemoryBanRepository) Remove(network Network) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, item := range s.items {
+		if network.ContainsNetwork(item.IP) {
+			delete(s.items, key)
+			s.changes.record(item.IP)
+		}
+	}
+
+	return nil
+}
+
+// RemoveByReason deletes ban of exactly this network if it has given reason
+func (s *MemoryBanRepository) RemoveByReason(network Network, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := network.String()
+	if item, ok := s.items[key]; ok && item.Reason == reason {
+		delete(s.items, key)
+		s.changes.record(item.IP)
+	}
+
+	return nil
+}
+
+// ExtendByReason sets end of ban of exactly this network if it has given reason
+func (s *MemoryBanRepository) ExtendByReason(network Network, until time.Time, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := network.String()
+	if item, ok := s.items[key]; ok && item.Reason == reason {
+		item.Until = until
+		s.items[key] = item
+		s.changes.record(item.IP)
+	}
+
+	return nil
+}
+
+// ListByReason returns bans with given reason
+func (s *MemoryBanRepository) ListByReason(reason string) ([]BanItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]BanItem, 0)
+	for _, item := range s.items {
+		if item.Reason == reason {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// List returns bans which are not expired yet
+func (s *MemoryBanRepository) List() ([]BanItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	result := make([]BanItem, 0, len(s.items))
+	for _, item := range s.items {
+		if !item.Until.Before(now) {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// Find returns page of bans matching query and total number of matching bans
+func (s *MemoryBanRepository) Find(query BanListQuery) ([]BanItem, int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	matched := make([]BanItem, 0)
+	for _, item := range s.items {
+		if item.Until.Before(now) || !banMatches(item, query) {
+			continue
+		}
+		matched = append(matched, item)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return compareListKeys(query.Sort, banListKey(matched[i]), banListKey(matched[j])) < 0
+	})
+
+	result := make([]BanItem, 0, query.Limit)
+	for _, item := range matched {
+		if len(result) >= query.Limit {
+			break
+		}
+		if query.After != nil && compareListKeys(query.Sort, banListKey(item), *query.After) <= 0 {
+			continue
+		}
+		result = append(result, item)
+	}
+
+	return result, len(matched), nil
+}
+
+func banMatches(item BanItem, query BanListQuery) bool {
+	if len(query.Reason) > 0 && item.Reason != query.Reason {
+		return false
+	}
+
+	if query.ByUserID != nil && item.ByUserID != *query.ByUserID {
+		return false
+	}
+
+	if !query.ExpiresAfter.IsZero() && !item.Until.After(query.ExpiresAfter) {
+		return false
+	}
+
+	if !query.ExpiresBefore.IsZero() && !item.Until.Before(query.ExpiresBefore) {
+		return false
+	}
+
+	if query.Contains != nil && !item.IP.ContainsNetwork(*query.Contains) {
+		return false
+	}
+
+	if query.Within != nil && !query.Within.ContainsNetwork(item.IP) {
+		return false
+	}
+
+	return true
+}
+
+// Exists ban list already contains network
+func (s *MemoryBanRepository) Exists(network Network) (bool, error) {
+	item, err := s.Get(network)
+	if err != nil {
+		return false, err
+	}
+
+	return item != nil, nil
+}
+
+// Get most specific ban which contains network
+func (s *MemoryBanRepository) Get(network Network) (*BanItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	var result *BanItem
+	resultOnes := -1
+	for _, item := range s.items {
+		if item.Until.Before(now) || !item.IP.ContainsNetwork(network) {
+			continue
+		}
+
+		ones, _ := item.IP.Mask.Size()
+		if ones > resultOnes {
+			match := item
+			result = &match
+			resultOnes = ones
+		}
+	}
+
+	return result, nil
+}
+
+// Offences counts bans of network recorded since given time
+func (s *MemoryBanRepository) Offences(network Network, since time.Time) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	count := 0
+	for _, item := range s.history[network.String()] {
+		if item.CreatedAt.After(since) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// History returns up to limit recorded bans of networks which contain network, most recent first
+func (s *MemoryBanRepository) History(network Network, limit int) ([]BanHistoryItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]BanHistoryItem, 0)
+	for _, items := range s.history {
+		for _, item := range items {
+			if item.IP.ContainsNetwork(network) {
+				result = append(result, item)
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// GC Garbage Collect
+func (s *MemoryBanRepository) GC() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	var affected int64
+	for key, item := range s.items {
+		if item.Until.Before(now) {
+			delete(s.items, key)
+			s.changes.record(item.IP)
+			affected++
+		}
+	}
+
+	return affected, nil
+}
+
+// GCHistory deletes offence history recorded before given time
+func (s *MemoryBanRepository) GCHistory(before time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var affected int64
+	for key, items := range s.history {
+		kept := items[:0]
+		for _, item := range items {
+			if item.CreatedAt.Before(before) {
+				affected++
+				continue
+			}
+			kept = append(kept, item)
+		}
+
+		if len(kept) == 0 {
+			delete(s.history, key)
+		} else {
+			s.history[key] = kept
+		}
+	}
+
+	return affected, nil
+}
+
+// Clear removes all collected data
+func (s *MemoryBanRepository) Clear() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, item := range s.items {
+		s.changes.record(item.IP)
+	}
+
+	s.items = make(map[string]BanItem)
+	s.history = make(map[string][]BanHistoryItem)
+
+	return nil
+}
+
+// LastChange returns cursor of most recent change
+func (s *MemoryBanRepository) LastChange() (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.changes.last, nil
+}
+
+// Changes returns up to limit changes recorded after cursor
+func (s *MemoryBanRepository) Changes(cursor int64, limit int) ([]BanChange, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entries := s.changes.since(cursor, limit)
+	result := make([]BanChange, len(entries))
+	for idx, entry := range entries {
+		result[idx] = BanChange{ID: entry.id, IP: entry.network}
+		if item, ok := s.items[entry.network.String()]; ok {
+			result[idx].Item = &item
+		}
+	}
+
+	return result, nil
+}
+
+// GCChanges deletes changes recorded before given time
+func (s *MemoryBanRepository) GCChanges(before time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.changes.gc(before), nil
+}
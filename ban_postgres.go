@@ -0,0 +1,347 @@
+package traffic
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PostgresBanRepository stores bans in ip_ban table
+type PostgresBanRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresBanRepository constructor
+func NewPostgresBanRepository(db *pgxpool.Pool) *PostgresBanRepository {
+	return &PostgresBanRepository{
+		db: db,
+	}
+}
+
+// Add ban or replace existing
+func (s *PostgresBanRepository) Add(network Network, until time.Time, byUserID int, reason string) error {
+	_, err := s.db.Exec(context.Background(), `
+		WITH history AS (
+			INSERT INTO ip_ban_history (ip, until, by_user_id, reason)
+			VALUES ($1, $2, $3, $4)
+		)
+		INSERT INTO ip_ban (ip, until, by_user_id, reason)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT(ip) DO UPDATE SET until=EXCLUDED.until, by_user_id=EXCLUDED.by_user_id, reason=EXCLUDED.reason
+	`, network, until, byUserID, reason)
+
+	return err
+}
+
+// Remove bans of network and of its subnets
+func (s *PostgresBanRepository) Remove(network Network) error {
+	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban WHERE ip <<= $1", network)
+
+	return err
+}
+
+// RemoveByReason deletes ban of exactly this network if it has given reason
+func (s *PostgresBanRepository) RemoveByReason(network Network, reason string) error {
+	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban WHERE ip = $1 AND reason = $2", network, reason)
+
+	return err
+}
+
+// ExtendByReason sets end of ban of exactly this network if it has given reason
+func (s *PostgresBanRepository) ExtendByReason(network Network, until time.Time, reason string) error {
+	_, err := s.db.Exec(
+		context.Background(),
+		"UPDATE ip_ban SET until = $2 WHERE ip = $1 AND reason = $3",
+		network, until, reason,
+	)
+
+	return err
+}
+
+// ListByReason returns bans with given reason
+func (s *PostgresBanRepository) ListByReason(reason string) ([]BanItem, error) {
+	rows, err := s.db.Query(context.Background(), `
+		SELECT ip, until, reason, by_user_id
+		FROM ip_ban
+		WHERE reason = $1
+	`, reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]BanItem, 0)
+	for rows.Next() {
+		var item BanItem
+		if err := rows.Scan(&item.IP.IPNet, &item.Until, &item.Reason, &item.ByUserID); err != nil {
+			return nil, err
+		}
+
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+// List returns bans which are not expired yet
+func (s *PostgresBanRepository) List() ([]BanItem, error) {
+	rows, err := s.db.Query(context.Background(), `
+		SELECT ip, until, reason, by_user_id
+		FROM ip_ban
+		WHERE until >= NOW()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]BanItem, 0)
+	for rows.Next() {
+		var item BanItem
+		if err := rows.Scan(&item.IP.IPNet, &item.Until, &item.Reason, &item.ByUserID); err != nil {
+			return nil, err
+		}
+
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+// Find returns page of bans matching query and total number of matching bans
+func (s *PostgresBanRepository) Find(query BanListQuery) ([]BanItem, int, error) {
+	filter := postgresFilter{}
+	filter.where("until >= NOW()")
+
+	if len(query.Reason) > 0 {
+		filter.where("reason = " + filter.arg(query.Reason))
+	}
+
+	if query.ByUserID != nil {
+		filter.where("by_user_id = " + filter.arg(*query.ByUserID))
+	}
+
+	if !query.ExpiresAfter.IsZero() {
+		filter.where("until > " + filter.arg(query.ExpiresAfter))
+	}
+
+	if !query.ExpiresBefore.IsZero() {
+		filter.where("until < " + filter.arg(query.ExpiresBefore))
+	}
+
+	if query.Contains != nil {
+		filter.where("ip >>= " + filter.arg(*query.Contains))
+	}
+
+	if query.Within != nil {
+		filter.where("ip <<= " + filter.arg(*query.Within))
+	}
+
+	var total int
+	err := s.db.QueryRow(context.Background(), "SELECT COUNT(1) FROM ip_ban "+filter.sql(), filter.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filter.after(query.Sort, "until", query.After)
+
+	rows, err := s.db.Query(context.Background(), `
+		SELECT ip, until, reason, by_user_id
+		FROM ip_ban
+		`+filter.sql()+`
+		`+postgresOrderBy(query.Sort, "until")+`
+		LIMIT `+filter.arg(query.Limit), filter.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := make([]BanItem, 0)
+	for rows.Next() {
+		var item BanItem
+		if err := rows.Scan(&item.IP.IPNet, &item.Until, &item.Reason, &item.ByUserID); err != nil {
+			return nil, 0, err
+		}
+
+		result = append(result, item)
+	}
+
+	return result, total, rows.Err()
+}
+
+// Exists ban list already contains network
+func (s *PostgresBanRepository) Exists(network Network) (bool, error) {
+
+	var exists bool
+	err := s.db.QueryRow(context.Background(), `
+		SELECT true
+		FROM ip_ban
+		WHERE ip >>= $1 AND until >= NOW()
+		LIMIT 1
+	`, network).Scan(&exists)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return false, err
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Get most specific ban which contains network
+func (s *PostgresBanRepository) Get(network Network) (*BanItem, error) {
+
+	item := BanItem{}
+	err := s.db.QueryRow(context.Background(), `
+		SELECT ip, until, reason, by_user_id
+		FROM ip_ban
+		WHERE ip >>= $1 AND until >= NOW()
+		ORDER BY masklen(ip) DESC
+		LIMIT 1
+	`, network).Scan(&item.IP.IPNet, &item.Until, &item.Reason, &item.ByUserID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// History returns up to limit recorded bans of networks which contain network, most recent first
+func (s *PostgresBanRepository) History(network Network, limit int) ([]BanHistoryItem, error) {
+	rows, err := s.db.Query(context.Background(), `
+		SELECT ip, until, reason, COALESCE(by_user_id, 0), created_at
+		FROM ip_ban_history
+		WHERE ip >>= $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, network, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]BanHistoryItem, 0)
+	for rows.Next() {
+		var item BanHistoryItem
+		if err := rows.Scan(&item.IP.IPNet, &item.Until, &item.Reason, &item.ByUserID, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+// Offences counts bans of IP recorded since given time
+func (s *PostgresBanRepository) Offences(network Network, since time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRow(context.Background(), `
+		SELECT COUNT(1)
+		FROM ip_ban_history
+		WHERE ip = $1 AND created_at > $2
+	`, network, since).Scan(&count)
+
+	return count, err
+}
+
+// GC Garbage Collect
+func (s *PostgresBanRepository) GC() (int64, error) {
+	ct, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban WHERE until < NOW()")
+	if err != nil {
+		return 0, err
+	}
+
+	affected := ct.RowsAffected()
+
+	return affected, nil
+}
+
+// GCHistory deletes offence history recorded before given time
+func (s *PostgresBanRepository) GCHistory(before time.Time) (int64, error) {
+	ct, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban_history WHERE created_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return ct.RowsAffected(), nil
+}
+
+// Clear removes all collected data
+func (s *PostgresBanRepository) Clear() error {
+	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(context.Background(), "DELETE FROM ip_ban_history")
+
+	return err
+}
+
+// LastChange returns cursor of most recent change
+func (s *PostgresBanRepository) LastChange() (int64, error) {
+	var cursor int64
+	err := s.db.QueryRow(context.Background(), "SELECT COALESCE(MAX(id), 0) FROM ip_ban_change").Scan(&cursor)
+
+	return cursor, err
+}
+
+// Changes returns up to limit changes recorded after cursor joined with current ban of network
+func (s *PostgresBanRepository) Changes(cursor int64, limit int) ([]BanChange, error) {
+	rows, err := s.db.Query(context.Background(), `
+		SELECT c.id, c.ip, b.until, b.reason, b.by_user_id
+		FROM ip_ban_change c
+			LEFT JOIN ip_ban b ON b.ip = c.ip
+		WHERE c.id > $1
+		ORDER BY c.id
+		LIMIT $2
+	`, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]BanChange, 0)
+	for rows.Next() {
+		var change BanChange
+		var until *time.Time
+		var reason *string
+		var byUserID *int
+		if err := rows.Scan(&change.ID, &change.IP.IPNet, &until, &reason, &byUserID); err != nil {
+			return nil, err
+		}
+
+		if until != nil {
+			change.Item = &BanItem{IP: change.IP, Until: *until}
+			if reason != nil {
+				change.Item.Reason = *reason
+			}
+			if byUserID != nil {
+				change.Item.ByUserID = *byUserID
+			}
+		}
+
+		result = append(result, change)
+	}
+
+	return result, rows.Err()
+}
+
+// GCChanges deletes changes recorded before given time
+func (s *PostgresBanRepository) GCChanges(before time.Time) (int64, error) {
+	ct, err := s.db.Exec(context.Background(), "DELETE FROM ip_ban_change WHERE created_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return ct.RowsAffected(), nil
+}
@@ -18,7 +18,7 @@ func createBanService(t *testing.T) *Ban {
 
 	logger := util.NewLogger(config.Sentry)
 
-	s, err := NewBan(pool, logger)
+	s, err := NewBan(NewPostgresBanRepository(pool), logger)
 	require.NoError(t, err)
 
 	return s
@@ -44,3 +44,137 @@ func TestAddRemove(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, exists)
 }
+
+func TestMemoryBanExpiry(t *testing.T) {
+
+	s, err := NewBan(NewMemoryBanRepository(), util.NewLogger(util.SentryConfig{}))
+	require.NoError(t, err)
+
+	ip := net.IPv4(66, 249, 73, 139)
+
+	err = s.Add(ip, time.Hour, 1, " Test ")
+	require.NoError(t, err)
+
+	item, err := s.Get(ip)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	require.Equal(t, "Test", item.Reason)
+
+	err = s.Add(ip, -time.Hour, 1, "Test")
+	require.NoError(t, err)
+
+	exists, err := s.Exists(ip)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	affected, err := s.GC()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), affected)
+}
+
+func testBanFind(t *testing.T, s *Ban) {
+	require.NoError(t, s.Clear())
+
+	require.NoError(t, s.AddNetwork(mustParseNetwork(t, "192.0.2.0/24"), time.Hour, 1, "Test"))
+	require.NoError(t, s.AddNetwork(mustParseNetwork(t, "192.0.2.1"), 3*time.Hour, 2, "Test"))
+	require.NoError(t, s.AddNetwork(mustParseNetwork(t, "198.51.100.1"), 2*time.Hour, 1, "Other"))
+	require.NoError(t, s.AddNetwork(mustParseNetwork(t, "2001:db8::1"), 4*time.Hour, 1, "Test"))
+	require.NoError(t, s.AddNetwork(mustParseNetwork(t, "203.0.113.1"), -time.Hour, 1, "Test"))
+
+	find := func(query BanListQuery) ([]string, BanListPage) {
+		page, err := s.Find(query)
+		require.NoError(t, err)
+
+		result := make([]string, len(page.Items))
+		for idx, item := range page.Items {
+			result[idx] = item.IP.String()
+		}
+
+		return result, page
+	}
+
+	ips, page := find(BanListQuery{})
+	require.Equal(t, []string{"192.0.2.0/24", "192.0.2.1", "198.51.100.1", "2001:db8::1"}, ips)
+	require.Equal(t, 4, page.Total)
+	require.Empty(t, page.NextCursor)
+
+	// pages by end of ban
+	query := BanListQuery{Sort: ListSort{Field: BanSortUpTo, Desc: true}, Limit: 3}
+	ips, page = find(query)
+	require.Equal(t, []string{"2001:db8::1", "192.0.2.1", "198.51.100.1"}, ips)
+	require.Equal(t, 4, page.Total)
+	require.NotEmpty(t, page.NextCursor)
+
+	query.After, _ = ParseListCursor(page.NextCursor)
+	ips, page = find(query)
+	require.Equal(t, []string{"192.0.2.0/24"}, ips)
+	require.Equal(t, 4, page.Total)
+	require.Empty(t, page.NextCursor)
+
+	ips, page = find(BanListQuery{Sort: ListSort{Field: ListSortIP}, Limit: 2})
+	require.Equal(t, []string{"192.0.2.0/24", "192.0.2.1"}, ips)
+	after, _ := ParseListCursor(page.NextCursor)
+	ips, _ = find(BanListQuery{Sort: ListSort{Field: ListSortIP}, Limit: 2, After: after})
+	require.Equal(t, []string{"198.51.100.1", "2001:db8::1"}, ips)
+
+	ips, page = find(BanListQuery{Reason: "Test"})
+	require.Equal(t, []string{"192.0.2.0/24", "192.0.2.1", "2001:db8::1"}, ips)
+	require.Equal(t, 3, page.Total)
+
+	byUserID := 2
+	ips, _ = find(BanListQuery{ByUserID: &byUserID})
+	require.Equal(t, []string{"192.0.2.1"}, ips)
+
+	ips, _ = find(BanListQuery{
+		ExpiresAfter:  time.Now().Add(90 * time.Minute),
+		ExpiresBefore: time.Now().Add(150 * time.Minute),
+	})
+	require.Equal(t, []string{"198.51.100.1"}, ips)
+
+	contains := mustParseNetwork(t, "192.0.2.1")
+	ips, _ = find(BanListQuery{Contains: &contains})
+	require.Equal(t, []string{"192.0.2.0/24", "192.0.2.1"}, ips)
+
+	within := mustParseNetwork(t, "192.0.0.0/16")
+	ips, _ = find(BanListQuery{Within: &within})
+	require.Equal(t, []string{"192.0.2.0/24", "192.0.2.1"}, ips)
+
+	within = mustParseNetwork(t, "2001:db8::/32")
+	ips, _ = find(BanListQuery{Within: &within})
+	require.Equal(t, []string{"2001:db8::1"}, ips)
+}
+
+func TestMemoryBanMinPrefix(t *testing.T) {
+
+	s, err := NewBan(NewMemoryBanRepository(), util.NewLogger(util.SentryConfig{}))
+	require.NoError(t, err)
+	s.SetMinPrefixes(8, 32)
+
+	for _, value := range []string{"0.0.0.0/0", "10.0.0.0/7", "::/0", "2001:db8::/31"} {
+		network, err := ParseNetwork(value)
+		require.NoError(t, err)
+		require.Error(t, s.CheckNetwork(network), value)
+		require.Error(t, s.AddNetwork(network, time.Hour, 1, "Test"), value)
+	}
+
+	for _, value := range []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "2001:db8::1"} {
+		network, err := ParseNetwork(value)
+		require.NoError(t, err)
+		require.NoError(t, s.AddNetwork(network, time.Hour, 1, "Test"), value)
+	}
+
+	exists, err := s.Exists(net.IPv4(10, 1, 2, 3))
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestBanFind(t *testing.T) {
+	testBanFind(t, createBanService(t))
+}
+
+func TestMemoryBanFind(t *testing.T) {
+	s, err := NewBan(NewMemoryBanRepository(), util.NewLogger(util.SentryConfig{}))
+	require.NoError(t, err)
+
+	testBanFind(t, s)
+}
@@ -0,0 +1,130 @@
+package traffic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// BlocklistImportResult BlocklistImportResult
+type BlocklistImportResult struct {
+	Added  int `json:"added"`
+	Lifted int `json:"lifted"`
+	Total  int `json:"total"`
+}
+
+// ParseBlocklist parses list of one IP or CIDR per line. Text after # or ; is comment,
+// so Spamhaus DROP (`cidr ; SBLnnn`) and FireHOL netset files are read as well
+func ParseBlocklist(r io.Reader) ([]Network, error) {
+	result := make([]Network, 0)
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := scanner.Text()
+		if idx := strings.IndexAny(text, "#;"); idx >= 0 {
+			text = text[:idx]
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		network, err := ParseNetwork(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		result = append(result, network)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Import bans networks of list for duration with name of list as reason
+// and lifts bans of the list which are not listed anymore
+func (s *Ban) Import(name string, networks []Network, duration time.Duration) (BlocklistImportResult, error) {
+	result := BlocklistImportResult{}
+
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return result, fmt.Errorf("name of list is required")
+	}
+
+	if duration <= 0 {
+		return result, fmt.Errorf("duration must be positive")
+	}
+
+	for _, network := range networks {
+		if err := s.CheckNetwork(network); err != nil {
+			return result, err
+		}
+	}
+
+	items, err := s.repository.ListByReason(name)
+	if err != nil {
+		return result, err
+	}
+
+	existing := make(map[string]bool, len(items))
+	for _, item := range items {
+		existing[item.IP.String()] = true
+	}
+
+	until := time.Now().Add(duration)
+	listed := make(map[string]bool, len(networks))
+	for _, network := range networks {
+		key := network.String()
+		if listed[key] {
+			continue
+		}
+		listed[key] = true
+
+		if !existing[key] {
+			// ban of exactly this network from other origin is not taken over
+			item, err := s.repository.Get(network)
+			if err != nil {
+				return result, err
+			}
+			if item != nil && item.IP.String() == key {
+				continue
+			}
+		}
+
+		// listed bans are extended on each import, only new ones are recorded as offences
+		if existing[key] {
+			if err := s.repository.ExtendByReason(network, until, name); err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		if err := s.repository.Add(network, until, banByUserID, name); err != nil {
+			return result, err
+		}
+		result.Added++
+	}
+	result.Total = len(listed)
+
+	for _, item := range items {
+		if listed[item.IP.String()] {
+			continue
+		}
+
+		if err := s.repository.RemoveByReason(item.IP, name); err != nil {
+			return result, err
+		}
+		result.Lifted++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,130 @@
+package traffic
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBlocklist(t *testing.T) {
+
+	plain := "# plain list\n192.0.2.1\n\n198.51.100.0/24 # comment\n2001:db8::/32\n"
+	networks, err := ParseBlocklist(strings.NewReader(plain))
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+	require.Equal(t, "192.0.2.1", networks[0].String())
+	require.Equal(t, "198.51.100.0/24", networks[1].String())
+	require.Equal(t, "2001:db8::/32", networks[2].String())
+
+	drop := "; Spamhaus DROP List 2021/01/20 - (c) 2021 The Spamhaus Project\n" +
+		"; Last-Modified: Wed, 20 Jan 2021 07:19:51 GMT\n" +
+		"1.10.16.0/20 ; SBL256894\n" +
+		"1.19.0.0/16 ; SBL434604\n"
+	networks, err = ParseBlocklist(strings.NewReader(drop))
+	require.NoError(t, err)
+	require.Len(t, networks, 2)
+	require.Equal(t, "1.10.16.0/20", networks[0].String())
+
+	netset := "#\n# firehol_level1\n#\n# List source URL\t: https://iplists.firehol.org\n#\n0.0.0.0/8\n1.19.0.0/16\n5.79.71.205\n"
+	networks, err = ParseBlocklist(strings.NewReader(netset))
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+	require.Equal(t, "5.79.71.205", networks[2].String())
+
+	_, err = ParseBlocklist(strings.NewReader("192.0.2.1\nnot-an-ip\n"))
+	require.EqualError(t, err, "line 2: invalid IP `not-an-ip`")
+}
+
+func TestMemoryBlocklistImport(t *testing.T) {
+
+	s := createMemoryTrafficService(t)
+
+	listed, err := ParseBlocklist(strings.NewReader("192.0.2.0/24\n198.51.100.0/24\n203.0.113.7\n"))
+	require.NoError(t, err)
+
+	// ban of another origin
+	manual, err := ParseNetwork("198.51.100.0/24")
+	require.NoError(t, err)
+	require.NoError(t, s.Ban.AddNetwork(manual, time.Hour, 1, "Manual"))
+
+	result, err := s.Ban.Import("drop", listed, 48*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, BlocklistImportResult{Added: 2, Lifted: 0, Total: 3}, result)
+
+	item, err := s.Ban.GetNetwork(manual)
+	require.NoError(t, err)
+	require.Equal(t, "Manual", item.Reason)
+
+	item, err = s.Ban.Get(net.IPv4(192, 0, 2, 10))
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	require.Equal(t, "drop", item.Reason)
+	require.WithinDuration(t, time.Now().Add(48*time.Hour), item.Until, time.Minute)
+
+	// autoban inside of listed network
+	require.NoError(t, s.Ban.Add(net.IPv4(192, 0, 2, 10), time.Hour, banByUserID, "min limit"))
+
+	result, err = s.Ban.Import("drop", listed[1:], 48*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, BlocklistImportResult{Added: 0, Lifted: 1, Total: 2}, result)
+
+	item, err = s.Ban.GetNetwork(manual)
+	require.NoError(t, err)
+	require.Equal(t, "Manual", item.Reason)
+
+	exists, err := s.Ban.Exists(net.IPv4(192, 0, 2, 11))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	item, err = s.Ban.Get(net.IPv4(192, 0, 2, 10))
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	require.Equal(t, "min limit", item.Reason)
+
+	exists, err = s.Ban.Exists(net.IPv4(203, 0, 113, 7))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	_, err = s.Ban.Import(" ", listed, time.Hour)
+	require.Error(t, err)
+
+	_, err = s.Ban.Import("drop", listed, 0)
+	require.Error(t, err)
+
+	// list with too wide network is rejected as a whole
+	wide, err := ParseBlocklist(strings.NewReader("198.18.0.0/15\n0.0.0.0/0\n"))
+	require.NoError(t, err)
+	_, err = s.Ban.Import("drop", wide, time.Hour)
+	require.Error(t, err)
+
+	exists, err = s.Ban.Exists(net.IPv4(198, 18, 0, 1))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestMemoryBlocklistReimportKeepsOffences(t *testing.T) {
+
+	s := createMemoryTrafficService(t)
+
+	listed, err := ParseBlocklist(strings.NewReader("192.0.2.0/24\n"))
+	require.NoError(t, err)
+
+	_, err = s.Ban.Import("drop", listed, time.Hour)
+	require.NoError(t, err)
+
+	result, err := s.Ban.Import("drop", listed, 48*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, BlocklistImportResult{Added: 0, Lifted: 0, Total: 1}, result)
+
+	offences, err := s.Ban.OffencesNetwork(listed[0])
+	require.NoError(t, err)
+	require.Equal(t, 1, offences)
+
+	// ban is extended by import
+	item, err := s.Ban.GetNetwork(listed[0])
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(48*time.Hour), item.Until, time.Minute)
+}
@@ -0,0 +1,149 @@
+package traffic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/autowp/traffic/util"
+	"github.com/jackc/pgx/v4"
+)
+
+// changeChannel postgres channel notified by triggers of change log
+const changeChannel = "ip_change"
+
+// ChangeNotification payload of change log notification
+type ChangeNotification struct {
+	// Table ip_ban or ip_whitelist
+	Table string `json:"table"`
+	// ID of change log entry
+	ID int64   `json:"id"`
+	IP Network `json:"ip"`
+}
+
+// ChangeObserver applies changes of bans and whitelist to local state
+type ChangeObserver interface {
+	// Resync reloads whole local state. Called after each connection of listener,
+	// because notifications sent while listener is disconnected are lost
+	Resync() error
+	// Notify applies single change
+	Notify(notification ChangeNotification)
+}
+
+// PGConnector opens dedicated connection to postgres
+type PGConnector func(ctx context.Context) (*pgx.Conn, error)
+
+// ChangeListener subscribes to notifications of change log with postgres LISTEN and passes them to observers
+type ChangeListener struct {
+	connect   PGConnector
+	logger    *util.Logger
+	mutex     sync.RWMutex
+	observers []ChangeObserver
+}
+
+// NewChangeListener constructor
+func NewChangeListener(connect PGConnector, logger *util.Logger) *ChangeListener {
+	return &ChangeListener{
+		connect: connect,
+		logger:  logger,
+	}
+}
+
+// AddObserver registers observer of changes
+func (s *ChangeListener) AddObserver(observer ChangeObserver) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.observers = append(s.observers, observer)
+}
+
+func (s *ChangeListener) getObservers() []ChangeObserver {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.observers
+}
+
+// Listen for notifications until quit.
+// Connection is re-established with exponential backoff when it is lost, observers are resynced after it
+func (s *ChangeListener) Listen(config NotifyConfig, quitChan chan bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-quitChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	delay := config.ReconnectDelay
+	for {
+		err := s.listen(ctx, func() {
+			delay = config.ReconnectDelay
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		s.logger.Warningf("change listener: %v, reconnect in %v", err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+
+		delay = nextReconnectDelay(delay, config.ReconnectMaxDelay)
+	}
+}
+
+// listen waits for notifications on single connection until it fails or ctx is done
+func (s *ChangeListener) listen(ctx context.Context, onConnected func()) error {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := conn.Close(context.Background())
+		if err != nil {
+			s.logger.Warning(err)
+		}
+	}()
+
+	_, err = conn.Exec(ctx, "LISTEN "+changeChannel)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Change listener connected")
+	onConnected()
+
+	for _, observer := range s.getObservers() {
+		err := observer.Resync()
+		if err != nil {
+			s.logger.Warning(err)
+		}
+	}
+
+	for {
+		pgNotification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var notification ChangeNotification
+		err = json.Unmarshal([]byte(pgNotification.Payload), &notification)
+		if err != nil {
+			s.logger.Warning(err)
+			continue
+		}
+
+		for _, observer := range s.getObservers() {
+			observer.Notify(notification)
+		}
+	}
+}
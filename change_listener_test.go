@@ -0,0 +1,83 @@
+package traffic
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/autowp/traffic/util"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingObserver struct {
+	mutex         sync.Mutex
+	resyncs       int
+	notifications []ChangeNotification
+}
+
+func (o *recordingObserver) Resync() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.resyncs++
+
+	return nil
+}
+
+func (o *recordingObserver) Notify(notification ChangeNotification) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.notifications = append(o.notifications, notification)
+}
+
+func (o *recordingObserver) state() (int, []ChangeNotification) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.resyncs, append([]ChangeNotification(nil), o.notifications...)
+}
+
+func TestChangeListener(t *testing.T) {
+	config := LoadConfig()
+
+	s := createTrafficService(t)
+
+	listener := NewChangeListener(func(ctx context.Context) (*pgx.Conn, error) {
+		return pgx.Connect(ctx, config.DSN)
+	}, util.NewLogger(config.Sentry))
+	observer := &recordingObserver{}
+	listener.AddObserver(observer)
+
+	quit := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		err := listener.Listen(config.Notify, quit)
+		require.NoError(t, err)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		resyncs, _ := observer.state()
+		return resyncs == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	network := mustParseNetwork(t, "192.0.2.77")
+	require.NoError(t, s.Ban.AddNetwork(network, time.Hour, 1, "Test"))
+	require.NoError(t, s.Ban.RemoveNetwork(network))
+
+	require.Eventually(t, func() bool {
+		_, notifications := observer.state()
+		return len(notifications) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, notifications := observer.state()
+	require.Equal(t, "ip_ban", notifications[0].Table)
+	require.Equal(t, network.String(), notifications[0].IP.String())
+	require.Less(t, notifications[0].ID, notifications[1].ID)
+
+	close(quit)
+	<-done
+}
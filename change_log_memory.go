@@ -0,0 +1,52 @@
+package traffic
+
+import (
+	"time"
+)
+
+type memoryChange struct {
+	id        int64
+	network   Network
+	createdAt time.Time
+}
+
+// memoryChangeLog changed networks of memory repository. Guarded by mutex of repository
+type memoryChangeLog struct {
+	last    int64
+	entries []memoryChange
+}
+
+func (l *memoryChangeLog) record(network Network) {
+	l.last++
+	l.entries = append(l.entries, memoryChange{
+		id:        l.last,
+		network:   network,
+		createdAt: time.Now(),
+	})
+}
+
+// since returns up to limit changes recorded after cursor
+func (l *memoryChangeLog) since(cursor int64, limit int) []memoryChange {
+	idx := 0
+	for idx < len(l.entries) && l.entries[idx].id <= cursor {
+		idx++
+	}
+
+	result := l.entries[idx:]
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result
+}
+
+func (l *memoryChangeLog) gc(before time.Time) int64 {
+	idx := 0
+	for idx < len(l.entries) && l.entries[idx].createdAt.Before(before) {
+		idx++
+	}
+
+	l.entries = append([]memoryChange(nil), l.entries[idx:]...)
+
+	return int64(idx)
+}
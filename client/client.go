@@ -0,0 +1,338 @@
+// Package client enforces bans of traffic service inside of Go services.
+// Client keeps local copy of bans and whitelist, loaded from `GET /sync` and updated
+// from `GET /sync/changes`, so checks are answered without network hop.
+// Package does not depend on the service package, so it can be imported by any service
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// changesLimit number of changes of each list requested at once
+const changesLimit = 1000
+
+// ErrNotReady local copy is not loaded yet or is not refreshed for longer than Config.MaxStaleness
+var ErrNotReady = errors.New("ban list is not available")
+
+// Ban active ban of network
+type Ban struct {
+	IP       string    `json:"ip"`
+	Until    time.Time `json:"up_to"`
+	ByUserID int       `json:"by_user_id"`
+	Reason   string    `json:"reason"`
+}
+
+// WhitelistItem whitelisted network
+type WhitelistItem struct {
+	IP          string     `json:"ip"`
+	Description string     `json:"description"`
+	Source      string     `json:"source"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type state struct {
+	BanCursor       int64           `json:"ban_cursor"`
+	WhitelistCursor int64           `json:"whitelist_cursor"`
+	Bans            []Ban           `json:"bans"`
+	Whitelist       []WhitelistItem `json:"whitelist"`
+}
+
+type banChange struct {
+	ID   int64  `json:"id"`
+	IP   string `json:"ip"`
+	Item *Ban   `json:"item"`
+}
+
+type whitelistChange struct {
+	ID   int64          `json:"id"`
+	IP   string         `json:"ip"`
+	Item *WhitelistItem `json:"item"`
+}
+
+type changes struct {
+	BanCursor       int64             `json:"ban_cursor"`
+	WhitelistCursor int64             `json:"whitelist_cursor"`
+	Bans            []banChange       `json:"bans"`
+	Whitelist       []whitelistChange `json:"whitelist"`
+}
+
+// Config Config
+type Config struct {
+	// URL of traffic service, e.g. http://traffic:8080
+	URL string
+	// HTTPClient used for requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// RefreshInterval how often changes are requested, 5s when zero
+	RefreshInterval time.Duration
+	// ResyncInterval how often whole lists are reloaded, 10m when zero
+	ResyncInterval time.Duration
+	// MaxStaleness after which local copy is not trusted without successful refresh, zero means forever
+	MaxStaleness time.Duration
+	// OnError receives errors of background sync
+	OnError func(err error)
+}
+
+// Client local copy of bans and whitelist of traffic service
+type Client struct {
+	config Config
+
+	// syncMutex serializes loads and refreshes, it guards cursors
+	syncMutex       sync.Mutex
+	banCursor       int64
+	whitelistCursor int64
+
+	mutex      sync.RWMutex
+	loaded     bool
+	syncedAt   time.Time
+	bans       map[string]Ban
+	banSet     *prefixSet
+	whitelist  map[string]WhitelistItem
+	allowedSet *prefixSet
+}
+
+// New constructor
+func New(config Config) (*Client, error) {
+	if _, err := url.Parse(config.URL); err != nil || config.URL == "" {
+		return nil, fmt.Errorf("invalid url `%s`", config.URL)
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 5 * time.Second
+	}
+
+	if config.ResyncInterval <= 0 {
+		config.ResyncInterval = 10 * time.Minute
+	}
+
+	return &Client{
+		config:     config,
+		bans:       make(map[string]Ban),
+		banSet:     newPrefixSet(),
+		whitelist:  make(map[string]WhitelistItem),
+		allowedSet: newPrefixSet(),
+	}, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, result interface{}) error {
+	endpoint := strings.TrimRight(c.config.URL, "/") + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %d", path, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Load replaces local copy with whole lists
+func (c *Client) Load(ctx context.Context) error {
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+
+	return c.load(ctx)
+}
+
+func (c *Client) load(ctx context.Context) error {
+	var result state
+	err := c.get(ctx, "/sync", nil, &result)
+	if err != nil {
+		return err
+	}
+
+	bans := make(map[string]Ban, len(result.Bans))
+	banSet := newPrefixSet()
+	for _, item := range result.Bans {
+		network, err := parseNetwork(item.IP)
+		if err != nil {
+			return err
+		}
+		bans[banSet.add(network)] = item
+	}
+
+	whitelist := make(map[string]WhitelistItem, len(result.Whitelist))
+	allowedSet := newPrefixSet()
+	for _, item := range result.Whitelist {
+		network, err := parseNetwork(item.IP)
+		if err != nil {
+			return err
+		}
+		whitelist[allowedSet.add(network)] = item
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.bans, c.banSet = bans, banSet
+	c.whitelist, c.allowedSet = whitelist, allowedSet
+	c.banCursor, c.whitelistCursor = result.BanCursor, result.WhitelistCursor
+	c.loaded = true
+	c.syncedAt = time.Now()
+
+	return nil
+}
+
+// Refresh applies changes made since previous load or refresh. Lists are loaded when they are not yet
+func (c *Client) Refresh(ctx context.Context) error {
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+
+	c.mutex.RLock()
+	loaded := c.loaded
+	c.mutex.RUnlock()
+
+	if !loaded {
+		return c.load(ctx)
+	}
+
+	for {
+		var result changes
+		err := c.get(ctx, "/sync/changes", url.Values{
+			"ban_cursor":       {strconv.FormatInt(c.banCursor, 10)},
+			"whitelist_cursor": {strconv.FormatInt(c.whitelistCursor, 10)},
+			"limit":            {strconv.Itoa(changesLimit)},
+		}, &result)
+		if err != nil {
+			return err
+		}
+
+		err = c.apply(result)
+		if err != nil {
+			return err
+		}
+
+		if len(result.Bans) < changesLimit && len(result.Whitelist) < changesLimit {
+			return nil
+		}
+	}
+}
+
+func (c *Client) apply(result changes) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, change := range result.Bans {
+		network, err := parseNetwork(change.IP)
+		if err != nil {
+			return err
+		}
+
+		if change.Item == nil {
+			delete(c.bans, c.banSet.remove(network))
+		} else {
+			c.bans[c.banSet.add(network)] = *change.Item
+		}
+	}
+
+	for _, change := range result.Whitelist {
+		network, err := parseNetwork(change.IP)
+		if err != nil {
+			return err
+		}
+
+		if change.Item == nil {
+			delete(c.whitelist, c.allowedSet.remove(network))
+		} else {
+			c.whitelist[c.allowedSet.add(network)] = *change.Item
+		}
+	}
+
+	c.banCursor, c.whitelistCursor = result.BanCursor, result.WhitelistCursor
+	c.syncedAt = time.Now()
+
+	return nil
+}
+
+// Check returns most specific ban which denies access of IP or nil when IP is allowed.
+// Whitelist takes precedence over bans. ErrNotReady is returned when local copy can not be trusted
+func (c *Client) Check(ip net.IP) (*Ban, error) {
+	if ip == nil {
+		return nil, fmt.Errorf("ip not provided")
+	}
+
+	now := time.Now()
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.loaded || (c.config.MaxStaleness > 0 && now.Sub(c.syncedAt) > c.config.MaxStaleness) {
+		return nil, ErrNotReady
+	}
+
+	whitelisted := false
+	c.allowedSet.lookup(ip, func(key string) bool {
+		item := c.whitelist[key]
+		whitelisted = item.ExpiresAt == nil || item.ExpiresAt.After(now)
+		return whitelisted
+	})
+	if whitelisted {
+		return nil, nil
+	}
+
+	var result *Ban
+	c.banSet.lookup(ip, func(key string) bool {
+		item := c.bans[key]
+		if item.Until.Before(now) {
+			return false
+		}
+
+		result = &item
+		return true
+	})
+
+	return result, nil
+}
+
+// Run loads lists and keeps them up to date until ctx is done
+func (c *Client) Run(ctx context.Context) {
+	refreshTicker := time.NewTicker(c.config.RefreshInterval)
+	defer refreshTicker.Stop()
+
+	resyncTicker := time.NewTicker(c.config.ResyncInterval)
+	defer resyncTicker.Stop()
+
+	c.report(c.Refresh(ctx))
+
+	for {
+		select {
+		case <-refreshTicker.C:
+			c.report(c.Refresh(ctx))
+		case <-resyncTicker.C:
+			c.report(c.Load(ctx))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Client) report(err error) {
+	if err != nil && c.config.OnError != nil {
+		c.config.OnError(err)
+	}
+}
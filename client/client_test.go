@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeService struct {
+	mutex   sync.Mutex
+	state   state
+	changes changes
+}
+
+func (f *fakeService) setChanges(value changes) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.changes = value
+}
+
+func (f *fakeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var body interface{}
+	switch r.URL.Path {
+	case "/sync":
+		body = f.state
+	case "/sync/changes":
+		body = f.changes
+		// changes are served once
+		f.changes = changes{BanCursor: f.changes.BanCursor, WhitelistCursor: f.changes.WhitelistCursor}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func startFakeService(t *testing.T, initial state) (*fakeService, *Client) {
+	service := &fakeService{state: initial}
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	c, err := New(Config{URL: server.URL})
+	require.NoError(t, err)
+
+	return service, c
+}
+
+func TestClientCheck(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Minute)
+
+	_, c := startFakeService(t, state{
+		BanCursor:       5,
+		WhitelistCursor: 3,
+		Bans: []Ban{
+			{IP: "192.0.2.0/24", Until: until, Reason: "subnet"},
+			{IP: "192.0.2.7", Until: until, Reason: "host"},
+			{IP: "192.0.2.8", Until: expired, Reason: "expired"},
+			{IP: "2001:db8::/32", Until: until, Reason: "ipv6"},
+		},
+		Whitelist: []WhitelistItem{
+			{IP: "192.0.2.10"},
+			{IP: "192.0.2.11", ExpiresAt: &expired},
+		},
+	})
+
+	_, err := c.Check(net.ParseIP("192.0.2.7"))
+	require.Equal(t, ErrNotReady, err)
+
+	require.NoError(t, c.Load(context.Background()))
+
+	check := func(ip string) string {
+		ban, err := c.Check(net.ParseIP(ip))
+		require.NoError(t, err)
+		if ban == nil {
+			return ""
+		}
+		return ban.Reason
+	}
+
+	require.Equal(t, "host", check("192.0.2.7"))
+	require.Equal(t, "subnet", check("192.0.2.8"))
+	require.Equal(t, "ipv6", check("2001:db8::1"))
+	require.Equal(t, "", check("192.0.2.10"))
+	require.Equal(t, "subnet", check("192.0.2.11"))
+	require.Equal(t, "", check("198.51.100.1"))
+}
+
+func TestClientRefresh(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+
+	service, c := startFakeService(t, state{
+		BanCursor: 1,
+		Bans:      []Ban{{IP: "192.0.2.1", Until: until, Reason: "test"}},
+	})
+
+	require.NoError(t, c.Refresh(context.Background()))
+	ban, err := c.Check(net.ParseIP("192.0.2.1"))
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+
+	service.setChanges(changes{
+		BanCursor:       3,
+		WhitelistCursor: 1,
+		Bans: []banChange{
+			{ID: 2, IP: "192.0.2.1"},
+			{ID: 3, IP: "198.51.100.0/24", Item: &Ban{IP: "198.51.100.0/24", Until: until, Reason: "test"}},
+		},
+		Whitelist: []whitelistChange{
+			{ID: 1, IP: "198.51.100.7", Item: &WhitelistItem{IP: "198.51.100.7"}},
+		},
+	})
+
+	require.NoError(t, c.Refresh(context.Background()))
+
+	ban, err = c.Check(net.ParseIP("192.0.2.1"))
+	require.NoError(t, err)
+	require.Nil(t, ban)
+
+	ban, err = c.Check(net.ParseIP("198.51.100.1"))
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+
+	ban, err = c.Check(net.ParseIP("198.51.100.7"))
+	require.NoError(t, err)
+	require.Nil(t, ban)
+
+	require.Equal(t, int64(3), c.banCursor)
+	require.Equal(t, int64(1), c.whitelistCursor)
+}
+
+func TestClientStale(t *testing.T) {
+	_, c := startFakeService(t, state{})
+	c.config.MaxStaleness = time.Minute
+
+	require.NoError(t, c.Load(context.Background()))
+	_, err := c.Check(net.ParseIP("192.0.2.1"))
+	require.NoError(t, err)
+
+	c.syncedAt = time.Now().Add(-2 * time.Minute)
+	_, err = c.Check(net.ParseIP("192.0.2.1"))
+	require.Equal(t, ErrNotReady, err)
+}
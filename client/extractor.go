@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPExtractor returns IP of client which made request, nil when it is unknown
+type IPExtractor func(r *http.Request) net.IP
+
+// RemoteAddr extractor of connection address, for services exposed without proxies
+func RemoteAddr(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return normalizeIP(net.ParseIP(host))
+}
+
+// TrustedProxies extractor for services behind proxies which append client address to header,
+// e.g. X-Forwarded-For or X-Real-IP. Header is taken into account only when connection comes from trusted proxy.
+// Addresses of header are walked from the right skipping trusted proxies, first untrusted one is client,
+// so addresses spoofed by client at the left are ignored
+func TrustedProxies(header string, proxies ...string) (IPExtractor, error) {
+	trusted := make([]*net.IPNet, len(proxies))
+	for idx, proxy := range proxies {
+		network, err := parseNetwork(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy: %v", err)
+		}
+		trusted[idx] = &network
+	}
+
+	isTrusted := func(ip net.IP) bool {
+		for _, network := range trusted {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return func(r *http.Request) net.IP {
+		ip := RemoteAddr(r)
+		if ip == nil || !isTrusted(ip) {
+			return ip
+		}
+
+		var addresses []string
+		for _, value := range r.Header.Values(header) {
+			addresses = append(addresses, strings.Split(value, ",")...)
+		}
+
+		for idx := len(addresses) - 1; idx >= 0; idx-- {
+			address := normalizeIP(net.ParseIP(strings.TrimSpace(addresses[idx])))
+			if address == nil {
+				// malformed entry can not be trusted, neither entries behind it
+				return ip
+			}
+
+			ip = address
+			if !isTrusted(ip) {
+				return ip
+			}
+		}
+
+		return ip
+	}, nil
+}
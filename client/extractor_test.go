@@ -0,0 +1,50 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteAddr(t *testing.T) {
+	r := &http.Request{RemoteAddr: "192.0.2.1:1234", Header: http.Header{}}
+	require.Equal(t, "192.0.2.1", RemoteAddr(r).String())
+
+	r.RemoteAddr = "[2001:db8::1]:443"
+	require.Equal(t, "2001:db8::1", RemoteAddr(r).String())
+
+	r.RemoteAddr = "@"
+	require.Nil(t, RemoteAddr(r))
+}
+
+func TestTrustedProxies(t *testing.T) {
+	extractor, err := TrustedProxies("X-Forwarded-For", "10.0.0.0/8", "2001:db8::1")
+	require.NoError(t, err)
+
+	request := func(remoteAddr string, forwardedFor ...string) *http.Request {
+		r := &http.Request{RemoteAddr: remoteAddr, Header: http.Header{}}
+		for _, value := range forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		return r
+	}
+
+	// header of untrusted connection is ignored
+	require.Equal(t, "192.0.2.1", extractor(request("192.0.2.1:1000", "198.51.100.1")).String())
+
+	require.Equal(t, "198.51.100.1", extractor(request("10.0.0.1:1000", "198.51.100.1")).String())
+
+	// spoofed addresses at the left are skipped
+	require.Equal(t, "198.51.100.1", extractor(request("10.0.0.1:1000", "203.0.113.9, 198.51.100.1, 10.0.0.2")).String())
+	require.Equal(t, "198.51.100.1", extractor(request("[2001:db8::1]:1000", "203.0.113.9", "198.51.100.1")).String())
+
+	// all hops are trusted
+	require.Equal(t, "10.0.0.3", extractor(request("10.0.0.1:1000", "10.0.0.3, 10.0.0.2")).String())
+
+	require.Equal(t, "10.0.0.1", extractor(request("10.0.0.1:1000", "garbage")).String())
+	require.Equal(t, "10.0.0.1", extractor(request("10.0.0.1:1000")).String())
+
+	_, err = TrustedProxies("X-Forwarded-For", "10.0.0.0/33")
+	require.Error(t, err)
+}
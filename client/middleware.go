@@ -0,0 +1,121 @@
+package client
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FailMode decides requests when ban list is not available or client IP is unknown
+type FailMode int
+
+const (
+	// FailOpen allows requests
+	FailOpen FailMode = iota
+	// FailClosed rejects requests with 503
+	FailClosed
+)
+
+// MiddlewareOptions MiddlewareOptions
+type MiddlewareOptions struct {
+	// ClientIP extractor, RemoteAddr when nil
+	ClientIP IPExtractor
+	FailMode FailMode
+	// Publisher receives message of each request with known IP when set.
+	// It is called by request goroutine, so it must not wait for broker, as AMQPPublisher does
+	Publisher Publisher
+	// OnError receives errors of checks and publishing
+	OnError func(err error)
+}
+
+type decision struct {
+	status     int
+	retryAfter int
+}
+
+func (d decision) write(w http.ResponseWriter) {
+	if d.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(d.retryAfter))
+	}
+	w.WriteHeader(d.status)
+}
+
+// decide returns decision of request, nil when it is allowed
+func (c *Client) decide(r *http.Request, options MiddlewareOptions) *decision {
+	extractor := options.ClientIP
+	if extractor == nil {
+		extractor = RemoteAddr
+	}
+
+	fail := func(err error) *decision {
+		if err != nil && options.OnError != nil {
+			options.OnError(err)
+		}
+
+		if options.FailMode == FailClosed {
+			return &decision{status: http.StatusServiceUnavailable}
+		}
+
+		return nil
+	}
+
+	ip := extractor(r)
+	if ip == nil {
+		return fail(nil)
+	}
+
+	if options.Publisher != nil {
+		err := options.Publisher.Publish(MonitoringMessage{IP: ip, Timestamp: time.Now()})
+		if err != nil && options.OnError != nil {
+			options.OnError(err)
+		}
+	}
+
+	ban, err := c.Check(ip)
+	if err != nil {
+		return fail(err)
+	}
+
+	if ban == nil {
+		return nil
+	}
+
+	retryAfter := int(math.Ceil(time.Until(ban.Until).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	return &decision{status: http.StatusForbidden, retryAfter: retryAfter}
+}
+
+// Middleware of net/http which rejects banned clients with 403 and Retry-After header
+func (c *Client) Middleware(options MiddlewareOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if d := c.decide(r, options); d != nil {
+				d.write(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GinMiddleware of gin which rejects banned clients with 403 and Retry-After header
+func (c *Client) GinMiddleware(options MiddlewareOptions) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if d := c.decide(ctx.Request, options); d != nil {
+			if d.retryAfter > 0 {
+				ctx.Header("Retry-After", strconv.Itoa(d.retryAfter))
+			}
+			ctx.AbortWithStatus(d.status)
+			return
+		}
+
+		ctx.Next()
+	}
+}
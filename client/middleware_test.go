@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingPublisher struct {
+	mutex    sync.Mutex
+	messages []MonitoringMessage
+}
+
+func (p *recordingPublisher) Publish(message MonitoringMessage) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.messages = append(p.messages, message)
+
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	_, c := startFakeService(t, state{
+		Bans: []Ban{{IP: "192.0.2.1", Until: time.Now().Add(time.Hour), Reason: "test"}},
+	})
+
+	publisher := &recordingPublisher{}
+	handler := c.Middleware(MiddlewareOptions{Publisher: publisher})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// fail open till ban list is loaded
+	require.Equal(t, http.StatusTeapot, serve("192.0.2.1:1000").Code)
+
+	require.NoError(t, c.Load(context.Background()))
+
+	w := serve("192.0.2.1:1000")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	require.InDelta(t, 3600, retryAfter, 5)
+
+	require.Equal(t, http.StatusTeapot, serve("192.0.2.2:1000").Code)
+
+	require.Len(t, publisher.messages, 3)
+	require.Equal(t, "192.0.2.2", publisher.messages[2].IP.String())
+}
+
+func TestMiddlewareFailClosed(t *testing.T) {
+	c, err := New(Config{URL: "http://127.0.0.1:1"})
+	require.NoError(t, err)
+
+	var errs []error
+	handler := c.Middleware(MiddlewareOptions{
+		FailMode: FailClosed,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Len(t, errs, 1)
+	require.True(t, errors.Is(errs[0], ErrNotReady))
+}
+
+func TestGinMiddleware(t *testing.T) {
+	_, c := startFakeService(t, state{
+		Bans: []Ban{{IP: "2001:db8::/32", Until: time.Now().Add(time.Hour), Reason: "test"}},
+	})
+	require.NoError(t, c.Load(context.Background()))
+
+	extractor, err := TrustedProxies("X-Real-IP", "127.0.0.1")
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(c.GinMiddleware(MiddlewareOptions{ClientIP: extractor}))
+	r.GET("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusTeapot)
+	})
+
+	serve := func(realIP string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "127.0.0.1:1000"
+		req.Header.Set("X-Real-IP", realIP)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusForbidden, serve("2001:db8::1"))
+	require.Equal(t, http.StatusTeapot, serve("2001:db9::1"))
+}
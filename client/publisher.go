@@ -0,0 +1,220 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrPublishBufferFull message is dropped because broker doesn't keep up with requests
+var ErrPublishBufferFull = errors.New("publish buffer is full")
+
+// ErrPublisherClosed message is dropped because publisher is closed
+var ErrPublisherClosed = errors.New("publisher is closed")
+
+// MonitoringMessage request of IP, consumed by monitoring listener of traffic service
+type MonitoringMessage struct {
+	IP        net.IP    `json:"ip"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Publisher sends monitoring messages of requests. Publish is called on each request, so it must not block
+type Publisher interface {
+	Publish(message MonitoringMessage) error
+}
+
+// Dialer opens connection to broker
+type Dialer func() (*amqp.Connection, error)
+
+// PublisherConfig PublisherConfig
+type PublisherConfig struct {
+	// BufferSize number of messages waiting for broker, 10000 when zero. Messages are dropped when it is full
+	BufferSize int
+	// ReconnectDelay first delay before reconnect, doubled up to ReconnectMaxDelay on each failure.
+	// 100ms and 30s when zero
+	ReconnectDelay    time.Duration
+	ReconnectMaxDelay time.Duration
+	// OnError receives errors of connection and publishing
+	OnError func(err error)
+}
+
+// AMQPPublisher publishes monitoring messages to queue of traffic service through default exchange.
+// Messages are buffered and sent by background goroutine, which reconnects when broker closes
+// connection or channel and pauses while broker blocks connection.
+// Queue is declared by traffic service, so its arguments are not repeated here
+type AMQPPublisher struct {
+	dial      Dialer
+	queue     string
+	config    PublisherConfig
+	messages  chan MonitoringMessage
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAMQPPublisher starts publishing to queue through connections opened by dial
+func NewAMQPPublisher(dial Dialer, queue string, config PublisherConfig) *AMQPPublisher {
+	if config.BufferSize <= 0 {
+		config.BufferSize = 10000
+	}
+	if config.ReconnectDelay <= 0 {
+		config.ReconnectDelay = 100 * time.Millisecond
+	}
+	if config.ReconnectMaxDelay <= 0 {
+		config.ReconnectMaxDelay = 30 * time.Second
+	}
+	if config.ReconnectMaxDelay < config.ReconnectDelay {
+		config.ReconnectMaxDelay = config.ReconnectDelay
+	}
+
+	p := &AMQPPublisher{
+		dial:     dial,
+		queue:    queue,
+		config:   config,
+		messages: make(chan MonitoringMessage, config.BufferSize),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+// Publish queues message without waiting for broker. Message is dropped when buffer is full
+func (p *AMQPPublisher) Publish(message MonitoringMessage) error {
+	select {
+	case <-p.quit:
+		return ErrPublisherClosed
+	default:
+	}
+
+	select {
+	case p.messages <- message:
+		return nil
+	default:
+		return ErrPublishBufferFull
+	}
+}
+
+// Close stops publishing, messages which are not sent yet are dropped
+func (p *AMQPPublisher) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+	})
+	<-p.done
+
+	return nil
+}
+
+func (p *AMQPPublisher) report(err error) {
+	if err != nil && p.config.OnError != nil {
+		p.config.OnError(err)
+	}
+}
+
+func (p *AMQPPublisher) run() {
+	defer close(p.done)
+
+	delay := p.config.ReconnectDelay
+	for {
+		conn, err := p.dial()
+		if err == nil {
+			var quit bool
+			quit, err = p.publish(conn, func() {
+				delay = p.config.ReconnectDelay
+			})
+
+			closeErr := conn.Close()
+			if closeErr != nil && closeErr != amqp.ErrClosed {
+				p.report(closeErr)
+			}
+
+			if quit {
+				return
+			}
+		}
+
+		p.report(err)
+
+		select {
+		case <-time.After(delay):
+		case <-p.quit:
+			return
+		}
+
+		delay *= 2
+		if delay > p.config.ReconnectMaxDelay {
+			delay = p.config.ReconnectMaxDelay
+		}
+	}
+}
+
+// publish sends buffered messages until quit or connection failure. Reports true when quit requested
+func (p *AMQPPublisher) publish(conn *amqp.Connection, onConnected func()) (bool, error) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	connBlocked := conn.NotifyBlocked(make(chan amqp.Blocking, 2))
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return false, err
+	}
+	defer ch.Close()
+
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	onConnected()
+
+	blocked := false
+	for {
+		// messages stay in buffer while broker blocks publishing, so requests are not held
+		var messages <-chan MonitoringMessage
+		if !blocked {
+			messages = p.messages
+		}
+
+		select {
+		case <-p.quit:
+			return true, nil
+		case err := <-connClosed:
+			return false, closeReason(err)
+		case err := <-chClosed:
+			return false, closeReason(err)
+		case blocking := <-connBlocked:
+			blocked = blocking.Active
+		case message := <-messages:
+			body, err := json.Marshal(message)
+			if err != nil {
+				p.report(err)
+				continue
+			}
+
+			err = ch.Publish(
+				"",      // exchange
+				p.queue, // routing key
+				false,   // mandatory
+				false,   // immediate
+				amqp.Publishing{
+					ContentType: "application/json",
+					Body:        body,
+				},
+			)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// closeReason error of closed connection or channel, notification channel is closed without error on graceful close
+func closeReason(err *amqp.Error) error {
+	if err == nil {
+		return amqp.ErrClosed
+	}
+
+	return err
+}
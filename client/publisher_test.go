@@ -0,0 +1,60 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAMQPPublisherDropsWhenBufferIsFull(t *testing.T) {
+	var mutex sync.Mutex
+	dials := 0
+	var errs []error
+
+	publisher := NewAMQPPublisher(func() (*amqp.Connection, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		dials++
+		return nil, errors.New("broker is down")
+	}, "input", PublisherConfig{
+		BufferSize:        2,
+		ReconnectDelay:    time.Millisecond,
+		ReconnectMaxDelay: 5 * time.Millisecond,
+		OnError: func(err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			errs = append(errs, err)
+		},
+	})
+
+	message := MonitoringMessage{IP: net.IPv4(192, 0, 2, 1), Timestamp: time.Now()}
+
+	// publishing never waits for broker
+	results := make(chan []error, 1)
+	go func() {
+		results <- []error{publisher.Publish(message), publisher.Publish(message), publisher.Publish(message)}
+	}()
+
+	select {
+	case result := <-results:
+		require.Equal(t, []error{nil, nil, ErrPublishBufferFull}, result)
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked")
+	}
+
+	// connection is retried
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return dials >= 3 && len(errs) >= 3
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, publisher.Close())
+	require.NoError(t, publisher.Close())
+	require.Equal(t, ErrPublisherClosed, publisher.Publish(message))
+}
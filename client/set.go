@@ -0,0 +1,105 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// normalizeIP converts IPv4 to 4-byte form
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
+
+// parseNetwork parses IP or CIDR notation as served by traffic service
+func parseNetwork(value string) (net.IPNet, error) {
+	value = strings.TrimSpace(value)
+
+	if !strings.Contains(value, "/") {
+		ip := normalizeIP(net.ParseIP(value))
+		if ip == nil {
+			return net.IPNet{}, fmt.Errorf("invalid IP `%s`", value)
+		}
+
+		bits := len(ip) * 8
+		return net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return net.IPNet{}, fmt.Errorf("invalid network `%s`", value)
+	}
+
+	return net.IPNet{IP: normalizeIP(network.IP), Mask: network.Mask}, nil
+}
+
+// networkKey canonical form of network, shared by stored networks and lookups
+func networkKey(ip net.IP, ones int) string {
+	return fmt.Sprintf("%s/%d", ip.String(), ones)
+}
+
+// prefixSet networks by canonical key with counts of prefix lengths,
+// so IP is looked up by masking it with each present length
+type prefixSet struct {
+	keys map[string]struct{}
+	ipv4 [net.IPv4len*8 + 1]int
+	ipv6 [net.IPv6len*8 + 1]int
+}
+
+func newPrefixSet() *prefixSet {
+	return &prefixSet{keys: make(map[string]struct{})}
+}
+
+func (p *prefixSet) counts(bits int) []int {
+	if bits == net.IPv4len*8 {
+		return p.ipv4[:]
+	}
+
+	return p.ipv6[:]
+}
+
+// add stores network and returns its key
+func (p *prefixSet) add(network net.IPNet) string {
+	ones, bits := network.Mask.Size()
+	key := networkKey(network.IP, ones)
+	if _, ok := p.keys[key]; !ok {
+		p.keys[key] = struct{}{}
+		p.counts(bits)[ones]++
+	}
+
+	return key
+}
+
+// remove deletes network and returns its key
+func (p *prefixSet) remove(network net.IPNet) string {
+	ones, bits := network.Mask.Size()
+	key := networkKey(network.IP, ones)
+	if _, ok := p.keys[key]; ok {
+		delete(p.keys, key)
+		p.counts(bits)[ones]--
+	}
+
+	return key
+}
+
+// lookup calls fn with keys of stored networks which contain IP, from most specific one, until fn returns true
+func (p *prefixSet) lookup(ip net.IP, fn func(key string) bool) {
+	ip = normalizeIP(ip)
+	bits := len(ip) * 8
+	counts := p.counts(bits)
+
+	for ones := bits; ones >= 0; ones-- {
+		if counts[ones] == 0 {
+			continue
+		}
+
+		key := networkKey(ip.Mask(net.CIDRMask(ones, bits)), ones)
+		if _, ok := p.keys[key]; ok && fn(key) {
+			return
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/autowp/traffic"
 )
@@ -60,6 +62,65 @@ func main() {
 		t.Close()
 		os.Exit(0)
 		return
+	case "whitelist-import":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: traffic whitelist-import <name> <file>")
+			os.Exit(1)
+			return
+		}
+		err = t.WhitelistImport(os.Args[2], os.Args[3])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		t.Close()
+		os.Exit(0)
+		return
+	case "blocklist-import":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: traffic blocklist-import <name> <file> [duration]")
+			os.Exit(1)
+			return
+		}
+		var duration time.Duration
+		if len(os.Args) > 4 {
+			duration, err = time.ParseDuration(os.Args[4])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+				return
+			}
+		}
+		err = t.BlocklistImport(os.Args[2], os.Args[3], duration)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		t.Close()
+		os.Exit(0)
+		return
+	case "ban-export":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: traffic ban-export <format> <file> [name]")
+			fmt.Printf("Formats: %s\n", strings.Join(traffic.ExportFormats, ", "))
+			os.Exit(1)
+			return
+		}
+		name := ""
+		if len(os.Args) > 4 {
+			name = os.Args[4]
+		}
+		err = t.BanExport(os.Args[2], name, os.Args[3])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		t.Close()
+		os.Exit(0)
+		return
 	case "serve":
 		err = t.Serve()
 		if err != nil {
@@ -77,6 +138,26 @@ func main() {
 			os.Exit(1)
 		}
 		return
+	case "sync-files":
+		quit := make(chan bool)
+		err = t.SyncFiles(quit)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		c := make(chan os.Signal, 2)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		for sig := range c {
+			log.Printf("captured %v, stopping and exiting.", sig)
+
+			quit <- true
+			close(quit)
+			t.Close()
+			os.Exit(1)
+		}
+		return
 	case "listen-amqp":
 		quit := make(chan bool)
 		err = t.ListenAMQP(quit)
@@ -5,6 +5,8 @@ import (
 	"github.com/autowp/traffic/util"
 	"github.com/spf13/viper"
 	"log"
+	"net"
+	"time"
 )
 
 // HTTPConfig HTTPConfig
@@ -18,14 +20,121 @@ type MigrationsConfig struct {
 	Dir string `yaml:"dir" mapstructure:"dir"`
 }
 
+// MonitoringConfig MonitoringConfig
+type MonitoringConfig struct {
+	// BatchSize number of unacknowledged deliveries which triggers flush, must be less than Prefetch
+	BatchSize     int           `yaml:"batch_size"     mapstructure:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval" mapstructure:"flush_interval"`
+	// Durable queue survives broker restart. Changing it for existing queue requires queue to be deleted
+	Durable bool `yaml:"durable" mapstructure:"durable"`
+	// Prefetch limits number of unacknowledged deliveries, 0 means unlimited
+	Prefetch int `yaml:"prefetch" mapstructure:"prefetch"`
+	// DeadLetterExchange receives rejected malformed messages, empty means they are dropped
+	DeadLetterExchange string `yaml:"dead_letter_exchange" mapstructure:"dead_letter_exchange"`
+	// ReconnectDelay first delay before reconnect to broker, doubled up to ReconnectMaxDelay on each failure
+	ReconnectDelay    time.Duration `yaml:"reconnect_delay"     mapstructure:"reconnect_delay"`
+	ReconnectMaxDelay time.Duration `yaml:"reconnect_max_delay" mapstructure:"reconnect_max_delay"`
+	// RealtimeDetection evaluates autoban profiles in listener for each message
+	RealtimeDetection bool `yaml:"realtime_detection" mapstructure:"realtime_detection"`
+	// RealtimeMaxWindow longest window of profiles evaluated in realtime, longer ones are left to scheduled autoban
+	RealtimeMaxWindow time.Duration `yaml:"realtime_max_window" mapstructure:"realtime_max_window"`
+	// RealtimeMaxNetworks limits networks tracked by realtime detection for each profile,
+	// least recently seen are forgotten
+	RealtimeMaxNetworks int `yaml:"realtime_max_networks" mapstructure:"realtime_max_networks"`
+}
+
+// BanConfig BanConfig
+type BanConfig struct {
+	// MinIPv4Prefix and MinIPv6Prefix shortest prefix of banned network, so whole internet can't be banned by mistake
+	MinIPv4Prefix int `yaml:"min_ipv4_prefix" mapstructure:"min_ipv4_prefix"`
+	MinIPv6Prefix int `yaml:"min_ipv6_prefix" mapstructure:"min_ipv6_prefix"`
+}
+
+// BanEscalationConfig BanEscalationConfig
+type BanEscalationConfig struct {
+	// Factor multiplies ban time of profile for each previous offence
+	Factor float64 `yaml:"factor" mapstructure:"factor"`
+	// MaxTime limits escalated ban time, zero means unlimited
+	MaxTime time.Duration `yaml:"max_time" mapstructure:"max_time"`
+	// CleanPeriod after which offence is forgotten, zero means never
+	CleanPeriod time.Duration `yaml:"clean_period" mapstructure:"clean_period"`
+}
+
+// DNSConfig DNSConfig
+type DNSConfig struct {
+	// Servers recursive nameservers, nameservers of /etc/resolv.conf are used when empty
+	Servers []string `yaml:"servers" mapstructure:"servers"`
+	// CacheTTL limits caching of lookups, TTL of records is honoured below it. Zero disables cache
+	CacheTTL time.Duration `yaml:"cache_ttl" mapstructure:"cache_ttl"`
+	// NegativeTTL how long NXDOMAIN and failed lookups are cached
+	NegativeTTL time.Duration `yaml:"negative_ttl" mapstructure:"negative_ttl"`
+	// Workers number of concurrent lookups of whitelist autodetect
+	Workers int `yaml:"workers" mapstructure:"workers"`
+}
+
+// WhitelistConfig WhitelistConfig
+type WhitelistConfig struct {
+	// AutodetectTTL how long autodetected item is kept after last successful verification, zero means forever
+	AutodetectTTL time.Duration `yaml:"autodetect_ttl" mapstructure:"autodetect_ttl"`
+}
+
+// BlocklistConfig BlocklistConfig
+type BlocklistConfig struct {
+	// Duration of bans of imported list, list is expected to be imported again before it ends
+	Duration time.Duration `yaml:"duration" mapstructure:"duration"`
+}
+
+// SnapshotConfig SnapshotConfig
+type SnapshotConfig struct {
+	// RefreshInterval how often changes of bans and whitelist are applied to in-memory snapshot of check endpoint
+	// besides notified ones
+	RefreshInterval time.Duration `yaml:"refresh_interval" mapstructure:"refresh_interval"`
+	// ResyncInterval how often snapshot is fully reloaded, so changes skipped by change log cursors are picked up
+	ResyncInterval time.Duration `yaml:"resync_interval" mapstructure:"resync_interval"`
+	// ChangeRetention how long change log is kept, must exceed ResyncInterval
+	ChangeRetention time.Duration `yaml:"change_retention" mapstructure:"change_retention"`
+}
+
+// NotifyConfig NotifyConfig
+type NotifyConfig struct {
+	// Enabled subscribes serving process to change notifications of bans and whitelist
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	// ReconnectDelay first delay before reconnect of listener, doubled up to ReconnectMaxDelay on each failure
+	ReconnectDelay    time.Duration `yaml:"reconnect_delay"     mapstructure:"reconnect_delay"`
+	ReconnectMaxDelay time.Duration `yaml:"reconnect_max_delay" mapstructure:"reconnect_max_delay"`
+}
+
+// SyncFilesConfig SyncFilesConfig
+type SyncFilesConfig struct {
+	// Interval of sync besides notified changes, so expired bans are dropped from files
+	Interval time.Duration `yaml:"interval" mapstructure:"interval"`
+	// Debounce reload commands run after last change of files
+	Debounce time.Duration `yaml:"debounce" mapstructure:"debounce"`
+	// MaxWait limits delay of reload commands after first unapplied change, so constant changes don't postpone
+	// reload forever. Zero means unlimited
+	MaxWait time.Duration    `yaml:"max_wait" mapstructure:"max_wait"`
+	Files   []SyncFileConfig `yaml:"files"    mapstructure:"files"`
+}
+
 // Config Application config definition
 type Config struct {
-	RabbitMQ        string            `yaml:"rabbitmq"         mapstructure:"rabbitmq"`
-	MonitoringQueue string            `yaml:"monitoring_queue" mapstructure:"monitoring_queue"`
-	Sentry          util.SentryConfig `yaml:"sentry"           mapstructure:"sentry"`
-	DSN             string            `yaml:"dsn"              mapstructure:"dsn"`
-	Migrations      MigrationsConfig  `yaml:"migrations"       mapstructure:"migrations"`
-	HTTP            HTTPConfig        `yaml:"http"             mapstructure:"http"`
+	RabbitMQ        string              `yaml:"rabbitmq"         mapstructure:"rabbitmq"`
+	MonitoringQueue string              `yaml:"monitoring_queue" mapstructure:"monitoring_queue"`
+	Sentry          util.SentryConfig   `yaml:"sentry"           mapstructure:"sentry"`
+	DSN             string              `yaml:"dsn"              mapstructure:"dsn"`
+	Migrations      MigrationsConfig    `yaml:"migrations"       mapstructure:"migrations"`
+	HTTP            HTTPConfig          `yaml:"http"             mapstructure:"http"`
+	AutobanProfiles []AutobanProfile    `yaml:"autoban_profiles" mapstructure:"autoban_profiles"`
+	Monitoring      MonitoringConfig    `yaml:"monitoring"       mapstructure:"monitoring"`
+	Ban             BanConfig           `yaml:"ban"              mapstructure:"ban"`
+	BanEscalation   BanEscalationConfig `yaml:"ban_escalation"   mapstructure:"ban_escalation"`
+	Crawlers        []CrawlerRule       `yaml:"crawlers"         mapstructure:"crawlers"`
+	DNS             DNSConfig           `yaml:"dns"              mapstructure:"dns"`
+	Whitelist       WhitelistConfig     `yaml:"whitelist"        mapstructure:"whitelist"`
+	Blocklist       BlocklistConfig     `yaml:"blocklist"        mapstructure:"blocklist"`
+	Snapshot        SnapshotConfig      `yaml:"snapshot"         mapstructure:"snapshot"`
+	Notify          NotifyConfig        `yaml:"notify"           mapstructure:"notify"`
+	SyncFiles       SyncFilesConfig     `yaml:"sync_files"       mapstructure:"sync_files"`
 }
 
 // LoadConfig LoadConfig
@@ -83,4 +192,119 @@ func ValidateConfig(config Config) {
 		log.Fatalln("MonitoringQueue not provided")
 	}
 
+	if config.Monitoring.BatchSize <= 0 {
+		log.Fatalln("monitoring.batch_size must be positive")
+	}
+
+	if config.Monitoring.FlushInterval <= 0 {
+		log.Fatalln("monitoring.flush_interval must be positive")
+	}
+
+	if config.Monitoring.Prefetch < 0 {
+		log.Fatalln("monitoring.prefetch must not be negative")
+	}
+
+	if config.Monitoring.Prefetch > 0 && config.Monitoring.BatchSize >= config.Monitoring.Prefetch {
+		log.Fatalln("monitoring.batch_size must be less than prefetch")
+	}
+
+	if config.Monitoring.ReconnectDelay <= 0 {
+		log.Fatalln("monitoring.reconnect_delay must be positive")
+	}
+
+	if config.Monitoring.ReconnectMaxDelay < config.Monitoring.ReconnectDelay {
+		log.Fatalln("monitoring.reconnect_max_delay must not be less than reconnect_delay")
+	}
+
+	if config.Ban.MinIPv4Prefix < 0 || config.Ban.MinIPv4Prefix > net.IPv4len*8 {
+		log.Fatalf("ban.min_ipv4_prefix must be between 0 and %d\n", net.IPv4len*8)
+	}
+
+	if config.Ban.MinIPv6Prefix < 0 || config.Ban.MinIPv6Prefix > net.IPv6len*8 {
+		log.Fatalf("ban.min_ipv6_prefix must be between 0 and %d\n", net.IPv6len*8)
+	}
+
+	if config.Monitoring.RealtimeDetection &&
+		(config.Monitoring.RealtimeMaxWindow <= 0 || config.Monitoring.RealtimeMaxNetworks <= 0) {
+		log.Fatalln("monitoring.realtime_max_window and realtime_max_networks must be positive")
+	}
+
+	if config.BanEscalation.Factor < 1 {
+		log.Fatalln("ban_escalation.factor must not be less than 1")
+	}
+
+	if config.BanEscalation.MaxTime < 0 || config.BanEscalation.CleanPeriod < 0 {
+		log.Fatalln("ban_escalation durations must not be negative")
+	}
+
+	if config.DNS.CacheTTL < 0 || config.DNS.NegativeTTL < 0 {
+		log.Fatalln("dns durations must not be negative")
+	}
+
+	if config.DNS.Workers <= 0 {
+		log.Fatalln("dns.workers must be positive")
+	}
+
+	if config.Whitelist.AutodetectTTL < 0 {
+		log.Fatalln("whitelist.autodetect_ttl must not be negative")
+	}
+
+	if config.Blocklist.Duration <= 0 {
+		log.Fatalln("blocklist.duration must be positive")
+	}
+
+	if config.Snapshot.RefreshInterval <= 0 || config.Snapshot.ResyncInterval <= 0 {
+		log.Fatalln("snapshot intervals must be positive")
+	}
+
+	if config.Snapshot.ChangeRetention <= config.Snapshot.ResyncInterval {
+		log.Fatalln("snapshot.change_retention must be greater than resync_interval")
+	}
+
+	if config.Notify.ReconnectDelay <= 0 {
+		log.Fatalln("notify.reconnect_delay must be positive")
+	}
+
+	if config.Notify.ReconnectMaxDelay < config.Notify.ReconnectDelay {
+		log.Fatalln("notify.reconnect_max_delay must not be less than reconnect_delay")
+	}
+
+	if config.SyncFiles.Interval <= 0 {
+		log.Fatalln("sync_files.interval must be positive")
+	}
+
+	if config.SyncFiles.Debounce < 0 {
+		log.Fatalln("sync_files.debounce must not be negative")
+	}
+
+	if config.SyncFiles.MaxWait < 0 {
+		log.Fatalln("sync_files.max_wait must not be negative")
+	}
+
+	if config.SyncFiles.MaxWait > 0 && config.SyncFiles.MaxWait < config.SyncFiles.Debounce {
+		log.Fatalln("sync_files.max_wait must not be less than debounce")
+	}
+
+	for idx, file := range config.SyncFiles.Files {
+		if err := file.Validate(); err != nil {
+			log.Fatalf("sync_files.files[%d]: %v\n", idx, err)
+		}
+	}
+
+	for idx, profile := range config.AutobanProfiles {
+		if err := profile.Validate(); err != nil {
+			log.Fatalf("autoban_profiles[%d]: %v\n", idx, err)
+		}
+
+		if (profile.IPv4Prefix > 0 && profile.IPv4Prefix < config.Ban.MinIPv4Prefix) ||
+			(profile.IPv6Prefix > 0 && profile.IPv6Prefix < config.Ban.MinIPv6Prefix) {
+			log.Fatalf("autoban_profiles[%d]: prefix is shorter than minimal prefix of ban\n", idx)
+		}
+	}
+
+	for idx, rule := range config.Crawlers {
+		if err := rule.Validate(); err != nil {
+			log.Fatalf("crawlers[%d]: %v\n", idx, err)
+		}
+	}
 }
@@ -0,0 +1,68 @@
+package traffic
+
+import (
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLoadAutobanProfiles(t *testing.T) {
+
+	config := LoadConfig()
+
+	require.Len(t, config.AutobanProfiles, 5)
+
+	for _, profile := range config.AutobanProfiles {
+		require.NoError(t, profile.Validate())
+	}
+
+	require.Equal(t, 700, config.AutobanProfiles[3].Limit)
+	require.Equal(t, time.Minute, config.AutobanProfiles[3].Window)
+	require.Equal(t, 12*time.Hour, config.AutobanProfiles[3].Time)
+	require.True(t, config.AutobanProfiles[3].Enabled)
+
+	subnet := config.AutobanProfiles[4]
+	require.False(t, subnet.Enabled)
+	require.Equal(t, 24, subnet.IPv4Prefix)
+	require.Equal(t, 64, subnet.IPv6Prefix)
+}
+
+func TestLoadCrawlers(t *testing.T) {
+
+	config := LoadConfig()
+
+	require.NotEmpty(t, config.Crawlers)
+
+	names := make([]string, 0, len(config.Crawlers))
+	for _, rule := range config.Crawlers {
+		require.NoError(t, rule.Validate())
+		names = append(names, rule.Name)
+	}
+
+	require.Contains(t, names, "googlebot")
+	require.Contains(t, names, "bingbot")
+	require.Contains(t, names, "yandex")
+}
+
+func TestLoadCrawlersGooglebot(t *testing.T) {
+
+	config := LoadConfig()
+
+	var rule CrawlerRule
+	for _, item := range config.Crawlers {
+		if item.Name == "googlebot" {
+			rule = item
+		}
+	}
+	require.Equal(t, "googlebot", rule.Name)
+
+	ip := net.IPv4(66, 249, 66, 1)
+	require.True(t, rule.Match("crawl-66-249-66-1.googlebot.com.", ip))
+	require.True(t, rule.Match("crawl-66-249-66-1.geo.googlebot.com.", ip))
+
+	// any GCE instance passes forward-confirmed reverse DNS of these domains
+	require.False(t, rule.Match("x.bc.googleusercontent.com.", ip))
+	require.False(t, rule.Match("1.1.168.192.bc.googleusercontent.com.", ip))
+	require.False(t, rule.Match("x.google.com.", ip))
+}
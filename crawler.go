@@ -0,0 +1,110 @@
+package traffic
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// CrawlerRule search engine crawler verified by forward-confirmed reverse DNS
+type CrawlerRule struct {
+	Name string `yaml:"name" mapstructure:"name"`
+	// Hosts allowed hostname suffixes, e.g. googlebot.com matches crawl-66-249-66-1.googlebot.com
+	Hosts []string `yaml:"hosts" mapstructure:"hosts"`
+	// Families allowed address families: ipv4, ipv6. Empty means any
+	Families []string `yaml:"families" mapstructure:"families"`
+	// Description of whitelist item. {name}, {host} and {ip} are replaced. Defaults to "{name} autodetect"
+	Description string `yaml:"description" mapstructure:"description"`
+}
+
+// CrawlerMatch crawler verified by rule
+type CrawlerMatch struct {
+	Rule        CrawlerRule
+	Host        string
+	Description string
+}
+
+// Source source of whitelist item of crawler
+func (m CrawlerMatch) Source() string {
+	return WhitelistSourceAutodetect + m.Rule.Name
+}
+
+// Validate crawler rule
+func (r CrawlerRule) Validate() error {
+	if len(r.Name) == 0 {
+		return fmt.Errorf("name is required")
+	}
+
+	if len(r.Hosts) == 0 {
+		return fmt.Errorf("hosts are required")
+	}
+
+	for _, host := range r.Hosts {
+		if len(strings.Trim(host, ".")) == 0 {
+			return fmt.Errorf("empty host")
+		}
+	}
+
+	for _, family := range r.Families {
+		if family != familyIPv4 && family != familyIPv6 {
+			return fmt.Errorf("unknown family `%s`", family)
+		}
+	}
+
+	return nil
+}
+
+// Match reports hostname of IP belongs to crawler
+func (r CrawlerRule) Match(host string, ip net.IP) bool {
+	if !r.matchFamily(ip) {
+		return false
+	}
+
+	host = normalizeHost(host)
+	for _, suffix := range r.Hosts {
+		suffix = "." + strings.TrimPrefix(normalizeHost(suffix), ".")
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r CrawlerRule) matchFamily(ip net.IP) bool {
+	if len(r.Families) == 0 {
+		return true
+	}
+
+	family := familyIPv6
+	if ip.To4() != nil {
+		family = familyIPv4
+	}
+
+	for _, f := range r.Families {
+		if f == family {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Describe description of whitelist item of crawler
+func (r CrawlerRule) Describe(host string, ip net.IP) string {
+	template := r.Description
+	if len(template) == 0 {
+		template = "{name} autodetect"
+	}
+
+	return strings.NewReplacer(
+		"{name}", r.Name,
+		"{host}", strings.TrimSuffix(host, "."),
+		"{ip}", ip.String(),
+	).Replace(template)
+}
@@ -0,0 +1,50 @@
+package traffic
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrawlerRuleMatch(t *testing.T) {
+
+	rule := CrawlerRule{
+		Name:     "yandex",
+		Hosts:    []string{"yandex.com", ".yandex.ru."},
+		Families: []string{familyIPv4},
+	}
+	require.NoError(t, rule.Validate())
+
+	ip := net.IPv4(5, 255, 253, 1)
+
+	require.True(t, rule.Match("5-255-253-1.spider.yandex.com.", ip))
+	require.True(t, rule.Match("5-255-253-1.spider.YANDEX.ru", ip))
+	require.False(t, rule.Match("yandex.com.", ip))
+	require.False(t, rule.Match("5-255-253-1.notyandex.com.", ip))
+	require.False(t, rule.Match("5-255-253-1.yandex.com.evil.org.", ip))
+	require.False(t, rule.Match("5-255-253-1.spider.yandex.com.", net.ParseIP("2a02:6b8::1")))
+
+	rule.Families = nil
+	require.True(t, rule.Match("5-255-253-1.spider.yandex.com.", net.ParseIP("2a02:6b8::1")))
+}
+
+func TestCrawlerRuleDescribe(t *testing.T) {
+
+	ip := net.IPv4(66, 249, 66, 1)
+	host := "crawl-66-249-66-1.googlebot.com."
+
+	rule := CrawlerRule{Name: "googlebot", Hosts: []string{"googlebot.com"}}
+	require.Equal(t, "googlebot autodetect", rule.Describe(host, ip))
+
+	rule.Description = "{name}: {host} ({ip})"
+	require.Equal(t, "googlebot: crawl-66-249-66-1.googlebot.com (66.249.66.1)", rule.Describe(host, ip))
+}
+
+func TestCrawlerRuleValidate(t *testing.T) {
+
+	require.Error(t, CrawlerRule{Hosts: []string{"googlebot.com"}}.Validate())
+	require.Error(t, CrawlerRule{Name: "googlebot"}.Validate())
+	require.Error(t, CrawlerRule{Name: "googlebot", Hosts: []string{"."}}.Validate())
+	require.Error(t, CrawlerRule{Name: "googlebot", Hosts: []string{"googlebot.com"}, Families: []string{"ipx"}}.Validate())
+}
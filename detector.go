@@ -0,0 +1,198 @@
+package traffic
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+)
+
+// detectorSlots number of slots of sliding window, so counters have precision of window/detectorSlots
+const detectorSlots = 60
+
+// slidingCounter counts events in the last window with slots ring.
+// Slots hold epochs from head-detectorSlots+1 to head, so counter takes about 256 bytes regardless of window
+type slidingCounter struct {
+	slotWidth int64
+	head      int64
+	slots     [detectorSlots]int32
+}
+
+func newSlidingCounter(window time.Duration) slidingCounter {
+	slotWidth := int64(window) / detectorSlots
+	if slotWidth <= 0 {
+		slotWidth = 1
+	}
+
+	return slidingCounter{slotWidth: slotWidth}
+}
+
+func (c *slidingCounter) add(timestamp time.Time) {
+	epoch := timestamp.UnixNano() / c.slotWidth
+
+	if epoch > c.head {
+		// slots of passed epochs are reused
+		for e := c.head + 1; e <= epoch && e <= c.head+detectorSlots; e++ {
+			c.slots[e%detectorSlots] = 0
+		}
+		c.head = epoch
+	}
+
+	if epoch <= c.head-detectorSlots {
+		return
+	}
+	c.slots[epoch%detectorSlots]++
+}
+
+func (c *slidingCounter) sum(now time.Time) int {
+	epoch := now.UnixNano() / c.slotWidth
+
+	from, to := c.head, c.head
+	if epoch > from {
+		from = epoch
+	}
+	if epoch < to {
+		to = epoch
+	}
+
+	sum := 0
+	for e := from - detectorSlots + 1; e <= to; e++ {
+		sum += int(c.slots[e%detectorSlots])
+	}
+
+	return sum
+}
+
+func (c *slidingCounter) reset() {
+	c.slots = [detectorSlots]int32{}
+}
+
+type detectorEntry struct {
+	key     string
+	last    time.Time
+	counter slidingCounter
+	// element of recently hit list of profile
+	element *list.Element
+}
+
+// Detector evaluates autoban profiles for each request as it arrives
+type Detector struct {
+	mutex    sync.Mutex
+	profiles []AutobanProfile
+	// minWindow shortest window of profiles, idle entries are collected at least that often
+	minWindow time.Duration
+	// entries of each profile by network which profile aggregates
+	entries []map[string]*detectorEntry
+	// recent entries of each profile, most recently hit first
+	recent []*list.List
+	// maxNetworks limits entries of each profile, zero means unlimited
+	maxNetworks int
+	lastGC      time.Time
+}
+
+// NewDetector constructor. Disabled profiles are ignored
+func NewDetector(profiles []AutobanProfile) *Detector {
+	d := &Detector{}
+
+	for _, profile := range profiles {
+		if !profile.Enabled {
+			continue
+		}
+
+		d.profiles = append(d.profiles, profile)
+		d.entries = append(d.entries, make(map[string]*detectorEntry))
+		d.recent = append(d.recent, list.New())
+		if d.minWindow == 0 || profile.Window < d.minWindow {
+			d.minWindow = profile.Window
+		}
+	}
+
+	return d
+}
+
+// SetMaxNetworks limits tracked networks of each profile, least recently hit are forgotten. Zero means unlimited
+func (d *Detector) SetMaxNetworks(maxNetworks int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.maxNetworks = maxNetworks
+}
+
+// Hit counts request and returns profiles which limits are exceeded with it.
+// Counter of the profile is reset when exceeded, so profile fires again only after another Limit requests
+func (d *Detector) Hit(ip net.IP, timestamp time.Time) []AutobanProfile {
+	if len(d.profiles) == 0 {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var result []AutobanProfile
+	for idx, profile := range d.profiles {
+		key := profile.Network(ip).String()
+		entry, ok := d.entries[idx][key]
+		if ok {
+			d.recent[idx].MoveToFront(entry.element)
+		} else {
+			entry = &detectorEntry{key: key, counter: newSlidingCounter(profile.Window)}
+			entry.element = d.recent[idx].PushFront(entry)
+			d.entries[idx][key] = entry
+
+			if d.maxNetworks > 0 && d.recent[idx].Len() > d.maxNetworks {
+				d.remove(idx, d.recent[idx].Back().Value.(*detectorEntry))
+			}
+		}
+
+		if timestamp.After(entry.last) {
+			entry.last = timestamp
+		}
+
+		if timestamp.Before(entry.last.Add(-profile.Window)) {
+			continue
+		}
+
+		entry.counter.add(timestamp)
+		if entry.counter.sum(entry.last) > profile.Limit {
+			entry.counter.reset()
+			result = append(result, profile)
+		}
+	}
+
+	if timestamp.Sub(d.lastGC) > d.minWindow {
+		d.gc(timestamp)
+	}
+
+	return result
+}
+
+// gc forgets networks which counters are empty, i.e. without requests during window of profile
+// or reset by firing since then
+func (d *Detector) gc(now time.Time) {
+	for idx, entries := range d.entries {
+		for _, entry := range entries {
+			if !entry.last.After(now) && entry.counter.sum(now) == 0 {
+				d.remove(idx, entry)
+			}
+		}
+	}
+	d.lastGC = now
+}
+
+func (d *Detector) remove(idx int, entry *detectorEntry) {
+	d.recent[idx].Remove(entry.element)
+	delete(d.entries[idx], entry.key)
+}
+
+// Len number of tracked networks of all profiles
+func (d *Detector) Len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	count := 0
+	for _, entries := range d.entries {
+		count += len(entries)
+	}
+
+	return count
+}
@@ -0,0 +1,160 @@
+package traffic
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectorSlidingWindow(t *testing.T) {
+
+	profile := AutobanProfile{
+		Limit:   700,
+		Reason:  "min limit",
+		Window:  time.Minute,
+		Time:    time.Hour,
+		Enabled: true,
+	}
+	d := NewDetector([]AutobanProfile{profile})
+
+	ip := net.IPv4(192, 168, 0, 1)
+	start := time.Date(2021, 1, 1, 12, 0, 59, 0, time.UTC)
+
+	for i := 0; i < 699; i++ {
+		require.Empty(t, d.Hit(ip, start))
+	}
+
+	fired := 0
+	for i := 0; i < 699; i++ {
+		fired += len(d.Hit(ip, start.Add(2*time.Second)))
+	}
+	require.Equal(t, 1, fired)
+
+	// requests out of window are not counted
+	other := net.IPv4(192, 168, 0, 2)
+	for i := 0; i < 699; i++ {
+		require.Empty(t, d.Hit(other, start))
+		require.Empty(t, d.Hit(other, start.Add(2*time.Minute)))
+	}
+}
+
+func TestDetectorIgnoresDisabled(t *testing.T) {
+
+	d := NewDetector([]AutobanProfile{
+		{Limit: 1, Reason: "Test", Window: 24 * time.Hour, Time: time.Hour, Enabled: false},
+	})
+
+	ip := net.IPv4(192, 168, 0, 1)
+	for i := 0; i < 10; i++ {
+		require.Empty(t, d.Hit(ip, time.Now()))
+	}
+	require.Zero(t, d.Len())
+}
+
+func TestDetectorGC(t *testing.T) {
+
+	d := NewDetector([]AutobanProfile{
+		{Limit: 100, Reason: "Test", Window: time.Hour, Time: time.Hour, Enabled: true},
+	})
+
+	now := time.Now()
+	d.Hit(net.IPv4(192, 168, 0, 1), now)
+	d.Hit(net.IPv4(192, 168, 0, 2), now.Add(30*time.Minute))
+	require.Equal(t, 2, d.Len())
+
+	d.Hit(net.IPv4(192, 168, 0, 3), now.Add(2*time.Hour))
+	require.Equal(t, 1, d.Len())
+}
+
+func TestDetectorSubnet(t *testing.T) {
+
+	d := NewDetector([]AutobanProfile{
+		{Limit: 10, Reason: "Test", Window: time.Minute, Time: time.Hour, Enabled: true, IPv4Prefix: 24, IPv6Prefix: 64},
+	})
+
+	now := time.Now()
+	fired := 0
+	for i := 0; i < 11; i++ {
+		fired += len(d.Hit(net.IPv4(192, 168, 0, byte(i)), now))
+	}
+	require.Equal(t, 1, fired)
+
+	require.Empty(t, d.Hit(net.IPv4(192, 168, 1, 1), now))
+	require.Equal(t, 2, d.Len())
+
+	fired = 0
+	for i := 0; i < 11; i++ {
+		fired += len(d.Hit(net.ParseIP(fmt.Sprintf("2001:db8::%x", i+1)), now))
+	}
+	require.Equal(t, 1, fired)
+}
+
+func TestDetectorGCShortestWindow(t *testing.T) {
+
+	d := NewDetector([]AutobanProfile{
+		{Limit: 100, Reason: "Long", Window: 24 * time.Hour, Time: time.Hour, Enabled: true},
+		{Limit: 1, Reason: "Short", Window: time.Minute, Time: time.Hour, Enabled: true, IPv4Prefix: 24},
+	})
+
+	now := time.Now()
+	d.Hit(net.IPv4(192, 168, 0, 1), now)
+	require.Equal(t, 2, d.Len())
+
+	// counter of short profile is reset by firing
+	require.Len(t, d.Hit(net.IPv4(192, 168, 0, 2), now), 1)
+	require.Equal(t, 3, d.Len())
+
+	// collected after shortest window, not after longest one
+	d.Hit(net.IPv4(10, 0, 0, 1), now.Add(2*time.Minute))
+	require.Equal(t, 4, d.Len())
+	require.Len(t, d.entries[1], 1)
+}
+
+func TestDetectorMaxNetworks(t *testing.T) {
+
+	d := NewDetector([]AutobanProfile{
+		{Limit: 2, Reason: "Test", Window: time.Minute, Time: time.Hour, Enabled: true},
+	})
+	d.SetMaxNetworks(2)
+
+	now := time.Now()
+	first := net.IPv4(192, 168, 0, 1)
+	d.Hit(first, now)
+	d.Hit(net.IPv4(192, 168, 0, 2), now)
+	// first becomes most recently hit
+	d.Hit(first, now)
+	d.Hit(net.IPv4(192, 168, 0, 3), now)
+
+	require.Equal(t, 2, d.Len())
+	require.Contains(t, d.entries[0], "192.168.0.1")
+	require.Contains(t, d.entries[0], "192.168.0.3")
+
+	// counter of first is kept
+	require.Len(t, d.Hit(first, now), 1)
+
+	for i := 4; i < 100; i++ {
+		d.Hit(net.IPv4(192, 168, 0, byte(i)), now)
+		require.LessOrEqual(t, d.Len(), 2)
+	}
+}
+
+func TestSlidingCounter(t *testing.T) {
+
+	c := newSlidingCounter(time.Hour)
+	start := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	c.add(start)
+	c.add(start.Add(30 * time.Minute))
+	require.Equal(t, 2, c.sum(start.Add(30*time.Minute)))
+	require.Equal(t, 1, c.sum(start.Add(time.Hour+time.Minute)))
+	require.Equal(t, 0, c.sum(start.Add(2*time.Hour)))
+
+	// late event inside of window is counted, older one is not
+	c.add(start.Add(2 * time.Hour))
+	c.add(start.Add(90 * time.Minute))
+	c.add(start.Add(30 * time.Minute))
+	require.Equal(t, 2, c.sum(start.Add(2*time.Hour)))
+}
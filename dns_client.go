@@ -0,0 +1,358 @@
+package traffic
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/autowp/traffic/util"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+	// dnsAttemptTimeout limits query to single server, so next one is tried in time
+	dnsAttemptTimeout = 2 * time.Second
+	dnsUDPSize        = 1232
+	// dnsMaxCNAMEs limits followed CNAME chain
+	dnsMaxCNAMEs = 8
+)
+
+// TTLResolver resolver which reports how long results are valid
+type TTLResolver interface {
+	Resolver
+	LookupAddrTTL(ctx context.Context, addr string) ([]string, time.Duration, error)
+	LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error)
+}
+
+// DNSClient stub resolver querying recursive nameservers directly, so TTL of records is known
+type DNSClient struct {
+	servers []string
+}
+
+// NewDNSClient constructor. Nameservers of /etc/resolv.conf are used when servers are empty
+func NewDNSClient(servers []string) *DNSClient {
+	if len(servers) == 0 {
+		servers = systemNameservers()
+	}
+
+	result := make([]string, len(servers))
+	for idx, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		result[idx] = server
+	}
+
+	return &DNSClient{
+		servers: result,
+	}
+}
+
+// systemNameservers nameservers of resolv.conf
+func systemNameservers() []string {
+	result := []string{}
+
+	file, err := os.Open(resolvConfPath)
+	if err == nil {
+		defer util.Close(file)
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				result = append(result, fields[1])
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		result = append(result, "127.0.0.1")
+	}
+
+	return result
+}
+
+// LookupAddr returns hostnames of address
+func (c *DNSClient) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	hosts, _, err := c.LookupAddrTTL(ctx, addr)
+	return hosts, err
+}
+
+// LookupIPAddr returns addresses of host
+func (c *DNSClient) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, _, err := c.LookupIPAddrTTL(ctx, host)
+	return addrs, err
+}
+
+// LookupAddrTTL returns hostnames of address and minimal TTL of PTR records
+func (c *DNSClient) LookupAddrTTL(ctx context.Context, addr string) ([]string, time.Duration, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, 0, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+
+	name := reverseName(ip)
+	resources, err := c.query(ctx, name, dnsmessage.TypePTR)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var hosts []string
+	ttl := time.Duration(-1)
+	for _, resource := range chainAnswers(name, resources) {
+		if ptr, ok := resource.Body.(*dnsmessage.PTRResource); ok {
+			hosts = append(hosts, ptr.PTR.String())
+			ttl = minTTL(ttl, resource.Header.TTL)
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	return hosts, ttl, nil
+}
+
+// LookupIPAddrTTL returns IPv4 and IPv6 addresses of host and minimal TTL of records
+func (c *DNSClient) LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	host = normalizeHost(host)
+
+	var addrs []net.IPAddr
+	ttl := time.Duration(-1)
+	var lastErr error
+
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		resources, err := c.query(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, resource := range chainAnswers(host, resources) {
+			switch body := resource.Body.(type) {
+			case *dnsmessage.AResource:
+				addrs = append(addrs, net.IPAddr{IP: net.IP(body.A[:])})
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, net.IPAddr{IP: net.IP(body.AAAA[:])})
+			default:
+				// CNAME chain limits validity of result too
+			}
+			ttl = minTTL(ttl, resource.Header.TTL)
+		}
+	}
+
+	if len(addrs) == 0 {
+		if lastErr != nil {
+			return nil, 0, lastErr
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return addrs, ttl, nil
+}
+
+// chainAnswers selects records of queried name and of CNAME targets it leads to,
+// so unrelated records of spoofed or poisoned response are ignored
+func chainAnswers(name string, resources []dnsmessage.Resource) []dnsmessage.Resource {
+	var result []dnsmessage.Resource
+
+	owner := normalizeHost(name)
+	for i := 0; i <= dnsMaxCNAMEs; i++ {
+		var next string
+		for _, resource := range resources {
+			if normalizeHost(resource.Header.Name.String()) != owner {
+				continue
+			}
+
+			result = append(result, resource)
+			if cname, ok := resource.Body.(*dnsmessage.CNAMEResource); ok {
+				next = normalizeHost(cname.CNAME.String())
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		owner = next
+	}
+
+	return result
+}
+
+func minTTL(current time.Duration, ttl uint32) time.Duration {
+	value := time.Duration(ttl) * time.Second
+	if current < 0 || value < current {
+		return value
+	}
+
+	return current
+}
+
+// query asks nameservers one by one till first answer
+func (c *DNSClient) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+
+	question := dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}
+
+	var lastErr error
+	for _, server := range c.servers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		response, err := c.exchange(ctx, server, question)
+		if err != nil {
+			lastErr = &net.DNSError{Err: err.Error(), Name: name, Server: server, IsTemporary: true}
+			continue
+		}
+
+		switch response.RCode {
+		case dnsmessage.RCodeSuccess:
+			return response.Answers, nil
+		case dnsmessage.RCodeNameError:
+			return nil, &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+		default:
+			lastErr = &net.DNSError{Err: "server misbehaving: " + response.RCode.String(), Name: name, Server: server, IsTemporary: true}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// exchange sends query over UDP, and over TCP when response is truncated
+func (c *DNSClient) exchange(ctx context.Context, server string, question dnsmessage.Question) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsAttemptTimeout)
+	defer cancel()
+
+	// unpredictable ID makes spoofing of responses harder
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	request := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}
+
+	packed, err := request.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.exchangeUDP(ctx, server, packed)
+	if err == nil && response.Truncated {
+		response, err = c.exchangeTCP(ctx, server, packed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if response.ID != id || !response.Response || len(response.Questions) != 1 ||
+		!strings.EqualFold(response.Questions[0].Name.String(), question.Name.String()) ||
+		response.Questions[0].Type != question.Type {
+		return nil, fmt.Errorf("unexpected response")
+	}
+
+	return response, nil
+}
+
+func (c *DNSClient) exchangeUDP(ctx context.Context, server string, packed []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(conn)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, dnsUDPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &dnsmessage.Message{}
+	if err := response.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (c *DNSClient) exchangeTCP(ctx context.Context, server string, packed []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(conn)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	request := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(request, uint16(len(packed)))
+	copy(request[2:], packed)
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	response := &dnsmessage.Message{}
+	if err := response.Unpack(buf); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// reverseName name of PTR record of IP
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	const hexDigits = "0123456789abcdef"
+	ip16 := ip.To16()
+	name := make([]byte, 0, 72)
+	for i := len(ip16) - 1; i >= 0; i-- {
+		name = append(name, hexDigits[ip16[i]&0xF], '.', hexDigits[ip16[i]>>4], '.')
+	}
+
+	return string(name) + "ip6.arpa."
+}
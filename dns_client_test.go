@@ -0,0 +1,115 @@
+package traffic
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSClient(t *testing.T) {
+
+	dns := startFakeDNS(t)
+	dns.ttl = 120
+
+	ip := net.IPv4(66, 249, 66, 1)
+	ip6 := net.ParseIP("2001:4860:4801:10::1")
+	dns.AddPTR(ip, "crawl-66-249-66-1.googlebot.com")
+	dns.AddPTR(ip6, "crawl-66-249-66-1.googlebot.com")
+	dns.AddIP("crawl-66-249-66-1.googlebot.com", ip)
+	dns.AddIP("crawl-66-249-66-1.googlebot.com", ip6)
+
+	// unreachable server is skipped
+	dead, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	deadAddr := dead.LocalAddr().String()
+	require.NoError(t, dead.Close())
+
+	client := NewDNSClient([]string{deadAddr, dns.Addr()})
+	ctx := context.Background()
+
+	hosts, ttl, err := client.LookupAddrTTL(ctx, ip.String())
+	require.NoError(t, err)
+	require.Equal(t, []string{"crawl-66-249-66-1.googlebot.com."}, hosts)
+	require.Equal(t, 120*time.Second, ttl)
+
+	hosts, _, err = client.LookupAddrTTL(ctx, ip6.String())
+	require.NoError(t, err)
+	require.Equal(t, []string{"crawl-66-249-66-1.googlebot.com."}, hosts)
+
+	addrs, ttl, err := client.LookupIPAddrTTL(ctx, "CRAWL-66-249-66-1.googlebot.com")
+	require.NoError(t, err)
+	require.Len(t, addrs, 2)
+	require.True(t, addrs[0].IP.Equal(ip))
+	require.True(t, addrs[1].IP.Equal(ip6))
+	require.Equal(t, 120*time.Second, ttl)
+
+	_, _, err = client.LookupAddrTTL(ctx, "192.0.2.1")
+	require.Error(t, err)
+	dnsErr, ok := err.(*net.DNSError)
+	require.True(t, ok)
+	require.True(t, dnsErr.IsNotFound)
+
+	_, err = client.LookupIPAddr(ctx, "unknown.example.com")
+	require.Error(t, err)
+
+	_, err = client.LookupAddr(ctx, "not an ip")
+	require.Error(t, err)
+}
+
+func TestDNSClientIgnoresUnrelatedRecords(t *testing.T) {
+
+	dns := startFakeDNS(t)
+
+	ip := net.IPv4(192, 0, 2, 1)
+	crawler := net.IPv4(66, 249, 66, 1)
+	dns.AddPTR(ip, "host.example.com")
+	dns.AddIP("host.example.com", ip)
+
+	// records of other names injected into answers
+	dns.addOwned(reverseName(ip), reverseName(crawler), dnsmessage.TypePTR,
+		&dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("crawl-66-249-66-1.googlebot.com.")})
+	dns.addOwned("host.example.com", "crawl-66-249-66-1.googlebot.com", dnsmessage.TypeA,
+		&dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}})
+
+	// alias is followed
+	dns.AddCNAME("www.example.com", "host.example.com")
+
+	client := NewDNSClient([]string{dns.Addr()})
+	ctx := context.Background()
+
+	hosts, err := client.LookupAddr(ctx, ip.String())
+	require.NoError(t, err)
+	require.Equal(t, []string{"host.example.com."}, hosts)
+
+	addrs, err := client.LookupIPAddr(ctx, "host.example.com")
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+	require.True(t, addrs[0].IP.Equal(ip))
+
+	addrs, err = client.LookupIPAddr(ctx, "www.example.com")
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+	require.True(t, addrs[0].IP.Equal(ip))
+}
+
+func TestNewDNSClientServers(t *testing.T) {
+
+	client := NewDNSClient([]string{"192.0.2.53", "192.0.2.54:5353", "2001:db8::53"})
+	require.Equal(t, []string{"192.0.2.53:53", "192.0.2.54:5353", "[2001:db8::53]:53"}, client.servers)
+
+	require.NotEmpty(t, NewDNSClient(nil).servers)
+}
+
+func TestReverseName(t *testing.T) {
+
+	require.Equal(t, "1.66.249.66.in-addr.arpa.", reverseName(net.IPv4(66, 249, 66, 1)))
+	require.Equal(
+		t,
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1.0.0.1.0.8.4.0.6.8.4.1.0.0.2.ip6.arpa.",
+		reverseName(net.ParseIP("2001:4860:4801:10::1")),
+	)
+}
@@ -0,0 +1,150 @@
+package traffic
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	// dossierMinutes number of buckets of per minute histogram
+	dossierMinutes = 60
+	// dossierTenMinutes number of buckets of ten minute histogram
+	dossierTenMinutes = 36
+	// dossierHours number of buckets of hourly histogram
+	dossierHours = 24
+	// dossierBanHistoryLimit number of recent bans of dossier
+	dossierBanHistoryLimit = 50
+)
+
+// HistogramBucket requests during period which starts at Start
+type HistogramBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// IPHistogram requests of IP in last hour by minute, in last six hours by ten minutes and in last day by hour.
+// Counters are kept for current day and for longest window of profiles, so older buckets may be empty
+type IPHistogram struct {
+	Minute    []HistogramBucket `json:"minute"`
+	TenMinute []HistogramBucket `json:"ten_minute"`
+	Hour      []HistogramBucket `json:"hour"`
+}
+
+// ProfileProximity requests of network which profile aggregates IP into, during window of profile
+type ProfileProximity struct {
+	Reason  string        `json:"reason"`
+	Network Network       `json:"network"`
+	Window  time.Duration `json:"window"`
+	Limit   int           `json:"limit"`
+	Count   int           `json:"count"`
+	// Ratio of count to limit, network is banned when it exceeds 1
+	Ratio float64 `json:"ratio"`
+}
+
+// IPDossier everything known about IP
+type IPDossier struct {
+	IP        net.IP         `json:"ip"`
+	Ban       *BanItem       `json:"ban"`
+	Whitelist *WhitelistItem `json:"whitelist"`
+	// Profiles enabled autoban profiles, closest to ban first
+	Profiles   []ProfileProximity `json:"profiles"`
+	Histogram  IPHistogram        `json:"histogram"`
+	BanHistory []BanHistoryItem   `json:"ban_history"`
+	Hostnames  []string           `json:"hostnames"`
+	// HostnamesError failure of reverse DNS lookup
+	HostnamesError string `json:"hostnames_error,omitempty"`
+}
+
+// histogram sums per minute counters into count buckets of width which end with bucket of now
+func histogram(buckets []MonitoringBucket, width time.Duration, count int, now time.Time) []HistogramBucket {
+	first := now.Truncate(width).Add(-time.Duration(count-1) * width)
+
+	result := make([]HistogramBucket, count)
+	for idx := range result {
+		result[idx].Start = first.Add(time.Duration(idx) * width)
+	}
+
+	for _, bucket := range buckets {
+		idx := int(bucket.Minute.Sub(first) / width)
+		if bucket.Minute.Before(first) || idx >= count {
+			continue
+		}
+		result[idx].Count += bucket.Count
+	}
+
+	return result
+}
+
+// Dossier collects ban and whitelist state, profile counters, traffic histogram, ban history and hostnames of IP
+func (s *Traffic) Dossier(ip net.IP) (IPDossier, error) {
+	ip = normalizeIP(ip)
+	now := time.Now()
+
+	result := IPDossier{
+		IP:       ip,
+		Profiles: make([]ProfileProximity, 0),
+	}
+
+	var err error
+	result.Ban, err = s.Ban.Get(ip)
+	if err != nil {
+		return result, err
+	}
+
+	result.Whitelist, err = s.Whitelist.Get(ip)
+	if err != nil {
+		return result, err
+	}
+
+	for _, profile := range s.autobanProfiles {
+		if !profile.Enabled {
+			continue
+		}
+
+		network, count, err := s.Monitoring.CountByProfile(ip, profile)
+		if err != nil {
+			return result, err
+		}
+
+		result.Profiles = append(result.Profiles, ProfileProximity{
+			Reason:  profile.Reason,
+			Network: network,
+			Window:  profile.Window,
+			Limit:   profile.Limit,
+			Count:   count,
+			Ratio:   float64(count) / float64(profile.Limit),
+		})
+	}
+
+	sort.SliceStable(result.Profiles, func(i, j int) bool {
+		return result.Profiles[i].Ratio > result.Profiles[j].Ratio
+	})
+
+	timeline, err := s.Monitoring.Timeline(ip, now.Truncate(time.Hour).Add(-(dossierHours-1)*time.Hour))
+	if err != nil {
+		return result, err
+	}
+
+	result.Histogram = IPHistogram{
+		Minute:    histogram(timeline, time.Minute, dossierMinutes, now),
+		TenMinute: histogram(timeline, 10*time.Minute, dossierTenMinutes, now),
+		Hour:      histogram(timeline, time.Hour, dossierHours, now),
+	}
+
+	result.BanHistory, err = s.Ban.History(ip, dossierBanHistoryLimit)
+	if err != nil {
+		return result, err
+	}
+
+	// failed lookup does not hide the rest of dossier
+	result.Hostnames, err = s.Whitelist.Hostnames(ip)
+	if err != nil {
+		result.HostnamesError = err.Error()
+	}
+	if result.Hostnames == nil {
+		result.Hostnames = make([]string, 0)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package traffic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	now := time.Date(2020, 1, 2, 10, 25, 30, 0, time.UTC)
+	buckets := []MonitoringBucket{
+		{Minute: time.Date(2020, 1, 2, 9, 0, 0, 0, time.UTC), Count: 100},
+		{Minute: time.Date(2020, 1, 2, 10, 5, 0, 0, time.UTC), Count: 1},
+		{Minute: time.Date(2020, 1, 2, 10, 23, 0, 0, time.UTC), Count: 2},
+		{Minute: time.Date(2020, 1, 2, 10, 25, 0, 0, time.UTC), Count: 3},
+	}
+
+	minutes := histogram(buckets, time.Minute, 3, now)
+	require.Equal(t, []HistogramBucket{
+		{Start: time.Date(2020, 1, 2, 10, 23, 0, 0, time.UTC), Count: 2},
+		{Start: time.Date(2020, 1, 2, 10, 24, 0, 0, time.UTC), Count: 0},
+		{Start: time.Date(2020, 1, 2, 10, 25, 0, 0, time.UTC), Count: 3},
+	}, minutes)
+
+	tenMinutes := histogram(buckets, 10*time.Minute, 3, now)
+	require.Equal(t, []int{1, 0, 5}, histogramCounts(tenMinutes))
+	require.Equal(t, time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC), tenMinutes[0].Start)
+
+	hours := histogram(buckets, time.Hour, 2, now)
+	require.Equal(t, []int{100, 6}, histogramCounts(hours))
+}
+
+func histogramCounts(buckets []HistogramBucket) []int {
+	result := make([]int, len(buckets))
+	for idx, bucket := range buckets {
+		result[idx] = bucket.Count
+	}
+
+	return result
+}
+
+func TestMemoryDossier(t *testing.T) {
+	s, dns := createMemoryTrafficServiceWithDNS(t)
+	s.autobanProfiles = []AutobanProfile{
+		{Limit: 10, Reason: "single", Window: time.Hour, Time: time.Hour, Enabled: true},
+		{Limit: 4, Reason: "subnet", Window: time.Hour, Time: time.Hour, Enabled: true, IPv4Prefix: 24},
+		{Limit: 1, Reason: "disabled", Window: time.Hour, Time: time.Hour},
+	}
+
+	ip := net.IPv4(192, 0, 2, 1)
+	dns.AddPTR(ip, "host.example.com")
+
+	now := time.Now()
+	require.NoError(t, s.Monitoring.AddBatch([]MonitoringBucket{
+		{IP: ip, Minute: now.Truncate(time.Minute), Count: 2},
+		{IP: ip, Minute: now.Add(-30 * time.Minute).Truncate(time.Minute), Count: 1},
+		{IP: net.IPv4(192, 0, 2, 2), Minute: now.Truncate(time.Minute), Count: 1},
+	}))
+
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "192.0.2.0/24"), -time.Hour, 1, "expired"))
+	require.NoError(t, s.Ban.Add(ip, time.Hour, 1, "Test"))
+
+	dossier, err := s.Dossier(ip)
+	require.NoError(t, err)
+
+	require.Equal(t, "192.0.2.1", dossier.IP.String())
+	require.NotNil(t, dossier.Ban)
+	require.Equal(t, "Test", dossier.Ban.Reason)
+	require.Equal(t, 1, dossier.Ban.Offences)
+	require.Nil(t, dossier.Whitelist)
+
+	require.Len(t, dossier.Profiles, 2)
+	require.Equal(t, "subnet", dossier.Profiles[0].Reason)
+	require.Equal(t, "192.0.2.0/24", dossier.Profiles[0].Network.String())
+	require.Equal(t, 4, dossier.Profiles[0].Count)
+	require.Equal(t, 1.0, dossier.Profiles[0].Ratio)
+	require.Equal(t, "single", dossier.Profiles[1].Reason)
+	require.Equal(t, 3, dossier.Profiles[1].Count)
+
+	require.Len(t, dossier.Histogram.Minute, dossierMinutes)
+	require.Equal(t, 2, dossier.Histogram.Minute[dossierMinutes-1].Count)
+	require.Len(t, dossier.Histogram.TenMinute, dossierTenMinutes)
+	require.Len(t, dossier.Histogram.Hour, dossierHours)
+	total := 0
+	for _, bucket := range dossier.Histogram.Hour {
+		total += bucket.Count
+	}
+	require.Equal(t, 3, total)
+
+	require.Len(t, dossier.BanHistory, 2)
+	require.Equal(t, "Test", dossier.BanHistory[0].Reason)
+	require.Equal(t, "expired", dossier.BanHistory[1].Reason)
+
+	require.Equal(t, []string{"host.example.com."}, dossier.Hostnames)
+	require.Empty(t, dossier.HostnamesError)
+
+	require.NoError(t, s.Whitelist.Add(ip, "Test"))
+
+	dossier, err = s.Dossier(net.IPv4(192, 0, 2, 1))
+	require.NoError(t, err)
+	require.NotNil(t, dossier.Whitelist)
+	require.Equal(t, "Test", dossier.Whitelist.Description)
+	require.Equal(t, []string{"host.example.com."}, dossier.Hostnames)
+}
@@ -0,0 +1,321 @@
+package traffic
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+)
+
+const (
+	// ExportFormatPlain one network per line
+	ExportFormatPlain = "plain"
+	// ExportFormatNginx `deny` directives to include into http, server or location context
+	ExportFormatNginx = "nginx"
+	// ExportFormatNginxGeo entries to include into `geo $banned { default 0; include ...; }` block
+	ExportFormatNginxGeo = "nginx-geo"
+	// ExportFormatIpset input of `ipset restore` which swaps sets of both families atomically
+	ExportFormatIpset = "ipset"
+	// ExportFormatNft input of `nft -f` which replaces elements of sets of both families in single transaction
+	ExportFormatNft = "nft"
+	// ExportFormatHAProxy map file for `src,map_ip(...)`
+	ExportFormatHAProxy = "haproxy"
+
+	// DefaultExportName name of ipset and nft sets, suffixed with family
+	DefaultExportName = "traffic_ban"
+
+	// DefaultIpsetMaxElem capacity of ipset sets when it is not given
+	DefaultIpsetMaxElem = 65536
+)
+
+// ExportFormats supported formats of ban export
+var ExportFormats = []string{
+	ExportFormatPlain,
+	ExportFormatNginx,
+	ExportFormatNginxGeo,
+	ExportFormatIpset,
+	ExportFormatNft,
+	ExportFormatHAProxy,
+}
+
+var exportNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,24}$`)
+
+// ValidExportName reports name which can be used as name of ipset and nft sets. Empty means default
+func ValidExportName(name string) bool {
+	return name == "" || exportNameRegexp.MatchString(name)
+}
+
+// ValidExportFormat reports supported format of ban export
+func ValidExportFormat(format string) bool {
+	for _, value := range ExportFormats {
+		if value == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lastIP last address of network
+func lastIP(n Network) net.IP {
+	ip := normalizeIP(n.IP)
+	result := make(net.IP, len(ip))
+	for idx := range ip {
+		result[idx] = ip[idx] | ^n.Mask[len(n.Mask)-len(ip)+idx]
+	}
+
+	return result
+}
+
+// compareIP orders IPv4 before IPv6 and addresses of family numerically
+func compareIP(a, b net.IP) int {
+	a, b = normalizeIP(a), normalizeIP(b)
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+
+	return bytes.Compare(a, b)
+}
+
+// sortNetworks orders networks by first address, containing network goes before its subnets
+func sortNetworks(networks []Network) {
+	sort.Slice(networks, func(i, j int) bool {
+		if cmp := compareIP(networks[i].IP, networks[j].IP); cmp != 0 {
+			return cmp < 0
+		}
+
+		iOnes, _ := networks[i].Mask.Size()
+		jOnes, _ := networks[j].Mask.Size()
+
+		return iOnes < jOnes
+	})
+}
+
+// collapseNetworks returns sorted disjoint networks, subnets of other networks are dropped
+func collapseNetworks(networks []Network) []Network {
+	sorted := append([]Network(nil), networks...)
+	sortNetworks(sorted)
+
+	result := make([]Network, 0, len(sorted))
+	for _, network := range sorted {
+		if len(result) > 0 && result[len(result)-1].ContainsNetwork(network) {
+			continue
+		}
+
+		result = append(result, network)
+	}
+
+	return result
+}
+
+// splitNetwork halves of network
+func splitNetwork(n Network) (Network, Network) {
+	ones, bits := n.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+
+	low := normalizeIP(n.IP).Mask(mask)
+	high := make(net.IP, len(low))
+	copy(high, low)
+	high[ones/8] |= 0x80 >> uint(ones%8)
+
+	return Network{net.IPNet{IP: low, Mask: mask}}, Network{net.IPNet{IP: high, Mask: mask}}
+}
+
+// subtractNetworks covers network without holes by CIDR blocks. Holes are disjoint subnets of network
+func subtractNetworks(network Network, holes []Network) []Network {
+	if len(holes) == 0 {
+		return []Network{network}
+	}
+
+	ones, _ := network.Mask.Size()
+	for _, hole := range holes {
+		if holeOnes, _ := hole.Mask.Size(); holeOnes == ones {
+			return nil
+		}
+	}
+
+	low, high := splitNetwork(network)
+	var lowHoles, highHoles []Network
+	for _, hole := range holes {
+		if low.ContainsNetwork(hole) {
+			lowHoles = append(lowHoles, hole)
+		} else {
+			highHoles = append(highHoles, hole)
+		}
+	}
+
+	return append(subtractNetworks(low, lowHoles), subtractNetworks(high, highHoles)...)
+}
+
+// excludeNetworks returns sorted disjoint CIDR blocks covering networks except excluded ones
+func excludeNetworks(networks []Network, excluded []Network) []Network {
+	networks = collapseNetworks(networks)
+	excluded = collapseNetworks(excluded)
+
+	result := make([]Network, 0, len(networks))
+	for _, network := range networks {
+		// first excluded network which starts with network start or after it
+		idx := sort.Search(len(excluded), func(i int) bool {
+			return compareIP(excluded[i].IP, network.IP) >= 0
+		})
+
+		// excluded networks are disjoint, so only one of preceding ones may contain network
+		contained := idx > 0 && excluded[idx-1].ContainsNetwork(network)
+
+		last := lastIP(network)
+		var holes []Network
+		for ; !contained && idx < len(excluded) && compareIP(excluded[idx].IP, last) <= 0; idx++ {
+			if excluded[idx].ContainsNetwork(network) {
+				contained = true
+				break
+			}
+			holes = append(holes, excluded[idx])
+		}
+
+		if !contained {
+			result = append(result, subtractNetworks(network, holes)...)
+		}
+	}
+
+	return result
+}
+
+// splitFamilies IPv4 and IPv6 networks
+func splitFamilies(networks []Network) ([]Network, []Network) {
+	var ipv4, ipv6 []Network
+	for _, network := range networks {
+		if _, bits := network.Mask.Size(); bits == net.IPv4len*8 {
+			ipv4 = append(ipv4, network)
+		} else {
+			ipv6 = append(ipv6, network)
+		}
+	}
+
+	return ipv4, ipv6
+}
+
+// RenderBanExport writes networks in given format. Name is used by ipset and nft formats.
+// MaxElem is capacity of ipset sets, DefaultIpsetMaxElem when zero. Existing sets are reused by `create -exist`,
+// which fails when capacity differs, so it must not change between renders
+func RenderBanExport(writer io.Writer, format string, name string, networks []Network, maxElem int) error {
+	if !ValidExportName(name) {
+		return fmt.Errorf("invalid name `%s`", name)
+	}
+
+	if name == "" {
+		name = DefaultExportName
+	}
+
+	if maxElem < 0 {
+		return fmt.Errorf("maxelem must not be negative")
+	}
+
+	if maxElem == 0 {
+		maxElem = DefaultIpsetMaxElem
+	}
+
+	w := bufio.NewWriter(writer)
+
+	switch format {
+	case ExportFormatPlain:
+		for _, network := range networks {
+			fmt.Fprintln(w, network.String())
+		}
+
+	case ExportFormatNginx:
+		for _, network := range networks {
+			fmt.Fprintf(w, "deny %s;\n", network.String())
+		}
+
+	case ExportFormatNginxGeo:
+		for _, network := range networks {
+			fmt.Fprintf(w, "%s 1;\n", network.String())
+		}
+
+	case ExportFormatHAProxy:
+		for _, network := range networks {
+			fmt.Fprintf(w, "%s 1\n", network.String())
+		}
+
+	case ExportFormatIpset:
+		ipv4, ipv6 := splitFamilies(networks)
+		if len(ipv4) > maxElem || len(ipv6) > maxElem {
+			return fmt.Errorf("number of networks exceeds maxelem %d of ipset", maxElem)
+		}
+		renderIpsetFamily(w, name+"_v4", "inet", ipv4, maxElem)
+		renderIpsetFamily(w, name+"_v6", "inet6", ipv6, maxElem)
+
+	case ExportFormatNft:
+		ipv4, ipv6 := splitFamilies(networks)
+		fmt.Fprintf(w, "table inet %s {\n", name)
+		fmt.Fprintf(w, "\tset %s_v4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t}\n", name)
+		fmt.Fprintf(w, "\tset %s_v6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t}\n", name)
+		fmt.Fprintln(w, "}")
+		renderNftFamily(w, name, name+"_v4", ipv4)
+		renderNftFamily(w, name, name+"_v6", ipv6)
+
+	default:
+		return fmt.Errorf("unsupported format `%s`", format)
+	}
+
+	return w.Flush()
+}
+
+// renderIpsetFamily fills temporary set and swaps it with live one. Both sets are created with the same
+// fixed capacity, so `create -exist` accepts sets left by previous runs
+func renderIpsetFamily(w io.Writer, set string, family string, networks []Network, maxElem int) {
+	tmp := set + "_tmp"
+	fmt.Fprintf(w, "create %s hash:net family %s maxelem %d -exist\n", set, family, maxElem)
+	fmt.Fprintf(w, "create %s hash:net family %s maxelem %d -exist\n", tmp, family, maxElem)
+	fmt.Fprintf(w, "flush %s\n", tmp)
+	for _, network := range networks {
+		fmt.Fprintf(w, "add %s %s\n", tmp, network.String())
+	}
+	fmt.Fprintf(w, "swap %s %s\n", tmp, set)
+	fmt.Fprintf(w, "destroy %s\n", tmp)
+}
+
+func renderNftFamily(w io.Writer, table string, set string, networks []Network) {
+	fmt.Fprintf(w, "flush set inet %s %s\n", table, set)
+	if len(networks) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "add element inet %s %s {\n", table, set)
+	for idx, network := range networks {
+		separator := ","
+		if idx == len(networks)-1 {
+			separator = ""
+		}
+		fmt.Fprintf(w, "\t%s%s\n", network.String(), separator)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// BanExport networks which are banned and not whitelisted, as sorted disjoint CIDR blocks
+func (s *Traffic) BanExport() ([]Network, error) {
+	bans, err := s.Ban.List()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.Whitelist.List()
+	if err != nil {
+		return nil, err
+	}
+
+	banned := make([]Network, len(bans))
+	for idx, item := range bans {
+		banned[idx] = item.IP
+	}
+
+	whitelisted := make([]Network, len(items))
+	for idx, item := range items {
+		whitelisted[idx] = item.IP
+	}
+
+	return excludeNetworks(banned, whitelisted), nil
+}
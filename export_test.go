@@ -0,0 +1,123 @@
+package traffic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func networkStrings(networks []Network) []string {
+	result := make([]string, len(networks))
+	for idx, network := range networks {
+		result[idx] = network.String()
+	}
+
+	return result
+}
+
+func parseNetworks(t *testing.T, values ...string) []Network {
+	result := make([]Network, len(values))
+	for idx, value := range values {
+		result[idx] = mustParseNetwork(t, value)
+	}
+
+	return result
+}
+
+func TestExcludeNetworks(t *testing.T) {
+
+	networks := parseNetworks(t,
+		"2001:db8::/32",
+		"192.0.2.0/24",
+		"192.0.2.7",
+		"198.51.100.1",
+		"198.51.100.2",
+		"203.0.113.0/24",
+	)
+
+	excluded := parseNetworks(t,
+		"192.0.2.0/26",
+		"192.0.2.255",
+		"198.51.100.0/30",
+		"203.0.113.0/24",
+		"2001:db8:8000::/33",
+	)
+
+	require.Equal(t, []string{
+		"192.0.2.64/26",
+		"192.0.2.128/26",
+		"192.0.2.192/27",
+		"192.0.2.224/28",
+		"192.0.2.240/29",
+		"192.0.2.248/30",
+		"192.0.2.252/31",
+		"192.0.2.254",
+		"2001:db8::/33",
+	}, networkStrings(excludeNetworks(networks, excluded)))
+
+	require.Equal(t, []string{"192.0.2.0/24", "198.51.100.1"}, networkStrings(excludeNetworks(
+		parseNetworks(t, "198.51.100.1", "192.0.2.0/24", "192.0.2.1"),
+		nil,
+	)))
+}
+
+func TestRenderBanExport(t *testing.T) {
+	networks := parseNetworks(t, "192.0.2.1", "198.51.100.0/24", "2001:db8::/32")
+
+	render := func(format string, name string) string {
+		var buf bytes.Buffer
+		require.NoError(t, RenderBanExport(&buf, format, name, networks, 0))
+		return buf.String()
+	}
+
+	require.Equal(t, "192.0.2.1\n198.51.100.0/24\n2001:db8::/32\n", render(ExportFormatPlain, ""))
+	require.Equal(t, "deny 192.0.2.1;\ndeny 198.51.100.0/24;\ndeny 2001:db8::/32;\n", render(ExportFormatNginx, ""))
+	require.Equal(t, "192.0.2.1 1;\n198.51.100.0/24 1;\n2001:db8::/32 1;\n", render(ExportFormatNginxGeo, ""))
+	require.Equal(t, "192.0.2.1 1\n198.51.100.0/24 1\n2001:db8::/32 1\n", render(ExportFormatHAProxy, ""))
+
+	require.Equal(t, "create ban_v4 hash:net family inet maxelem 65536 -exist\n"+
+		"create ban_v4_tmp hash:net family inet maxelem 65536 -exist\n"+
+		"flush ban_v4_tmp\n"+
+		"add ban_v4_tmp 192.0.2.1\n"+
+		"add ban_v4_tmp 198.51.100.0/24\n"+
+		"swap ban_v4_tmp ban_v4\n"+
+		"destroy ban_v4_tmp\n"+
+		"create ban_v6 hash:net family inet6 maxelem 65536 -exist\n"+
+		"create ban_v6_tmp hash:net family inet6 maxelem 65536 -exist\n"+
+		"flush ban_v6_tmp\n"+
+		"add ban_v6_tmp 2001:db8::/32\n"+
+		"swap ban_v6_tmp ban_v6\n"+
+		"destroy ban_v6_tmp\n", render(ExportFormatIpset, "ban"))
+
+	require.Equal(t, "table inet traffic_ban {\n"+
+		"\tset traffic_ban_v4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t}\n"+
+		"\tset traffic_ban_v6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t}\n"+
+		"}\n"+
+		"flush set inet traffic_ban traffic_ban_v4\n"+
+		"add element inet traffic_ban traffic_ban_v4 {\n\t192.0.2.1,\n\t198.51.100.0/24\n}\n"+
+		"flush set inet traffic_ban traffic_ban_v6\n"+
+		"add element inet traffic_ban traffic_ban_v6 {\n\t2001:db8::/32\n}\n", render(ExportFormatNft, ""))
+
+	var buf bytes.Buffer
+	require.Error(t, RenderBanExport(&buf, "iptables", "", networks, 0))
+	require.Error(t, RenderBanExport(&buf, ExportFormatIpset, "bad name", networks, 0))
+
+	// capacity doesn't depend on number of networks, so sets of previous run are reused
+	buf.Reset()
+	require.NoError(t, RenderBanExport(&buf, ExportFormatIpset, "ban", networks[:1], 2))
+	require.Equal(t, "create ban_v4 hash:net family inet maxelem 2 -exist\n"+
+		"create ban_v4_tmp hash:net family inet maxelem 2 -exist\n"+
+		"flush ban_v4_tmp\n"+
+		"add ban_v4_tmp 192.0.2.1\n"+
+		"swap ban_v4_tmp ban_v4\n"+
+		"destroy ban_v4_tmp\n"+
+		"create ban_v6 hash:net family inet6 maxelem 2 -exist\n"+
+		"create ban_v6_tmp hash:net family inet6 maxelem 2 -exist\n"+
+		"flush ban_v6_tmp\n"+
+		"swap ban_v6_tmp ban_v6\n"+
+		"destroy ban_v6_tmp\n", buf.String())
+
+	require.Error(t, RenderBanExport(&buf, ExportFormatIpset, "ban", networks, 1))
+	require.Error(t, RenderBanExport(&buf, ExportFormatIpset, "ban", networks, -1))
+}
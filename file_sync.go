@@ -0,0 +1,249 @@
+package traffic
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/autowp/traffic/util"
+)
+
+// SyncFileConfig SyncFileConfig
+type SyncFileConfig struct {
+	Path   string `yaml:"path"   mapstructure:"path"`
+	Format string `yaml:"format" mapstructure:"format"`
+	// Name of ipset and nft sets, default is used when empty
+	Name string `yaml:"name" mapstructure:"name"`
+	// MaxElem capacity of ipset sets, 65536 when zero. Changing it requires sets to be destroyed
+	MaxElem int `yaml:"maxelem" mapstructure:"maxelem"`
+	// ReloadCommand is run with `sh -c` after file is changed, e.g. `nginx -s reload`
+	ReloadCommand string `yaml:"reload_command" mapstructure:"reload_command"`
+}
+
+// Validate checks that file can be synced
+func (c SyncFileConfig) Validate() error {
+	if strings.TrimSpace(c.Path) == "" {
+		return fmt.Errorf("path not provided")
+	}
+
+	if !ValidExportFormat(c.Format) {
+		return fmt.Errorf("unsupported format `%s`", c.Format)
+	}
+
+	if !ValidExportName(c.Name) {
+		return fmt.Errorf("invalid name `%s`", c.Name)
+	}
+
+	if c.MaxElem < 0 {
+		return fmt.Errorf("maxelem must not be negative")
+	}
+
+	return nil
+}
+
+// FileSyncer keeps files of ban export up to date and runs reload commands after they change
+type FileSyncer struct {
+	export   func() ([]Network, error)
+	files    []SyncFileConfig
+	interval time.Duration
+	debounce time.Duration
+	maxWait  time.Duration
+	logger   *util.Logger
+	// trigger signals notified changes to Run
+	trigger    chan struct{}
+	runCommand func(command string) error
+}
+
+// NewFileSyncer constructor. Export returns networks to be written
+func NewFileSyncer(export func() ([]Network, error), config SyncFilesConfig, logger *util.Logger) *FileSyncer {
+	s := &FileSyncer{
+		export:   export,
+		files:    config.Files,
+		interval: config.Interval,
+		debounce: config.Debounce,
+		maxWait:  config.MaxWait,
+		logger:   logger,
+		trigger:  make(chan struct{}, 1),
+	}
+	s.runCommand = s.shell
+
+	return s
+}
+
+func (s *FileSyncer) shell(command string) error {
+	output, err := exec.Command("sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("`%s`: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// writeFileAtomic replaces file with temporary file written next to it, so readers never see partial content
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Sync renders every file and writes those which content differs.
+// Returns distinct reload commands of changed files in order of files
+func (s *FileSyncer) Sync() ([]string, error) {
+	networks, err := s.export()
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []string
+	var firstErr error
+	for _, file := range s.files {
+		var body bytes.Buffer
+		err := RenderBanExport(&body, file.Format, file.Name, networks, file.MaxElem)
+		if err == nil {
+			var current []byte
+			current, err = ioutil.ReadFile(file.Path)
+			if err == nil && bytes.Equal(current, body.Bytes()) {
+				continue
+			}
+
+			err = writeFileAtomic(file.Path, body.Bytes(), 0644)
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", file.Path, err)
+			}
+			continue
+		}
+
+		fmt.Printf("`%v` networks written to %s\n", len(networks), file.Path)
+
+		if file.ReloadCommand != "" && !containsString(commands, file.ReloadCommand) {
+			commands = append(commands, file.ReloadCommand)
+		}
+	}
+
+	return commands, firstErr
+}
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resync implements ChangeObserver
+func (s *FileSyncer) Resync() error {
+	s.Notify(ChangeNotification{})
+
+	return nil
+}
+
+// Notify implements ChangeObserver. Files are synced by Run
+func (s *FileSyncer) Notify(notification ChangeNotification) {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run syncs files at start, every interval and on notified changes until quit.
+// Reload commands of changed files are run once files stay unchanged during debounce period,
+// but no later than max wait after first change, and on quit
+func (s *FileSyncer) Run(quitChan chan bool) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var pending []string
+	var pendingSince time.Time
+	var debounce <-chan time.Time
+
+	sync := func() {
+		commands, err := s.Sync()
+		if err != nil {
+			s.logger.Warning(err)
+		}
+
+		if len(commands) == 0 {
+			return
+		}
+
+		if len(pending) == 0 {
+			pendingSince = time.Now()
+		}
+
+		for _, command := range commands {
+			if !containsString(pending, command) {
+				pending = append(pending, command)
+			}
+		}
+
+		wait := s.debounce
+		if s.maxWait > 0 {
+			if left := s.maxWait - time.Since(pendingSince); left < wait {
+				wait = left
+			}
+		}
+		debounce = time.After(wait)
+	}
+
+	reload := func() {
+		for _, command := range pending {
+			fmt.Printf("Reload: %s\n", command)
+			err := s.runCommand(command)
+			if err != nil {
+				s.logger.Warning(err)
+			}
+		}
+		pending = nil
+		debounce = nil
+	}
+
+	sync()
+
+	for {
+		select {
+		case <-ticker.C:
+			sync()
+		case <-s.trigger:
+			sync()
+		case <-debounce:
+			reload()
+		case <-quitChan:
+			// files are already written, so they are not left unapplied
+			reload()
+			return
+		}
+	}
+}
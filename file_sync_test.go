@@ -0,0 +1,175 @@
+package traffic
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/autowp/traffic/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSyncerSync(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	dir, err := ioutil.TempDir("", "traffic")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	nginxPath := filepath.Join(dir, "ban.conf")
+	plainPath := filepath.Join(dir, "ban.txt")
+
+	syncer := NewFileSyncer(s.BanExport, SyncFilesConfig{
+		Interval: time.Hour,
+		Files: []SyncFileConfig{
+			{Path: nginxPath, Format: ExportFormatNginx, ReloadCommand: "nginx -s reload"},
+			{Path: plainPath, Format: ExportFormatPlain, ReloadCommand: "nginx -s reload"},
+		},
+	}, util.NewLogger(LoadConfig().Sentry))
+
+	commands, err := syncer.Sync()
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx -s reload"}, commands)
+
+	content, err := ioutil.ReadFile(nginxPath)
+	require.NoError(t, err)
+	require.Equal(t, "", string(content))
+
+	// unchanged files are not written
+	commands, err = syncer.Sync()
+	require.NoError(t, err)
+	require.Empty(t, commands)
+
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "192.0.2.1"), time.Hour, 1, "Test"))
+
+	commands, err = syncer.Sync()
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx -s reload"}, commands)
+
+	content, err = ioutil.ReadFile(nginxPath)
+	require.NoError(t, err)
+	require.Equal(t, "deny 192.0.2.1;\n", string(content))
+
+	content, err = ioutil.ReadFile(plainPath)
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.1\n", string(content))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestFileSyncerRunDebouncesReload(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	dir, err := ioutil.TempDir("", "traffic")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	syncer := NewFileSyncer(s.BanExport, SyncFilesConfig{
+		Interval: time.Hour,
+		Debounce: 200 * time.Millisecond,
+		Files: []SyncFileConfig{
+			{Path: filepath.Join(dir, "ban.txt"), Format: ExportFormatPlain, ReloadCommand: "reload"},
+		},
+	}, util.NewLogger(LoadConfig().Sentry))
+
+	var mutex sync.Mutex
+	reloads := 0
+	syncer.runCommand = func(command string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		reloads++
+		return nil
+	}
+	getReloads := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return reloads
+	}
+
+	quit := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		syncer.Run(quit)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return getReloads() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, s.Ban.Add(net.IPv4(192, 0, 2, byte(i)), time.Hour, 1, "Test"))
+		syncer.Notify(ChangeNotification{})
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	require.Eventually(t, func() bool {
+		return getReloads() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, 2, getReloads())
+
+	close(quit)
+	<-done
+}
+
+func TestFileSyncerRunReloadsWithinMaxWait(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	dir, err := ioutil.TempDir("", "traffic")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	syncer := NewFileSyncer(s.BanExport, SyncFilesConfig{
+		Interval: time.Hour,
+		Debounce: 200 * time.Millisecond,
+		MaxWait:  400 * time.Millisecond,
+		Files: []SyncFileConfig{
+			{Path: filepath.Join(dir, "ban.txt"), Format: ExportFormatPlain, ReloadCommand: "reload"},
+		},
+	}, util.NewLogger(LoadConfig().Sentry))
+
+	var mutex sync.Mutex
+	reloads := 0
+	syncer.runCommand = func(command string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		reloads++
+		return nil
+	}
+	getReloads := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return reloads
+	}
+
+	quit := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		syncer.Run(quit)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return getReloads() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// changes keep coming faster than debounce period
+	for i := 1; i <= 20; i++ {
+		require.NoError(t, s.Ban.Add(net.IPv4(192, 0, 2, byte(i)), time.Hour, 1, "Test"))
+		syncer.Notify(ChangeNotification{})
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	require.GreaterOrEqual(t, getReloads(), 2)
+
+	close(quit)
+	<-done
+}
@@ -0,0 +1,134 @@
+package traffic
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultListLimit number of items of page when limit is not given
+	DefaultListLimit = 100
+	// MaxListLimit largest number of items of page
+	MaxListLimit = 1000
+)
+
+// ListSortIP orders listing by network, available for every listing
+const ListSortIP = "ip"
+
+// ListSort field and direction of listing order. Items with equal field are ordered by network
+type ListSort struct {
+	Field string
+	Desc  bool
+}
+
+// ParseListSort parses field name, optionally prefixed with minus for descending order.
+// Empty value is first of allowed fields in ascending order
+func ParseListSort(value string, fields ...string) (ListSort, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return ListSort{Field: fields[0]}, nil
+	}
+
+	sort := ListSort{Field: strings.TrimPrefix(value, "-")}
+	sort.Desc = sort.Field != value
+
+	for _, field := range fields {
+		if field == sort.Field {
+			return sort, nil
+		}
+	}
+
+	return ListSort{}, fmt.Errorf("unsupported sort `%s`, expected one of: %s", value, strings.Join(fields, ", "))
+}
+
+func (s ListSort) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+
+	return s.Field
+}
+
+// ListCursor sort key of last item of page, next page starts after it
+type ListCursor struct {
+	IP Network `json:"ip"`
+	// Time value of time field of sort, ignored for sort by network
+	Time time.Time `json:"t"`
+}
+
+// String opaque form of cursor passed to clients
+func (c ListCursor) String() string {
+	data, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseListCursor parses cursor returned with previous page, nil for empty value
+func ParseListCursor(value string) (*ListCursor, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var cursor ListCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.IP.IP == nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &cursor, nil
+}
+
+// listLimit clamps requested number of items of page
+func listLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultListLimit
+	}
+
+	if limit > MaxListLimit {
+		return MaxListLimit
+	}
+
+	return limit
+}
+
+// compareNetworks orders networks as inet values of Postgres: IPv4 first, then by address, containing network first
+func compareNetworks(a, b Network) int {
+	if cmp := compareIP(a.IP, b.IP); cmp != 0 {
+		return cmp
+	}
+
+	aOnes, _ := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+
+	return aOnes - bOnes
+}
+
+// compareListKeys compares sort keys of two items in order of sort
+func compareListKeys(sort ListSort, a ListCursor, b ListCursor) int {
+	cmp := 0
+	if sort.Field != ListSortIP {
+		switch {
+		case a.Time.Before(b.Time):
+			cmp = -1
+		case a.Time.After(b.Time):
+			cmp = 1
+		}
+	}
+
+	if cmp == 0 {
+		cmp = compareNetworks(a.IP, b.IP)
+	}
+
+	if sort.Desc {
+		return -cmp
+	}
+
+	return cmp
+}
@@ -0,0 +1,69 @@
+package traffic
+
+import (
+	"strconv"
+	"strings"
+)
+
+// postgresFilter conditions of dynamic query with their numbered arguments
+type postgresFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg adds argument and returns its placeholder
+func (f *postgresFilter) arg(value interface{}) string {
+	f.args = append(f.args, value)
+
+	return "$" + strconv.Itoa(len(f.args))
+}
+
+func (f *postgresFilter) where(condition string) {
+	f.conditions = append(f.conditions, condition)
+}
+
+func (f *postgresFilter) sql() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// after adds keyset condition which skips items up to cursor
+func (f *postgresFilter) after(sort ListSort, timeColumn string, cursor *ListCursor) {
+	if cursor == nil {
+		return
+	}
+
+	op := ">"
+	if sort.Desc {
+		op = "<"
+	}
+
+	if sort.Field == ListSortIP {
+		f.where("ip " + op + " " + f.arg(cursor.IP))
+		return
+	}
+
+	f.where("(" + timeColumn + ", ip) " + op + " (" + f.arg(cursor.Time) + ", " + f.arg(cursor.IP) + ")")
+}
+
+// postgresOrderBy ORDER BY clause of sort, network breaks ties
+func postgresOrderBy(sort ListSort, timeColumn string) string {
+	direction := ""
+	if sort.Desc {
+		direction = " DESC"
+	}
+
+	if sort.Field == ListSortIP {
+		return "ORDER BY ip" + direction
+	}
+
+	return "ORDER BY " + timeColumn + direction + ", ip" + direction
+}
+
+// escapeLike escapes wildcards of LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
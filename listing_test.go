@@ -0,0 +1,52 @@
+package traffic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListSort(t *testing.T) {
+	sort, err := ParseListSort("", BanSorts...)
+	require.NoError(t, err)
+	require.Equal(t, ListSort{Field: ListSortIP}, sort)
+
+	sort, err = ParseListSort("-up_to", BanSorts...)
+	require.NoError(t, err)
+	require.Equal(t, ListSort{Field: BanSortUpTo, Desc: true}, sort)
+	require.Equal(t, "-up_to", sort.String())
+
+	_, err = ParseListSort("reason", BanSorts...)
+	require.Error(t, err)
+}
+
+func TestParseListCursor(t *testing.T) {
+	cursor, err := ParseListCursor("")
+	require.NoError(t, err)
+	require.Nil(t, cursor)
+
+	expected := ListCursor{IP: mustParseNetwork(t, "2001:db8::/32"), Time: time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)}
+	cursor, err = ParseListCursor(expected.String())
+	require.NoError(t, err)
+	require.Equal(t, expected.IP.String(), cursor.IP.String())
+	require.True(t, expected.Time.Equal(cursor.Time))
+
+	_, err = ParseListCursor("garbage!")
+	require.Error(t, err)
+
+	_, err = ParseListCursor(ListCursor{}.String())
+	require.Error(t, err)
+}
+
+func TestCompareNetworks(t *testing.T) {
+	networks := []string{"2001:db8::1", "192.0.2.1", "192.0.2.0/24", "10.0.0.0/8"}
+	parsed := parseNetworks(t, networks...)
+	sortNetworks(parsed)
+	require.Equal(t, []string{"10.0.0.0/8", "192.0.2.0/24", "192.0.2.1", "2001:db8::1"}, networkStrings(parsed))
+
+	for idx := 1; idx < len(parsed); idx++ {
+		require.True(t, compareNetworks(parsed[idx-1], parsed[idx]) < 0)
+	}
+	require.Equal(t, 0, compareNetworks(parsed[0], parsed[0]))
+}
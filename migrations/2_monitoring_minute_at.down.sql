@@ -0,0 +1 @@
+ALTER TABLE ip_monitoring DROP COLUMN minute_at;
@@ -0,0 +1,4 @@
+ALTER TABLE ip_monitoring
+  ADD COLUMN minute_at timestamp GENERATED ALWAYS AS (day_date + hour * interval '1 hour' + minute * interval '1 minute') STORED;
+
+CREATE INDEX ON ip_monitoring (minute_at);
@@ -0,0 +1 @@
+DROP TABLE ip_ban_history;
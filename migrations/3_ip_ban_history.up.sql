@@ -0,0 +1,12 @@
+CREATE TABLE ip_ban_history (
+  id bigserial NOT NULL,
+  ip inet NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT NOW(),
+  until timestamptz NOT NULL,
+  by_user_id int DEFAULT NULL,
+  reason varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+);
+
+CREATE INDEX ON ip_ban_history (ip, created_at);
+CREATE INDEX ON ip_ban_history (created_at);
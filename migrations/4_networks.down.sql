@@ -0,0 +1,4 @@
+-- one-way migration: IPv4 addresses are not converted back to IPv4-mapped form
+-- and merged duplicates are not restored, only indexes are dropped
+DROP INDEX ip_whitelist_ip_gist_idx;
+DROP INDEX ip_ban_ip_gist_idx;
@@ -0,0 +1,46 @@
+-- IPv4 addresses were stored as IPv4-mapped IPv6 addresses.
+-- Both forms of the same address may exist, so mapped rows are merged into plain ones before conversion
+UPDATE ip_monitoring m SET count = m.count + d.count
+FROM ip_monitoring d
+WHERE family(d.ip) = 6 AND d.ip <<= '::ffff:0.0.0.0/96'
+  AND m.ip = set_masklen(substring(host(d.ip) from 8)::inet, masklen(d.ip) - 96)
+  AND m.day_date = d.day_date AND m.hour = d.hour AND m.tenminute = d.tenminute AND m.minute = d.minute;
+
+DELETE FROM ip_monitoring d
+USING ip_monitoring m
+WHERE family(d.ip) = 6 AND d.ip <<= '::ffff:0.0.0.0/96'
+  AND m.ip = set_masklen(substring(host(d.ip) from 8)::inet, masklen(d.ip) - 96)
+  AND m.day_date = d.day_date AND m.hour = d.hour AND m.tenminute = d.tenminute AND m.minute = d.minute;
+
+UPDATE ip_monitoring SET ip = set_masklen(substring(host(ip) from 8)::inet, masklen(ip) - 96)
+WHERE family(ip) = 6 AND ip <<= '::ffff:0.0.0.0/96';
+
+-- longest of duplicate bans wins
+UPDATE ip_ban b SET until = d.until, by_user_id = d.by_user_id, reason = d.reason
+FROM ip_ban d
+WHERE family(d.ip) = 6 AND d.ip <<= '::ffff:0.0.0.0/96'
+  AND b.ip = set_masklen(substring(host(d.ip) from 8)::inet, masklen(d.ip) - 96)
+  AND d.until > b.until;
+
+DELETE FROM ip_ban d
+USING ip_ban b
+WHERE family(d.ip) = 6 AND d.ip <<= '::ffff:0.0.0.0/96'
+  AND b.ip = set_masklen(substring(host(d.ip) from 8)::inet, masklen(d.ip) - 96);
+
+UPDATE ip_ban SET ip = set_masklen(substring(host(ip) from 8)::inet, masklen(ip) - 96)
+WHERE family(ip) = 6 AND ip <<= '::ffff:0.0.0.0/96';
+
+UPDATE ip_ban_history SET ip = set_masklen(substring(host(ip) from 8)::inet, masklen(ip) - 96)
+WHERE family(ip) = 6 AND ip <<= '::ffff:0.0.0.0/96';
+
+-- plain item of whitelist wins
+DELETE FROM ip_whitelist d
+USING ip_whitelist w
+WHERE family(d.ip) = 6 AND d.ip <<= '::ffff:0.0.0.0/96'
+  AND w.ip = set_masklen(substring(host(d.ip) from 8)::inet, masklen(d.ip) - 96);
+
+UPDATE ip_whitelist SET ip = set_masklen(substring(host(ip) from 8)::inet, masklen(ip) - 96)
+WHERE family(ip) = 6 AND ip <<= '::ffff:0.0.0.0/96';
+
+CREATE INDEX ip_ban_ip_gist_idx ON ip_ban USING gist (ip inet_ops);
+CREATE INDEX ip_whitelist_ip_gist_idx ON ip_whitelist USING gist (ip inet_ops);
@@ -0,0 +1,5 @@
+ALTER TABLE ip_whitelist
+  DROP COLUMN source,
+  DROP COLUMN created_at,
+  DROP COLUMN verified_at,
+  DROP COLUMN expires_at;
@@ -0,0 +1,17 @@
+ALTER TABLE ip_whitelist
+  ADD COLUMN source varchar(255) NOT NULL DEFAULT 'manual',
+  ADD COLUMN created_at timestamptz NOT NULL DEFAULT NOW(),
+  ADD COLUMN verified_at timestamptz DEFAULT NULL,
+  ADD COLUMN expires_at timestamptz DEFAULT NULL;
+
+-- items of former hardcoded autodetect are reverified by crawler rules.
+-- Legacy descriptions were named by hostname, e.g. "msnbot autodetect" or "yandex.com ipv6 autodetect"
+UPDATE ip_whitelist SET source = 'autodetect:' || CASE split_part(description, ' ', 1)
+    WHEN 'msnbot' THEN 'bingbot'
+    WHEN 'yandex.com' THEN 'yandex'
+    ELSE split_part(description, ' ', 1)
+  END
+WHERE description LIKE '% autodetect';
+
+CREATE INDEX ON ip_whitelist (source);
+CREATE INDEX ON ip_whitelist (expires_at);
@@ -0,0 +1,5 @@
+DROP TRIGGER ip_whitelist_change ON ip_whitelist;
+DROP TRIGGER ip_ban_change ON ip_ban;
+DROP FUNCTION record_ip_change();
+DROP TABLE ip_whitelist_change;
+DROP TABLE ip_ban_change;
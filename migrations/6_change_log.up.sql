@@ -0,0 +1,42 @@
+CREATE TABLE ip_ban_change (
+  id bigserial NOT NULL,
+  ip inet NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (id)
+);
+
+CREATE INDEX ON ip_ban_change (created_at);
+
+CREATE TABLE ip_whitelist_change (
+  id bigserial NOT NULL,
+  ip inet NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (id)
+);
+
+CREATE INDEX ON ip_whitelist_change (created_at);
+
+-- records changed network into change table given as trigger argument
+CREATE FUNCTION record_ip_change() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'INSERT' OR TG_OP = 'UPDATE' THEN
+    EXECUTE format('INSERT INTO %I (ip) VALUES ($1)', TG_ARGV[0]) USING NEW.ip;
+  END IF;
+
+  IF TG_OP = 'DELETE' THEN
+    EXECUTE format('INSERT INTO %I (ip) VALUES ($1)', TG_ARGV[0]) USING OLD.ip;
+  ELSIF TG_OP = 'UPDATE' THEN
+    IF NEW.ip <> OLD.ip THEN
+      EXECUTE format('INSERT INTO %I (ip) VALUES ($1)', TG_ARGV[0]) USING OLD.ip;
+    END IF;
+  END IF;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ip_ban_change AFTER INSERT OR UPDATE OR DELETE ON ip_ban
+  FOR EACH ROW EXECUTE PROCEDURE record_ip_change('ip_ban_change');
+
+CREATE TRIGGER ip_whitelist_change AFTER INSERT OR UPDATE OR DELETE ON ip_whitelist
+  FOR EACH ROW EXECUTE PROCEDURE record_ip_change('ip_whitelist_change');
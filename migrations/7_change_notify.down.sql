@@ -0,0 +1,17 @@
+CREATE OR REPLACE FUNCTION record_ip_change() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'INSERT' OR TG_OP = 'UPDATE' THEN
+    EXECUTE format('INSERT INTO %I (ip) VALUES ($1)', TG_ARGV[0]) USING NEW.ip;
+  END IF;
+
+  IF TG_OP = 'DELETE' THEN
+    EXECUTE format('INSERT INTO %I (ip) VALUES ($1)', TG_ARGV[0]) USING OLD.ip;
+  ELSIF TG_OP = 'UPDATE' THEN
+    IF NEW.ip <> OLD.ip THEN
+      EXECUTE format('INSERT INTO %I (ip) VALUES ($1)', TG_ARGV[0]) USING OLD.ip;
+    END IF;
+  END IF;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,25 @@
+-- records changed network into change table given as trigger argument and notifies listeners about it
+CREATE OR REPLACE FUNCTION record_ip_change() RETURNS trigger AS $$
+DECLARE
+  networks inet[];
+  network inet;
+  change_id bigint;
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    networks := ARRAY[NEW.ip];
+  ELSIF TG_OP = 'DELETE' THEN
+    networks := ARRAY[OLD.ip];
+  ELSIF NEW.ip <> OLD.ip THEN
+    networks := ARRAY[NEW.ip, OLD.ip];
+  ELSE
+    networks := ARRAY[NEW.ip];
+  END IF;
+
+  FOREACH network IN ARRAY networks LOOP
+    EXECUTE format('INSERT INTO %I (ip) VALUES ($1) RETURNING id', TG_ARGV[0]) INTO change_id USING network;
+    PERFORM pg_notify('ip_change', json_build_object('table', TG_TABLE_NAME, 'id', change_id, 'ip', network)::text);
+  END LOOP;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
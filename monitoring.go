@@ -1,31 +1,114 @@
 package traffic
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"net"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/autowp/traffic/util"
-	"github.com/streadway/amqp"
 )
 
+// MonitoringRepository storage of per minute request counters
+type MonitoringRepository interface {
+	// Add increments counter of the minute of timestamp
+	Add(ip net.IP, timestamp time.Time) error
+	// AddBatch increments counters by pre-aggregated values
+	AddBatch(buckets []MonitoringBucket) error
+	// GC deletes counters of minutes before given time
+	GC(before time.Time) (int64, error)
+	Clear() error
+	ClearIP(ip net.IP) error
+	// ListOfTop returns IPs with most requests today
+	ListOfTop(limit int) ([]ListOfTopItem, error)
+	// ListByBanProfile returns networks aggregated by profile which exceeded its limit
+	// during its window till now. Oldest minute partially overlapped by window is weighted by overlap,
+	// as if its requests were spread evenly
+	ListByBanProfile(profile AutobanProfile) ([]Network, error)
+	ExistsIP(ip net.IP) (bool, error)
+	// CountNetwork sums requests of IPs of network since given time,
+	// minute partially overlapped by period is weighted by overlap as by ListByBanProfile
+	CountNetwork(network Network, since time.Time) (int, error)
+	// Timeline returns per minute counters of IP since given time in order of minutes
+	Timeline(ip net.IP, since time.Time) ([]MonitoringBucket, error)
+}
+
 // Monitoring Main Object
 type Monitoring struct {
-	db     *pgxpool.Pool
-	logger *util.Logger
+	repository MonitoringRepository
+	logger     *util.Logger
+	stateMutex sync.RWMutex
+	state      ListenerState
+	observers  []MonitoringObserver
+	retention  time.Duration
 }
 
+// MonitoringObserver receives each message accepted by listener as it arrives, redelivered messages are skipped
+type MonitoringObserver func(message MonitoringInputMessage)
+
 // MonitoringInputMessage InputMessage
 type MonitoringInputMessage struct {
 	IP        net.IP    `json:"ip"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// MonitoringBucket count of requests from IP during minute
+type MonitoringBucket struct {
+	IP     net.IP
+	Minute time.Time
+	Count  int
+}
+
+type monitoringBucketKey struct {
+	ip     string
+	minute int64
+}
+
+// MonitoringBatch aggregates counters in memory before they are written to repository
+type MonitoringBatch struct {
+	buckets map[monitoringBucketKey]*MonitoringBucket
+}
+
+// NewMonitoringBatch constructor
+func NewMonitoringBatch() *MonitoringBatch {
+	return &MonitoringBatch{
+		buckets: make(map[monitoringBucketKey]*MonitoringBucket),
+	}
+}
+
+// Add counts request into bucket of its minute
+func (b *MonitoringBatch) Add(ip net.IP, timestamp time.Time) {
+	minute := timestamp.Truncate(time.Minute)
+	key := monitoringBucketKey{ip: ip.String(), minute: minute.Unix()}
+
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &MonitoringBucket{IP: ip, Minute: minute}
+		b.buckets[key] = bucket
+	}
+	bucket.Count++
+}
+
+// Len number of buckets
+func (b *MonitoringBatch) Len() int {
+	return len(b.buckets)
+}
+
+// Buckets returns aggregated counters
+func (b *MonitoringBatch) Buckets() []MonitoringBucket {
+	result := make([]MonitoringBucket, 0, len(b.buckets))
+	for _, bucket := range b.buckets {
+		result = append(result, *bucket)
+	}
+
+	return result
+}
+
+// Reset drops aggregated counters
+func (b *MonitoringBatch) Reset() {
+	b.buckets = make(map[monitoringBucketKey]*MonitoringBucket)
+}
+
 // ListOfTopItem ListOfTopItem
 type ListOfTopItem struct {
 	IP    net.IP `json:"ip"`
@@ -33,200 +116,108 @@ type ListOfTopItem struct {
 }
 
 // NewMonitoring constructor
-func NewMonitoring(db *pgxpool.Pool, logger *util.Logger) (*Monitoring, error) {
+func NewMonitoring(repository MonitoringRepository, logger *util.Logger) (*Monitoring, error) {
+	if repository == nil {
+		return nil, fmt.Errorf("monitoring repository is nil")
+	}
+
 	s := &Monitoring{
-		db:     db,
-		logger: logger,
+		repository: repository,
+		logger:     logger,
 	}
 
 	return s, nil
 }
 
-// Listen for incoming messages
-func (s *Monitoring) Listen(conn *amqp.Connection, queue string, quitChan chan bool) error {
-	ch, err := conn.Channel()
-	if err != nil {
-		return err
-	}
-	defer util.Close(ch)
-
-	inQ, err := ch.QueueDeclare(
-		queue, // name
-		false, // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
-	if err != nil {
-		return err
-	}
-
-	msgs, err := ch.Consume(
-		inQ.Name, // queue
-		"",       // consumer
-		true,     // auto-ack
-		false,    // exclusive
-		false,    // no-local
-		false,    // no-wait
-		nil,      // args
-	)
-	if err != nil {
-		return err
-	}
-
-	quit := false
-	for !quit {
-		select {
-		case d := <-msgs:
-			if d.ContentType != "application/json" {
-				s.logger.Warning(fmt.Errorf("unexpected mime `%s`", d.ContentType))
-				continue
-			}
-
-			var message MonitoringInputMessage
-			err := json.Unmarshal(d.Body, &message)
-			if err != nil {
-				s.logger.Warning(fmt.Errorf("failed to parse json `%v`: %s", err, d.Body))
-				continue
-			}
-
-			err = s.Add(message.IP, message.Timestamp)
-			if err != nil {
-				s.logger.Warning(err)
-			}
-
-		case <-quitChan:
-			quit = true
-		}
-	}
-
-	return nil
+// AddObserver registers observer of incoming messages. Must be called before Listen
+func (s *Monitoring) AddObserver(observer MonitoringObserver) {
+	s.observers = append(s.observers, observer)
 }
 
 // Add item to Monitoring
 func (s *Monitoring) Add(ip net.IP, timestamp time.Time) error {
+	return s.repository.Add(ip, timestamp)
+}
+
+// AddBatch adds pre-aggregated counters to Monitoring
+func (s *Monitoring) AddBatch(buckets []MonitoringBucket) error {
+	if len(buckets) == 0 {
+		return nil
+	}
 
-	_, err := s.db.Exec(context.Background(), `
-		INSERT INTO ip_monitoring (day_date, hour, tenminute, minute, ip, count)
-		VALUES (
-			$1::timestamptz,
-			EXTRACT(HOUR FROM $1::timestamptz),
-			FLOOR(EXTRACT(MINUTE FROM $1::timestamptz)/10),
-			EXTRACT(MINUTE FROM $1::timestamptz),
-			$2,
-			1
-		)
-		ON CONFLICT(ip,day_date,hour,tenminute,minute) DO UPDATE SET count=ip_monitoring.count+1
-	`, timestamp, ip)
+	return s.repository.AddBatch(buckets)
+}
 
-	return err
+// SetRetention keeps counters for at least given duration on GC, so they are available to rolling windows.
+// Counters of current day are always kept
+func (s *Monitoring) SetRetention(retention time.Duration) {
+	s.retention = retention
 }
 
 // GC Garbage Collect
 func (s *Monitoring) GC() (int64, error) {
-
-	ct, err := s.db.Exec(context.Background(), "DELETE FROM ip_monitoring WHERE day_date < CURRENT_DATE")
-	if err != nil {
-		return 0, err
+	now := time.Now()
+	before := startOfDay(now)
+	if since := now.Add(-s.retention).Truncate(time.Minute); since.Before(before) {
+		before = since
 	}
 
-	affected := ct.RowsAffected()
-
-	return affected, nil
+	return s.repository.GC(before)
 }
 
 // Clear removes all collected data
 func (s *Monitoring) Clear() error {
-	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_monitoring")
-	return err
+	return s.repository.Clear()
 }
 
 // ClearIP removes all data collected for IP
 func (s *Monitoring) ClearIP(ip net.IP) error {
-	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_monitoring WHERE ip = $1", ip)
-
-	return err
+	return s.repository.ClearIP(ip)
 }
 
 // ListOfTop ListOfTop
 func (s *Monitoring) ListOfTop(limit int) ([]ListOfTopItem, error) {
+	return s.repository.ListOfTop(limit)
+}
 
-	rows, err := s.db.Query(context.Background(), `
-		SELECT ip, SUM(count) AS c
-		FROM ip_monitoring
-		WHERE day_date = CURRENT_DATE
-		GROUP BY ip
-		ORDER BY c DESC
-		LIMIT $1
-	`, limit)
-	if err != nil {
-		return nil, err
+// ListByBanProfile ListByBanProfile
+func (s *Monitoring) ListByBanProfile(profile AutobanProfile) ([]Network, error) {
+	if profile.Window <= 0 {
+		return nil, fmt.Errorf("window must be positive")
 	}
-	defer rows.Close()
 
-	result := []ListOfTopItem{}
+	return s.repository.ListByBanProfile(profile)
+}
 
-	for rows.Next() {
-		var item ListOfTopItem
-		if err := rows.Scan(&item.IP, &item.Count); err != nil {
-			return nil, err
-		}
+// CountByProfile sums requests of network which profile aggregates IP into, during window of profile
+// in the same way as ListByBanProfile
+func (s *Monitoring) CountByProfile(ip net.IP, profile AutobanProfile) (Network, int, error) {
+	network := profile.Network(ip)
+	count, err := s.repository.CountNetwork(network, time.Now().Add(-profile.Window))
 
-		result = append(result, item)
-	}
+	return network, count, err
+}
 
-	return result, nil
+// Timeline per minute counters of IP since given time
+func (s *Monitoring) Timeline(ip net.IP, since time.Time) ([]MonitoringBucket, error) {
+	return s.repository.Timeline(ip, since)
 }
 
-// ListByBanProfile ListByBanProfile
-func (s *Monitoring) ListByBanProfile(profile AutobanProfile) ([]net.IP, error) {
-	group := append([]string{"ip"}, profile.Group...)
-
-	rows, err := s.db.Query(context.Background(), `
-		SELECT ip, SUM(count) AS c
-		FROM ip_monitoring
-		WHERE day_date = CURRENT_DATE
-		GROUP BY `+strings.Join(group, ", ")+`
-		HAVING SUM(count) > $1
-		LIMIT 1000
-	`, profile.Limit)
-	if err != nil {
-		return nil, err
+// windowWeight share of minute bucket which overlaps period started at since
+func windowWeight(minute time.Time, since time.Time) float64 {
+	overlap := minute.Add(time.Minute).Sub(since)
+	if overlap <= 0 {
+		return 0
 	}
-	defer rows.Close()
-
-	result := []net.IP{}
 
-	for rows.Next() {
-		var ip net.IP
-		var c int
-		if err := rows.Scan(&ip, &c); err != nil {
-			return nil, err
-		}
-
-		result = append(result, ip)
+	if overlap >= time.Minute {
+		return 1
 	}
 
-	return result, nil
+	return float64(overlap) / float64(time.Minute)
 }
 
 // ExistsIP ban list already contains IP
 func (s *Monitoring) ExistsIP(ip net.IP) (bool, error) {
-	var exists bool
-	err := s.db.QueryRow(context.Background(), `
-		SELECT true
-		FROM ip_monitoring
-		WHERE ip = $1
-		LIMIT 1
-	`, ip).Scan(&exists)
-	if err != nil {
-		if err != pgx.ErrNoRows {
-			return false, err
-		}
-
-		return false, nil
-	}
-
-	return true, nil
+	return s.repository.ExistsIP(ip)
 }
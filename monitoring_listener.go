@@ -0,0 +1,330 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/autowp/traffic/util"
+	"github.com/streadway/amqp"
+)
+
+// ListenerState state of connection of monitoring listener
+type ListenerState int
+
+const (
+	// ListenerStopped listener is not running
+	ListenerStopped ListenerState = iota
+	// ListenerConnecting dialing to broker
+	ListenerConnecting
+	// ListenerConnected consuming messages
+	ListenerConnected
+	// ListenerDisconnected waiting before reconnect
+	ListenerDisconnected
+)
+
+func (state ListenerState) String() string {
+	switch state {
+	case ListenerStopped:
+		return "stopped"
+	case ListenerConnecting:
+		return "connecting"
+	case ListenerConnected:
+		return "connected"
+	case ListenerDisconnected:
+		return "disconnected"
+	}
+
+	return fmt.Sprintf("unknown(%d)", int(state))
+}
+
+// AMQPDialer opens connection to broker
+type AMQPDialer func() (*amqp.Connection, error)
+
+// ListenerState reports state of connection of listener
+func (s *Monitoring) ListenerState() ListenerState {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+
+	return s.state
+}
+
+func (s *Monitoring) setListenerState(state ListenerState) {
+	s.stateMutex.Lock()
+	changed := s.state != state
+	s.state = state
+	s.stateMutex.Unlock()
+
+	if changed {
+		fmt.Printf("Monitoring listener %s\n", state)
+	}
+}
+
+// nextReconnectDelay doubles delay up to maxDelay
+func nextReconnectDelay(delay time.Duration, maxDelay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay
+}
+
+// Listen for incoming messages.
+// Connection is re-established with exponential backoff when broker closes connection or channel.
+// Counters are aggregated in memory and flushed when config.BatchSize deliveries are pending,
+// every config.FlushInterval and on quit.
+// Deliveries are acknowledged only after their batch is written, so unflushed counters are
+// redelivered after crash. Observers receive messages as they arrive, before flush,
+// except of redelivered ones which were observed on first delivery.
+// Malformed deliveries are rejected to config.DeadLetterExchange if any
+func (s *Monitoring) Listen(dial AMQPDialer, queue string, config MonitoringConfig, quitChan chan bool) error {
+	defer s.setListenerState(ListenerStopped)
+
+	delay := config.ReconnectDelay
+	for {
+		s.setListenerState(ListenerConnecting)
+
+		conn, err := dial()
+		if err == nil {
+			var quit bool
+			quit, err = s.consume(conn, queue, config, quitChan, func() {
+				delay = config.ReconnectDelay
+			})
+
+			closeErr := conn.Close()
+			if closeErr != nil && closeErr != amqp.ErrClosed {
+				s.logger.Warning(closeErr)
+			}
+
+			if quit {
+				return nil
+			}
+		}
+
+		s.setListenerState(ListenerDisconnected)
+		s.logger.Warningf("monitoring listener: %v, reconnect in %v", err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-quitChan:
+			return nil
+		}
+
+		delay = nextReconnectDelay(delay, config.ReconnectMaxDelay)
+	}
+}
+
+// consume messages until quit or connection failure. Reports true when quit requested
+func (s *Monitoring) consume(
+	conn *amqp.Connection, queue string, config MonitoringConfig, quitChan chan bool, onConnected func(),
+) (bool, error) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return false, err
+	}
+	defer util.Close(ch)
+
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	var args amqp.Table
+	if config.DeadLetterExchange != "" {
+		args = amqp.Table{"x-dead-letter-exchange": config.DeadLetterExchange}
+	}
+
+	inQ, err := ch.QueueDeclare(
+		queue,          // name
+		config.Durable, // durable
+		false,          // delete when unused
+		false,          // exclusive
+		false,          // no-wait
+		args,           // arguments
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if config.Prefetch > 0 {
+		err = ch.Qos(config.Prefetch, 0, false)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	msgs, err := ch.Consume(
+		inQ.Name, // queue
+		"",       // consumer
+		false,    // auto-ack
+		false,    // exclusive
+		false,    // no-local
+		false,    // no-wait
+		nil,      // args
+	)
+	if err != nil {
+		return false, err
+	}
+
+	s.setListenerState(ListenerConnected)
+	onConnected()
+
+	pending := newPendingDeliveries()
+	flushSize := monitoringFlushSize(config)
+	ticker := time.NewTicker(config.FlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if pending.Len() == 0 || !s.flush(pending.batch) {
+			return
+		}
+
+		// batch contains every delivery which is not rejected yet
+		err := ch.Ack(pending.lastTag, true)
+		if err != nil {
+			s.logger.Warning(err)
+		}
+
+		pending.reset()
+	}
+
+	// unacknowledged deliveries are redelivered by broker after reconnect,
+	// so batch is dropped to avoid counting them twice
+	lost := func(reason interface{}) (bool, error) {
+		if pending.Len() > 0 {
+			fmt.Printf("Drop %d unacknowledged deliveries\n", pending.Len())
+		}
+		return false, fmt.Errorf("connection lost: %v", reason)
+	}
+
+	for {
+		select {
+		case d, ok := <-msgs:
+			if !ok {
+				return lost("deliveries channel closed")
+			}
+
+			message, err := parseMonitoringDelivery(d)
+			if err != nil {
+				s.logger.Warning(err)
+				err = d.Reject(false)
+				if err != nil {
+					s.logger.Warning(err)
+				}
+				continue
+			}
+
+			pending.add(d.DeliveryTag, message)
+			s.observe(message, d.Redelivered)
+
+			if pending.Len() >= flushSize {
+				flush()
+			}
+
+		case amqpErr := <-connClosed:
+			return lost(amqpErr)
+
+		case amqpErr := <-chClosed:
+			return lost(amqpErr)
+
+		case <-ticker.C:
+			flush()
+
+		case <-quitChan:
+			flush()
+			return true, nil
+		}
+	}
+}
+
+// observe passes message to observers as soon as it arrives.
+// Redelivered message was observed on first delivery, which wasn't acknowledged due to lost connection or crash,
+// so it is skipped. Message observed by crashed process is not observed again, scheduled autoban still counts it
+func (s *Monitoring) observe(message MonitoringInputMessage, redelivered bool) {
+	if redelivered {
+		return
+	}
+
+	for _, observer := range s.observers {
+		observer(message)
+	}
+}
+
+// pendingDeliveries deliveries aggregated into batch and not acknowledged yet
+type pendingDeliveries struct {
+	batch   *MonitoringBatch
+	count   int
+	lastTag uint64
+}
+
+func newPendingDeliveries() *pendingDeliveries {
+	return &pendingDeliveries{batch: NewMonitoringBatch()}
+}
+
+func (p *pendingDeliveries) add(tag uint64, message MonitoringInputMessage) {
+	p.batch.Add(message.IP, message.Timestamp)
+	p.count++
+	p.lastTag = tag
+}
+
+// Len number of deliveries, buckets of batch are fewer when IPs repeat
+func (p *pendingDeliveries) Len() int {
+	return p.count
+}
+
+func (p *pendingDeliveries) reset() {
+	p.batch.Reset()
+	p.count = 0
+}
+
+// monitoringFlushSize number of pending deliveries which triggers flush.
+// It is kept below prefetch, otherwise broker stops delivering before threshold is reached
+func monitoringFlushSize(config MonitoringConfig) int {
+	size := config.BatchSize
+	if config.Prefetch > 0 && size >= config.Prefetch {
+		size = config.Prefetch - 1
+	}
+
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+func parseMonitoringDelivery(d amqp.Delivery) (MonitoringInputMessage, error) {
+	var message MonitoringInputMessage
+
+	if d.ContentType != "application/json" {
+		return message, fmt.Errorf("unexpected mime `%s`", d.ContentType)
+	}
+
+	err := json.Unmarshal(d.Body, &message)
+	if err != nil {
+		return message, fmt.Errorf("failed to parse json `%v`: %s", err, d.Body)
+	}
+
+	if message.IP == nil {
+		return message, fmt.Errorf("ip not provided: %s", d.Body)
+	}
+
+	return message, nil
+}
+
+// flush writes batch to repository and reports success.
+// Batch is kept on failure, so counters are retried with next flush
+func (s *Monitoring) flush(batch *MonitoringBatch) bool {
+	if batch.Len() == 0 {
+		return false
+	}
+
+	err := s.AddBatch(batch.Buckets())
+	if err != nil {
+		s.logger.Warning(err)
+		return false
+	}
+
+	batch.Reset()
+
+	return true
+}
@@ -0,0 +1,125 @@
+package traffic
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/autowp/traffic/util"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextReconnectDelay(t *testing.T) {
+
+	config := MonitoringConfig{ReconnectDelay: 100 * time.Millisecond, ReconnectMaxDelay: time.Second}
+
+	delay := config.ReconnectDelay
+	delays := []time.Duration{}
+	for i := 0; i < 5; i++ {
+		delay = nextReconnectDelay(delay, config.ReconnectMaxDelay)
+		delays = append(delays, delay)
+	}
+
+	require.Equal(t, []time.Duration{
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}, delays)
+}
+
+func TestListenRetriesDial(t *testing.T) {
+
+	s, err := NewMonitoring(NewMemoryMonitoringRepository(), util.NewLogger(util.SentryConfig{}))
+	require.NoError(t, err)
+
+	config := MonitoringConfig{
+		BatchSize:         10,
+		FlushInterval:     time.Second,
+		ReconnectDelay:    time.Millisecond,
+		ReconnectMaxDelay: 4 * time.Millisecond,
+	}
+
+	attempts := make(chan int, 100)
+	count := 0
+	dial := func() (*amqp.Connection, error) {
+		count++
+		attempts <- count
+		return nil, fmt.Errorf("dial failed")
+	}
+
+	quit := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- s.Listen(dial, "input", config, quit)
+	}()
+
+	for n := range attempts {
+		if n == 3 {
+			break
+		}
+	}
+	require.Equal(t, ListenerDisconnected, waitListenerState(s, ListenerDisconnected))
+
+	quit <- true
+	require.NoError(t, <-done)
+	require.Equal(t, ListenerStopped, s.ListenerState())
+}
+
+func waitListenerState(s *Monitoring, state ListenerState) ListenerState {
+	for i := 0; i < 100; i++ {
+		if s.ListenerState() == state {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return s.ListenerState()
+}
+
+func TestPendingDeliveriesCountsDeliveries(t *testing.T) {
+
+	pending := newPendingDeliveries()
+
+	// single IP within single minute makes one bucket
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		pending.add(uint64(i+1), MonitoringInputMessage{IP: net.IPv4(192, 0, 2, 1), Timestamp: now})
+	}
+
+	require.Equal(t, 5, pending.Len())
+	require.Equal(t, 1, pending.batch.Len())
+	require.Equal(t, uint64(5), pending.lastTag)
+
+	pending.reset()
+	require.Equal(t, 0, pending.Len())
+	require.Equal(t, 0, pending.batch.Len())
+}
+
+func TestMemoryMonitoringObserveSkipsRedelivered(t *testing.T) {
+
+	s, err := NewMonitoring(NewMemoryMonitoringRepository(), util.NewLogger(util.SentryConfig{}))
+	require.NoError(t, err)
+
+	var observed []MonitoringInputMessage
+	s.AddObserver(func(message MonitoringInputMessage) {
+		observed = append(observed, message)
+	})
+
+	message := MonitoringInputMessage{IP: net.IPv4(192, 0, 2, 1), Timestamp: time.Now()}
+	s.observe(message, false)
+	s.observe(message, true)
+
+	require.Equal(t, []MonitoringInputMessage{message}, observed)
+}
+
+func TestMonitoringFlushSize(t *testing.T) {
+
+	require.Equal(t, 1000, monitoringFlushSize(MonitoringConfig{BatchSize: 1000, Prefetch: 2000}))
+	require.Equal(t, 1999, monitoringFlushSize(MonitoringConfig{BatchSize: 5000, Prefetch: 2000}))
+	require.Equal(t, 5000, monitoringFlushSize(MonitoringConfig{BatchSize: 5000}))
+	require.Equal(t, 1, monitoringFlushSize(MonitoringConfig{BatchSize: 10, Prefetch: 1}))
+}
@@ -0,0 +1,241 @@
+package traffic
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryMonitoringRepository keeps per minute counters in process memory.
+// Calendar fields are evaluated in local timezone
+type MemoryMonitoringRepository struct {
+	mutex sync.RWMutex
+	items map[monitoringBucketKey]*MonitoringBucket
+	now   func() time.Time
+}
+
+// NewMemoryMonitoringRepository constructor
+func NewMemoryMonitoringRepository() *MemoryMonitoringRepository {
+	return &MemoryMonitoringRepository{
+		items: make(map[monitoringBucketKey]*MonitoringBucket),
+		now:   time.Now,
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// Add item to Monitoring
+func (s *MemoryMonitoringRepository) Add(ip net.IP, timestamp time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.add(ip, timestamp, 1)
+
+	return nil
+}
+
+// AddBatch adds pre-aggregated counters
+func (s *MemoryMonitoringRepository) AddBatch(buckets []MonitoringBucket) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, bucket := range buckets {
+		s.add(bucket.IP, bucket.Minute, bucket.Count)
+	}
+
+	return nil
+}
+
+func (s *MemoryMonitoringRepository) add(ip net.IP, timestamp time.Time, count int) {
+	minute := timestamp.Local().Truncate(time.Minute)
+	key := monitoringBucketKey{ip: ip.String(), minute: minute.Unix()}
+
+	item, ok := s.items[key]
+	if !ok {
+		item = &MonitoringBucket{IP: ip, Minute: minute}
+		s.items[key] = item
+	}
+	item.Count += count
+}
+
+// GC Garbage Collect
+func (s *MemoryMonitoringRepository) GC(before time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var affected int64
+	for key, item := range s.items {
+		if item.Minute.Before(before) {
+			delete(s.items, key)
+			affected++
+		}
+	}
+
+	return affected, nil
+}
+
+// Clear removes all collected data
+func (s *MemoryMonitoringRepository) Clear() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.items = make(map[monitoringBucketKey]*MonitoringBucket)
+
+	return nil
+}
+
+// ClearIP removes all data collected for IP
+func (s *MemoryMonitoringRepository) ClearIP(ip net.IP) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ipText := ip.String()
+	for key := range s.items {
+		if key.ip == ipText {
+			delete(s.items, key)
+		}
+	}
+
+	return nil
+}
+
+// sumByIP sums counters of minutes in [since, until) by IP
+func (s *MemoryMonitoringRepository) sumByIP(since, until time.Time) map[string]*ListOfTopItem {
+	sums := make(map[string]*ListOfTopItem)
+	for key, item := range s.items {
+		if item.Minute.Before(since) || !item.Minute.Before(until) {
+			continue
+		}
+
+		sum, ok := sums[key.ip]
+		if !ok {
+			sum = &ListOfTopItem{IP: item.IP}
+			sums[key.ip] = sum
+		}
+		sum.Count += item.Count
+	}
+
+	return sums
+}
+
+// ListOfTop ListOfTop
+func (s *MemoryMonitoringRepository) ListOfTop(limit int) ([]ListOfTopItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	today := startOfDay(time.Now())
+	sums := s.sumByIP(today, today.AddDate(0, 0, 1))
+
+	result := make([]ListOfTopItem, 0, len(sums))
+	for _, sum := range sums {
+		result = append(result, *sum)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].IP.String() < result[j].IP.String()
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// ListByBanProfile ListByBanProfile
+func (s *MemoryMonitoringRepository) ListByBanProfile(profile AutobanProfile) ([]Network, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	since := s.now().Add(-profile.Window)
+
+	networks := make(map[string]Network)
+	sums := make(map[string]float64)
+	for _, item := range s.items {
+		weight := windowWeight(item.Minute, since)
+		if weight == 0 {
+			continue
+		}
+
+		network := profile.Network(item.IP)
+		key := network.String()
+		networks[key] = network
+		sums[key] += float64(item.Count) * weight
+	}
+
+	keys := make([]string, 0, len(sums))
+	for key, sum := range sums {
+		if sum > float64(profile.Limit) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if len(keys) > 1000 {
+		keys = keys[:1000]
+	}
+
+	result := make([]Network, len(keys))
+	for idx, key := range keys {
+		result[idx] = networks[key]
+	}
+
+	return result, nil
+}
+
+// ExistsIP ban list already contains IP
+func (s *MemoryMonitoringRepository) ExistsIP(ip net.IP) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ipText := ip.String()
+	for key := range s.items {
+		if key.ip == ipText {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// CountNetwork sums requests of IPs of network since given time
+func (s *MemoryMonitoringRepository) CountNetwork(network Network, since time.Time) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	count := 0.0
+	for _, item := range s.items {
+		if network.Contains(item.IP) {
+			count += float64(item.Count) * windowWeight(item.Minute, since)
+		}
+	}
+
+	return int(count), nil
+}
+
+// Timeline returns per minute counters of IP since given time
+func (s *MemoryMonitoringRepository) Timeline(ip net.IP, since time.Time) ([]MonitoringBucket, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ipText := ip.String()
+	result := make([]MonitoringBucket, 0)
+	for key, item := range s.items {
+		if key.ip == ipText && !item.Minute.Before(since) {
+			result = append(result, *item)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Minute.Before(result[j].Minute)
+	})
+
+	return result, nil
+}
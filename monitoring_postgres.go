@@ -0,0 +1,222 @@
+package traffic
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PostgresMonitoringRepository stores counters in ip_monitoring table
+type PostgresMonitoringRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresMonitoringRepository constructor
+func NewPostgresMonitoringRepository(db *pgxpool.Pool) *PostgresMonitoringRepository {
+	return &PostgresMonitoringRepository{
+		db: db,
+	}
+}
+
+// Add item to Monitoring
+func (s *PostgresMonitoringRepository) Add(ip net.IP, timestamp time.Time) error {
+
+	_, err := s.db.Exec(context.Background(), `
+		INSERT INTO ip_monitoring (day_date, hour, tenminute, minute, ip, count)
+		VALUES (
+			$1::timestamptz,
+			EXTRACT(HOUR FROM $1::timestamptz),
+			FLOOR(EXTRACT(MINUTE FROM $1::timestamptz)/10),
+			EXTRACT(MINUTE FROM $1::timestamptz),
+			$2,
+			1
+		)
+		ON CONFLICT(ip,day_date,hour,tenminute,minute) DO UPDATE SET count=ip_monitoring.count+1
+	`, timestamp, normalizeIP(ip))
+
+	return err
+}
+
+// AddBatch upserts all buckets with single statement.
+// Buckets are expected to be unique by IP and minute
+func (s *PostgresMonitoringRepository) AddBatch(buckets []MonitoringBucket) error {
+	minutes := make([]time.Time, len(buckets))
+	ips := make([]net.IP, len(buckets))
+	counts := make([]int32, len(buckets))
+	for idx, bucket := range buckets {
+		minutes[idx] = bucket.Minute
+		ips[idx] = normalizeIP(bucket.IP)
+		counts[idx] = int32(bucket.Count)
+	}
+
+	_, err := s.db.Exec(context.Background(), `
+		INSERT INTO ip_monitoring (day_date, hour, tenminute, minute, ip, count)
+		SELECT
+			b.ts,
+			EXTRACT(HOUR FROM b.ts),
+			FLOOR(EXTRACT(MINUTE FROM b.ts)/10),
+			EXTRACT(MINUTE FROM b.ts),
+			b.ip,
+			b.c
+		FROM unnest($1::timestamptz[], $2::inet[], $3::int[]) AS b(ts, ip, c)
+		ON CONFLICT(ip,day_date,hour,tenminute,minute) DO UPDATE SET count=ip_monitoring.count+EXCLUDED.count
+	`, minutes, ips, counts)
+
+	return err
+}
+
+// GC Garbage Collect
+func (s *PostgresMonitoringRepository) GC(before time.Time) (int64, error) {
+
+	ct, err := s.db.Exec(context.Background(), "DELETE FROM ip_monitoring WHERE minute_at < $1::timestamptz", before)
+	if err != nil {
+		return 0, err
+	}
+
+	affected := ct.RowsAffected()
+
+	return affected, nil
+}
+
+// Clear removes all collected data
+func (s *PostgresMonitoringRepository) Clear() error {
+	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_monitoring")
+	return err
+}
+
+// ClearIP removes all data collected for IP
+func (s *PostgresMonitoringRepository) ClearIP(ip net.IP) error {
+	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_monitoring WHERE ip = $1", normalizeIP(ip))
+
+	return err
+}
+
+// ListOfTop ListOfTop
+func (s *PostgresMonitoringRepository) ListOfTop(limit int) ([]ListOfTopItem, error) {
+
+	rows, err := s.db.Query(context.Background(), `
+		SELECT ip, SUM(count) AS c
+		FROM ip_monitoring
+		WHERE day_date = CURRENT_DATE
+		GROUP BY ip
+		ORDER BY c DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []ListOfTopItem{}
+
+	for rows.Next() {
+		var item ListOfTopItem
+		if err := rows.Scan(&item.IP, &item.Count); err != nil {
+			return nil, err
+		}
+
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+// ListByBanProfile ListByBanProfile
+func (s *PostgresMonitoringRepository) ListByBanProfile(profile AutobanProfile) ([]Network, error) {
+	ipv4Prefix, ipv6Prefix := profile.Prefixes()
+
+	// oldest minute is weighted by its overlap with window
+	rows, err := s.db.Query(context.Background(), `
+		SELECT net, SUM(weighted)::float8 AS c
+		FROM (
+			SELECT network(set_masklen(ip, CASE WHEN family(ip) = 4 THEN $3::int ELSE $4::int END))::inet AS net,
+				count * LEAST(1, EXTRACT(EPOCH FROM minute_at + interval '1 minute' - (LOCALTIMESTAMP - $2::interval)) / 60) AS weighted
+			FROM ip_monitoring
+			WHERE minute_at > LOCALTIMESTAMP - $2::interval - interval '1 minute'
+		) AS w
+		GROUP BY net
+		HAVING SUM(weighted) > $1
+		LIMIT 1000
+	`, profile.Limit, profile.Window, ipv4Prefix, ipv6Prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []Network{}
+
+	for rows.Next() {
+		var network Network
+		var c float64
+		if err := rows.Scan(&network.IPNet, &c); err != nil {
+			return nil, err
+		}
+
+		result = append(result, network)
+	}
+
+	return result, nil
+}
+
+// ExistsIP ban list already contains IP
+func (s *PostgresMonitoringRepository) ExistsIP(ip net.IP) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(context.Background(), `
+		SELECT true
+		FROM ip_monitoring
+		WHERE ip = $1
+		LIMIT 1
+	`, normalizeIP(ip)).Scan(&exists)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return false, err
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// CountNetwork sums requests of IPs of network since given time
+func (s *PostgresMonitoringRepository) CountNetwork(network Network, since time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRow(context.Background(), `
+		SELECT COALESCE(FLOOR(SUM(
+			count * LEAST(1, EXTRACT(EPOCH FROM minute_at + interval '1 minute' - $2::timestamptz::timestamp) / 60)
+		)), 0)::bigint
+		FROM ip_monitoring
+		WHERE ip <<= $1 AND minute_at > $2::timestamptz::timestamp - interval '1 minute'
+	`, network, since).Scan(&count)
+
+	return count, err
+}
+
+// Timeline returns per minute counters of IP since given time
+func (s *PostgresMonitoringRepository) Timeline(ip net.IP, since time.Time) ([]MonitoringBucket, error) {
+	rows, err := s.db.Query(context.Background(), `
+		SELECT minute_at::timestamptz, count
+		FROM ip_monitoring
+		WHERE ip = $1 AND minute_at >= $2::timestamptz
+		ORDER BY minute_at
+	`, normalizeIP(ip), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]MonitoringBucket, 0)
+	for rows.Next() {
+		bucket := MonitoringBucket{IP: ip}
+		if err := rows.Scan(&bucket.Minute, &bucket.Count); err != nil {
+			return nil, err
+		}
+
+		result = append(result, bucket)
+	}
+
+	return result, rows.Err()
+}
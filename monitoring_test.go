@@ -4,13 +4,14 @@ import (
 	"context"
 	"github.com/autowp/traffic/util"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/require"
 	"net"
 	"testing"
 	"time"
 )
 
-func createMonitoringService(t *testing.T) *Monitoring {
+func createMonitoringService(t testing.TB) *Monitoring {
 	config := LoadConfig()
 
 	pool, err := pgxpool.Connect(context.Background(), config.DSN)
@@ -18,7 +19,7 @@ func createMonitoringService(t *testing.T) *Monitoring {
 
 	logger := util.NewLogger(config.Sentry)
 
-	s, err := NewMonitoring(pool, logger)
+	s, err := NewMonitoring(NewPostgresMonitoringRepository(pool), logger)
 	require.NoError(t, err)
 
 	return s
@@ -53,3 +54,190 @@ func TestMonitoringGC(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, items, 1)
 }
+
+func TestMemoryMonitoringListByBanProfile(t *testing.T) {
+
+	s, err := NewMonitoring(NewMemoryMonitoringRepository(), util.NewLogger(util.SentryConfig{}))
+	require.NoError(t, err)
+
+	ip1 := net.IPv4(192, 168, 0, 1)
+	ip2 := net.IPv4(192, 168, 0, 2)
+
+	now := time.Now().Truncate(time.Minute)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Add(ip1, now))
+		require.NoError(t, s.Add(ip2, now))
+	}
+	require.NoError(t, s.Add(ip2, now))
+	require.NoError(t, s.Add(ip1, now.AddDate(0, 0, -1)))
+
+	profile := AutobanProfile{Limit: 3, Reason: "Test", Window: time.Minute, Time: time.Hour}
+	ips, err := s.ListByBanProfile(profile)
+	require.NoError(t, err)
+	require.Equal(t, []Network{NetworkFromIP(ip2)}, ips)
+
+	profile.Window = 0
+	_, err = s.ListByBanProfile(profile)
+	require.Error(t, err)
+
+	items, err := s.ListOfTop(1)
+	require.NoError(t, err)
+	require.Equal(t, []ListOfTopItem{{IP: ip2, Count: 4}}, items)
+
+	affected, err := s.GC()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), affected)
+}
+
+func TestMonitoringBatch(t *testing.T) {
+
+	s, err := NewMonitoring(NewMemoryMonitoringRepository(), util.NewLogger(util.SentryConfig{}))
+	require.NoError(t, err)
+
+	ip1 := net.IPv4(192, 168, 0, 1)
+	ip2 := net.IPv4(192, 168, 0, 2)
+
+	now := time.Now().Truncate(time.Minute)
+
+	batch := NewMonitoringBatch()
+	for i := 0; i < 5; i++ {
+		batch.Add(ip1, now.Add(time.Duration(i)*time.Second))
+	}
+	batch.Add(ip2, now)
+	batch.Add(ip2, now.Add(-time.Minute))
+	require.Equal(t, 3, batch.Len())
+
+	err = s.AddBatch(batch.Buckets())
+	require.NoError(t, err)
+	batch.Reset()
+	require.Zero(t, batch.Len())
+
+	items, err := s.ListOfTop(10)
+	require.NoError(t, err)
+	require.Equal(t, []ListOfTopItem{{IP: ip1, Count: 5}, {IP: ip2, Count: 2}}, items)
+}
+
+func TestParseMonitoringDelivery(t *testing.T) {
+
+	message, err := parseMonitoringDelivery(amqp.Delivery{
+		ContentType: "application/json",
+		Body:        []byte(`{"ip":"192.168.0.1","timestamp":"2021-01-02T03:04:05Z"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "192.168.0.1", message.IP.String())
+	require.Equal(t, time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), message.Timestamp)
+
+	_, err = parseMonitoringDelivery(amqp.Delivery{
+		ContentType: "text/plain",
+		Body:        []byte(`{"ip":"192.168.0.1","timestamp":"2021-01-02T03:04:05Z"}`),
+	})
+	require.Error(t, err)
+
+	_, err = parseMonitoringDelivery(amqp.Delivery{
+		ContentType: "application/json",
+		Body:        []byte(`{"ip":`),
+	})
+	require.Error(t, err)
+
+	_, err = parseMonitoringDelivery(amqp.Delivery{
+		ContentType: "application/json",
+		Body:        []byte(`{"timestamp":"2021-01-02T03:04:05Z"}`),
+	})
+	require.Error(t, err)
+}
+
+func BenchmarkMonitoringAdd(b *testing.B) {
+
+	s := createMonitoringService(b)
+
+	err := s.Clear()
+	require.NoError(b, err)
+
+	now := time.Now()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = s.Add(net.IPv4(192, 168, 0, byte(i%100)), now)
+		require.NoError(b, err)
+	}
+}
+
+func BenchmarkMonitoringAddBatch(b *testing.B) {
+
+	s := createMonitoringService(b)
+
+	err := s.Clear()
+	require.NoError(b, err)
+
+	now := time.Now()
+	batch := NewMonitoringBatch()
+
+	// flush every 1000 messages as listener does with timer under load
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batch.Add(net.IPv4(192, 168, 0, byte(i%100)), now)
+		if (i+1)%1000 == 0 {
+			err = s.AddBatch(batch.Buckets())
+			require.NoError(b, err)
+			batch.Reset()
+		}
+	}
+
+	err = s.AddBatch(batch.Buckets())
+	require.NoError(b, err)
+}
+
+func TestMemoryMonitoringRollingWindow(t *testing.T) {
+
+	repository := NewMemoryMonitoringRepository()
+	s, err := NewMonitoring(repository, util.NewLogger(util.SentryConfig{}))
+	require.NoError(t, err)
+
+	// quarter of current minute passed, so three quarters of previous minute are in 1m window
+	now := time.Now().Truncate(time.Minute).Add(15 * time.Second)
+	repository.now = func() time.Time {
+		return now
+	}
+
+	steady := net.IPv4(192, 168, 0, 1)
+	burst := net.IPv4(192, 168, 0, 2)
+
+	// steady 600 requests per minute never exceed 700 in any 60s
+	require.NoError(t, s.AddBatch([]MonitoringBucket{
+		{IP: steady, Minute: now.Add(-time.Minute), Count: 600},
+		{IP: steady, Minute: now, Count: 150},
+		{IP: burst, Minute: now.Add(-time.Minute), Count: 699},
+		{IP: burst, Minute: now, Count: 699},
+		{IP: burst, Minute: now.Add(-3 * time.Minute), Count: 1},
+	}))
+
+	ips, err := s.ListByBanProfile(AutobanProfile{Limit: 700, Reason: "Test", Window: time.Minute, Time: time.Hour})
+	require.NoError(t, err)
+	require.Equal(t, []Network{NetworkFromIP(burst)}, ips)
+
+	// 0.75 * 600 + 150
+	count, err := repository.CountNetwork(NetworkFromIP(steady), now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 600, count)
+
+	// quarter of minute before previous one is in 90s window
+	ips, err = s.ListByBanProfile(AutobanProfile{Limit: 1398, Reason: "Test", Window: 90 * time.Second, Time: time.Hour})
+	require.NoError(t, err)
+	require.Empty(t, ips)
+
+	ips, err = s.ListByBanProfile(AutobanProfile{Limit: 1398, Reason: "Test", Window: 24 * time.Hour, Time: time.Hour})
+	require.NoError(t, err)
+	require.Equal(t, []Network{NetworkFromIP(burst)}, ips)
+
+}
+
+func TestWindowWeight(t *testing.T) {
+
+	minute := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	require.Equal(t, 1.0, windowWeight(minute, minute))
+	require.Equal(t, 1.0, windowWeight(minute, minute.Add(-time.Hour)))
+	require.Equal(t, 0.75, windowWeight(minute, minute.Add(15*time.Second)))
+	require.Equal(t, 0.0, windowWeight(minute, minute.Add(time.Minute)))
+	require.Equal(t, 0.0, windowWeight(minute, minute.Add(time.Hour)))
+}
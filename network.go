@@ -0,0 +1,100 @@
+package traffic
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Network single IP or CIDR range.
+// Text form is plain address for single IP and CIDR notation otherwise
+type Network struct {
+	net.IPNet
+}
+
+// normalizeIP converts IPv4 to 4-byte form, so it is stored as IPv4 instead of IPv4-mapped IPv6 address
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
+
+// NetworkFromIP network of single IP
+func NetworkFromIP(ip net.IP) Network {
+	ip = normalizeIP(ip)
+	bits := len(ip) * 8
+
+	return Network{net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}
+}
+
+// ParseNetwork parses IP or CIDR notation. Host bits of CIDR are cleared
+func ParseNetwork(value string) (Network, error) {
+	value = strings.TrimSpace(value)
+
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return Network{}, fmt.Errorf("invalid IP `%s`", value)
+		}
+
+		return NetworkFromIP(ip), nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return Network{}, fmt.Errorf("invalid network `%s`", value)
+	}
+
+	return Network{*ipNet}, nil
+}
+
+// IsHost reports network of single IP
+func (n Network) IsHost() bool {
+	ones, bits := n.Mask.Size()
+	return ones == bits
+}
+
+// ContainsNetwork reports that other network is subnet of n or equal to it
+func (n Network) ContainsNetwork(other Network) bool {
+	ones, bits := n.Mask.Size()
+	otherOnes, otherBits := other.Mask.Size()
+
+	return bits == otherBits && ones <= otherOnes && n.Contains(other.IP)
+}
+
+func (n Network) String() string {
+	if n.IP == nil {
+		return ""
+	}
+
+	if n.IsHost() {
+		return n.IP.String()
+	}
+
+	return n.IPNet.String()
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (n Network) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (n *Network) UnmarshalText(text []byte) error {
+	network, err := ParseNetwork(string(text))
+	if err != nil {
+		return err
+	}
+
+	*n = network
+
+	return nil
+}
+
+// Value implements driver.Valuer, so Network can be passed as inet query argument
+func (n Network) Value() (driver.Value, error) {
+	return n.IPNet.String(), nil
+}
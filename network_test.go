@@ -0,0 +1,70 @@
+package traffic
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetwork(t *testing.T) {
+
+	network, err := ParseNetwork("192.168.0.1")
+	require.NoError(t, err)
+	require.True(t, network.IsHost())
+	require.Equal(t, "192.168.0.1", network.String())
+	require.Equal(t, NetworkFromIP(net.IPv4(192, 168, 0, 1)), network)
+
+	network, err = ParseNetwork("192.168.0.17/24")
+	require.NoError(t, err)
+	require.False(t, network.IsHost())
+	require.Equal(t, "192.168.0.0/24", network.String())
+	require.True(t, network.Contains(net.IPv4(192, 168, 0, 200)))
+	require.False(t, network.Contains(net.IPv4(192, 168, 1, 1)))
+
+	value, err := network.Value()
+	require.NoError(t, err)
+	require.Equal(t, "192.168.0.0/24", value)
+
+	network, err = ParseNetwork("2a02:6b8::/32")
+	require.NoError(t, err)
+	require.Equal(t, "2a02:6b8::/32", network.String())
+
+	_, err = ParseNetwork("192.168.0.1/33")
+	require.Error(t, err)
+
+	_, err = ParseNetwork("example.com")
+	require.Error(t, err)
+}
+
+func TestNetworkContainsNetwork(t *testing.T) {
+
+	outer, err := ParseNetwork("10.0.0.0/16")
+	require.NoError(t, err)
+	inner, err := ParseNetwork("10.0.5.0/24")
+	require.NoError(t, err)
+
+	require.True(t, outer.ContainsNetwork(inner))
+	require.True(t, outer.ContainsNetwork(outer))
+	require.False(t, inner.ContainsNetwork(outer))
+	require.True(t, inner.ContainsNetwork(NetworkFromIP(net.IPv4(10, 0, 5, 1))))
+}
+
+func TestNetworkJSON(t *testing.T) {
+
+	var request struct {
+		IP Network `json:"ip"`
+	}
+
+	err := json.Unmarshal([]byte(`{"ip":"10.0.0.1/8"}`), &request)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.0/8", request.IP.String())
+
+	b, err := json.Marshal(request)
+	require.NoError(t, err)
+	require.Equal(t, `{"ip":"10.0.0.0/8"}`, string(b))
+
+	err = json.Unmarshal([]byte(`{"ip":"not an ip"}`), &request)
+	require.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package traffic
+
+import (
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Repositories storage backends used by Traffic
+type Repositories struct {
+	Ban        BanRepository
+	Whitelist  WhitelistRepository
+	Monitoring MonitoringRepository
+	Top        TopRepository
+}
+
+// NewPostgresRepositories creates repositories backed by postgres
+func NewPostgresRepositories(db *pgxpool.Pool) Repositories {
+	return Repositories{
+		Ban:        NewPostgresBanRepository(db),
+		Whitelist:  NewPostgresWhitelistRepository(db),
+		Monitoring: NewPostgresMonitoringRepository(db),
+		Top:        NewPostgresTopRepository(db),
+	}
+}
+
+// NewMemoryRepositories creates repositories which keeps data in process memory
+func NewMemoryRepositories() Repositories {
+	ban := NewMemoryBanRepository()
+	whitelist := NewMemoryWhitelistRepository()
+	monitoring := NewMemoryMonitoringRepository()
+
+	return Repositories{
+		Ban:        ban,
+		Whitelist:  whitelist,
+		Monitoring: monitoring,
+		Top:        NewMemoryTopRepository(monitoring, ban, whitelist),
+	}
+}
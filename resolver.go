@@ -0,0 +1,185 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dnsLookupTimeout = 5 * time.Second
+	// dnsCacheGCSize number of cached entries after which expired ones are purged
+	dnsCacheGCSize = 10000
+)
+
+// Resolver resolves PTR and A/AAAA records. *net.Resolver satisfies it
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// forwardConfirmed checks that host resolves back to IP.
+// PTR record alone can be set to any name by owner of reverse zone
+func forwardConfirmed(ctx context.Context, resolver Resolver, host string, ip net.IP) (bool, error) {
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		if isDNSNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, addr := range addrs {
+		if addr.IP.Equal(ip) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isDNSNotFound lookup failed because name does not exist, not because of resolver failure
+func isDNSNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// normalizeHost lowercases host and makes it fully qualified
+func normalizeHost(host string) string {
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+
+	return host
+}
+
+type dnsCacheEntry struct {
+	hosts   []string
+	addrs   []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+// DNSCacheStats DNSCacheStats
+type DNSCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// CachingResolver caches lookups of underlying resolver.
+// TTL of records is honoured when resolver reports it, failures are cached for negative TTL
+type CachingResolver struct {
+	resolver    Resolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+	mutex       sync.Mutex
+	entries     map[string]dnsCacheEntry
+	hits        uint64
+	misses      uint64
+	now         func() time.Time
+}
+
+// NewCachingResolver constructor. ttl limits caching of successful lookups
+func NewCachingResolver(resolver Resolver, ttl time.Duration, negativeTTL time.Duration) *CachingResolver {
+	return &CachingResolver{
+		resolver:    resolver,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]dnsCacheEntry),
+		now:         time.Now,
+	}
+}
+
+// LookupAddr returns hostnames of address
+func (s *CachingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	entry := s.lookup(ctx, "ptr:"+addr, func() (dnsCacheEntry, time.Duration) {
+		if resolver, ok := s.resolver.(TTLResolver); ok {
+			hosts, ttl, err := resolver.LookupAddrTTL(ctx, addr)
+			return dnsCacheEntry{hosts: hosts, err: err}, ttl
+		}
+
+		hosts, err := s.resolver.LookupAddr(ctx, addr)
+		return dnsCacheEntry{hosts: hosts, err: err}, s.ttl
+	})
+
+	return entry.hosts, entry.err
+}
+
+// LookupIPAddr returns addresses of host
+func (s *CachingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	host = normalizeHost(host)
+
+	entry := s.lookup(ctx, "ip:"+host, func() (dnsCacheEntry, time.Duration) {
+		if resolver, ok := s.resolver.(TTLResolver); ok {
+			addrs, ttl, err := resolver.LookupIPAddrTTL(ctx, host)
+			return dnsCacheEntry{addrs: addrs, err: err}, ttl
+		}
+
+		addrs, err := s.resolver.LookupIPAddr(ctx, host)
+		return dnsCacheEntry{addrs: addrs, err: err}, s.ttl
+	})
+
+	return entry.addrs, entry.err
+}
+
+// Stats counters of cache
+func (s *CachingResolver) Stats() DNSCacheStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return DNSCacheStats{
+		Hits:    s.hits,
+		Misses:  s.misses,
+		Entries: len(s.entries),
+	}
+}
+
+func (s *CachingResolver) lookup(ctx context.Context, key string, fetch func() (dnsCacheEntry, time.Duration)) dnsCacheEntry {
+	s.mutex.Lock()
+	entry, ok := s.entries[key]
+	if ok && s.now().Before(entry.expires) {
+		s.hits++
+		s.mutex.Unlock()
+		return entry
+	}
+	s.misses++
+	s.mutex.Unlock()
+
+	entry, ttl := fetch()
+	if entry.err != nil {
+		// lookup interrupted by caller says nothing about the name
+		if ctx.Err() != nil {
+			return entry
+		}
+		ttl = s.negativeTTL
+	}
+
+	if ttl > s.ttl {
+		ttl = s.ttl
+	}
+	if ttl <= 0 {
+		return entry
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if len(s.entries) >= dnsCacheGCSize {
+		for k, item := range s.entries {
+			if !now.Before(item.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	entry.expires = now.Add(ttl)
+	s.entries[key] = entry
+
+	return entry
+}
@@ -0,0 +1,305 @@
+package traffic
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS authoritative DNS server on loopback for tests
+type fakeDNS struct {
+	conn    *net.UDPConn
+	mutex   sync.Mutex
+	ttl     uint32
+	records map[string][]dnsmessage.Resource
+	queries int
+}
+
+func startFakeDNS(t testing.TB) *fakeDNS {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	f := &fakeDNS{
+		conn:    conn,
+		ttl:     300,
+		records: make(map[string][]dnsmessage.Resource),
+	}
+
+	go f.serve()
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return f
+}
+
+// Addr address of server
+func (f *fakeDNS) Addr() string {
+	return f.conn.LocalAddr().String()
+}
+
+// Resolver which sends all queries to the server
+func (f *fakeDNS) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", f.Addr())
+		},
+	}
+}
+
+// Queries number of queries answered
+func (f *fakeDNS) Queries() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.queries
+}
+
+func (f *fakeDNS) add(name string, rType dnsmessage.Type, body dnsmessage.ResourceBody) {
+	f.addOwned(name, name, rType, body)
+}
+
+// addOwned adds record owned by owner to answers of queries of name, as spoofed response would do
+func (f *fakeDNS) addOwned(name string, owner string, rType dnsmessage.Type, body dnsmessage.ResourceBody) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name = normalizeHost(name)
+	f.records[name] = append(f.records[name], dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(normalizeHost(owner)),
+			Type:  rType,
+			Class: dnsmessage.ClassINET,
+			TTL:   f.ttl,
+		},
+		Body: body,
+	})
+}
+
+// AddPTR adds PTR record of IP
+func (f *fakeDNS) AddPTR(ip net.IP, host string) {
+	f.add(reverseName(ip), dnsmessage.TypePTR, &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(normalizeHost(host))})
+}
+
+// AddIP adds A or AAAA record of host
+func (f *fakeDNS) AddIP(host string, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		var a [4]byte
+		copy(a[:], ip4)
+		f.add(host, dnsmessage.TypeA, &dnsmessage.AResource{A: a})
+		return
+	}
+
+	var aaaa [16]byte
+	copy(aaaa[:], ip.To16())
+	f.add(host, dnsmessage.TypeAAAA, &dnsmessage.AAAAResource{AAAA: aaaa})
+}
+
+// AddCNAME adds alias of host, records of target are answered after it
+func (f *fakeDNS) AddCNAME(host string, target string) {
+	f.add(host, dnsmessage.TypeCNAME, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(normalizeHost(target))})
+}
+
+func (f *fakeDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		response, err := f.answer(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		_, _ = f.conn.WriteToUDP(response, addr)
+	}
+}
+
+func (f *fakeDNS) answer(request []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(request)
+	if err != nil {
+		return nil, err
+	}
+
+	question, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.queries++
+
+	records, found := f.records[normalizeHost(question.Name.String())]
+
+	responseHeader := dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              dnsmessage.RCodeSuccess,
+	}
+	if !found {
+		responseHeader.RCode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(nil, responseHeader)
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(question); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	for idx := 0; idx < len(records); idx++ {
+		record := records[idx]
+		if cname, ok := record.Body.(*dnsmessage.CNAMEResource); ok {
+			if err := b.CNAMEResource(record.Header, *cname); err != nil {
+				return nil, err
+			}
+			target := f.records[normalizeHost(cname.CNAME.String())]
+			records = append(append([]dnsmessage.Resource{}, records...), target...)
+			continue
+		}
+
+		if record.Header.Type != question.Type {
+			continue
+		}
+
+		switch body := record.Body.(type) {
+		case *dnsmessage.AResource:
+			err = b.AResource(record.Header, *body)
+		case *dnsmessage.AAAAResource:
+			err = b.AAAAResource(record.Header, *body)
+		case *dnsmessage.PTRResource:
+			err = b.PTRResource(record.Header, *body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.Finish()
+}
+
+func TestCachingResolver(t *testing.T) {
+
+	dns := startFakeDNS(t)
+	ip := net.IPv4(66, 249, 66, 1)
+	dns.AddPTR(ip, "crawl-66-249-66-1.googlebot.com")
+	dns.AddIP("crawl-66-249-66-1.googlebot.com", ip)
+
+	now := time.Now()
+	resolver := NewCachingResolver(dns.Resolver(), time.Hour, time.Minute)
+	resolver.now = func() time.Time {
+		return now
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		hosts, err := resolver.LookupAddr(ctx, ip.String())
+		require.NoError(t, err)
+		require.Equal(t, []string{"crawl-66-249-66-1.googlebot.com."}, hosts)
+
+		confirmed, err := forwardConfirmed(ctx, resolver, hosts[0], ip)
+		require.NoError(t, err)
+		require.True(t, confirmed)
+	}
+
+	queries := dns.Queries()
+
+	now = now.Add(2 * time.Hour)
+	_, err := resolver.LookupAddr(ctx, ip.String())
+	require.NoError(t, err)
+	require.Greater(t, dns.Queries(), queries)
+}
+
+func TestCachingResolverTTL(t *testing.T) {
+
+	dns := startFakeDNS(t)
+	dns.ttl = 60
+
+	ip := net.IPv4(66, 249, 66, 1)
+	dns.AddPTR(ip, "crawl-66-249-66-1.googlebot.com")
+
+	now := time.Now()
+	resolver := NewCachingResolver(NewDNSClient([]string{dns.Addr()}), time.Hour, 5*time.Minute)
+	resolver.now = func() time.Time {
+		return now
+	}
+	ctx := context.Background()
+
+	_, err := resolver.LookupAddr(ctx, ip.String())
+	require.NoError(t, err)
+	_, err = resolver.LookupAddr(ctx, ip.String())
+	require.NoError(t, err)
+
+	// NXDOMAIN is cached for negative TTL
+	_, err = resolver.LookupIPAddr(ctx, "crawl-66-249-66-1.googlebot.com")
+	require.Error(t, err)
+	_, err = resolver.LookupIPAddr(ctx, "crawl-66-249-66-1.googlebot.com.")
+	require.Error(t, err)
+
+	queries := dns.Queries()
+	require.Equal(t, DNSCacheStats{Hits: 2, Misses: 2, Entries: 2}, resolver.Stats())
+
+	// record TTL is expired
+	now = now.Add(2 * time.Minute)
+	_, err = resolver.LookupAddr(ctx, ip.String())
+	require.NoError(t, err)
+	require.Equal(t, queries+1, dns.Queries())
+
+	_, err = resolver.LookupIPAddr(ctx, "crawl-66-249-66-1.googlebot.com")
+	require.Error(t, err)
+	require.Equal(t, queries+1, dns.Queries())
+
+	// negative TTL is expired
+	now = now.Add(5 * time.Minute)
+	dns.AddIP("crawl-66-249-66-1.googlebot.com", ip)
+	addrs, err := resolver.LookupIPAddr(ctx, "crawl-66-249-66-1.googlebot.com")
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+}
+
+func TestForwardConfirmed(t *testing.T) {
+
+	dns := startFakeDNS(t)
+	dns.AddIP("crawl-66-249-66-1.googlebot.com", net.IPv4(66, 249, 66, 1))
+	dns.AddIP("crawl-66-249-66-1.googlebot.com", net.ParseIP("2001:4860:4801:10::1"))
+
+	resolver := dns.Resolver()
+	ctx := context.Background()
+
+	confirmed, err := forwardConfirmed(ctx, resolver, "crawl-66-249-66-1.googlebot.com.", net.IPv4(66, 249, 66, 1))
+	require.NoError(t, err)
+	require.True(t, confirmed)
+
+	confirmed, err = forwardConfirmed(ctx, resolver, "crawl-66-249-66-1.googlebot.com.", net.ParseIP("2001:4860:4801:10::1"))
+	require.NoError(t, err)
+	require.True(t, confirmed)
+
+	confirmed, err = forwardConfirmed(ctx, resolver, "crawl-66-249-66-1.googlebot.com.", net.IPv4(66, 249, 66, 2))
+	require.NoError(t, err)
+	require.False(t, confirmed)
+
+	// NXDOMAIN is not a failure
+	confirmed, err = forwardConfirmed(ctx, resolver, "unknown.googlebot.com.", net.IPv4(66, 249, 66, 1))
+	require.NoError(t, err)
+	require.False(t, confirmed)
+}
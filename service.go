@@ -1,10 +1,13 @@
 package traffic
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	sentrygin "github.com/getsentry/sentry-go/gin"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -26,12 +29,12 @@ type Service struct {
 	config     Config
 	logger     *util.Logger
 	db         *pgxpool.Pool
-	rabbitMQ   *amqp.Connection
 	waitGroup  *sync.WaitGroup
 	router     *gin.Engine
 	httpServer *http.Server
 	Traffic    *Traffic
 	pool       *pgxpool.Pool
+	quit       chan bool
 }
 
 // NewService constructor
@@ -40,9 +43,9 @@ func NewService(config Config) (*Service, error) {
 		config:    config,
 		logger:    util.NewLogger(config.Sentry),
 		db:        nil,
-		rabbitMQ:  nil,
 		waitGroup: &sync.WaitGroup{},
 		Traffic:   nil,
+		quit:      make(chan bool),
 	}
 	return s, nil
 }
@@ -57,7 +60,7 @@ func (s *Service) initModel() error {
 		return err
 	}
 
-	traffic, err := NewTraffic(s.pool, s.logger)
+	traffic, err := NewTraffic(NewPostgresRepositories(s.pool), s.logger, s.config)
 	if err != nil {
 		s.logger.Fatal(err)
 		return err
@@ -89,6 +92,22 @@ func (s *Service) Serve() error {
 		return err
 	}
 
+	err = s.Traffic.Snapshot.Load()
+	if err != nil {
+		s.logger.Fatal(err)
+		return err
+	}
+
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+		fmt.Println("Snapshot refresh started")
+		s.Traffic.Snapshot.Run(s.config.Snapshot, s.quit)
+		fmt.Println("Snapshot refresh stopped")
+	}()
+
+	s.listenChanges(s.Traffic.Snapshot)
+
 	r := gin.New()
 	r.Use(gin.Recovery())
 
@@ -134,6 +153,42 @@ func (s *Service) SchedulerHourly() error {
 	}
 	fmt.Printf("`%v` items of ban deleted\n", deleted)
 
+	deleted, err = s.Traffic.Ban.GCHistory()
+	if err != nil {
+		s.logger.Fatal(err)
+		return err
+	}
+	fmt.Printf("`%v` items of ban history deleted\n", deleted)
+
+	deleted, err = s.Traffic.Whitelist.GC()
+	if err != nil {
+		s.logger.Fatal(err)
+		return err
+	}
+	fmt.Printf("`%v` expired items of whitelist deleted\n", deleted)
+
+	changesBefore := time.Now().Add(-s.config.Snapshot.ChangeRetention)
+	deleted, err = s.Traffic.Ban.GCChanges(changesBefore)
+	if err != nil {
+		s.logger.Fatal(err)
+		return err
+	}
+	fmt.Printf("`%v` items of ban change log deleted\n", deleted)
+
+	deleted, err = s.Traffic.Whitelist.GCChanges(changesBefore)
+	if err != nil {
+		s.logger.Fatal(err)
+		return err
+	}
+	fmt.Printf("`%v` items of whitelist change log deleted\n", deleted)
+
+	verified, removed, err := s.Traffic.ReverifyWhitelist()
+	if err != nil {
+		s.logger.Warning(err)
+		return err
+	}
+	fmt.Printf("`%v` autodetected items of whitelist verified, `%v` removed\n", verified, removed)
+
 	err = s.Traffic.AutoWhitelist()
 	if err != nil {
 		s.logger.Warning(err)
@@ -143,6 +198,68 @@ func (s *Service) SchedulerHourly() error {
 	return nil
 }
 
+// WhitelistImport imports range file into whitelist under named source
+func (s *Service) WhitelistImport(name string, path string) error {
+	err := s.initModel()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer util.Close(file)
+
+	networks, err := ParseIPRanges(file)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.Traffic.ImportWhitelist(name, networks)
+	if err != nil {
+		s.logger.Warning(err)
+		return err
+	}
+
+	fmt.Printf("`%v` networks of %s: `%v` added, `%v` removed\n", result.Total, name, result.Added, result.Removed)
+
+	return nil
+}
+
+// BlocklistImport bans networks of list file with name of list as reason
+func (s *Service) BlocklistImport(name string, path string, duration time.Duration) error {
+	err := s.initModel()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer util.Close(file)
+
+	networks, err := ParseBlocklist(file)
+	if err != nil {
+		return err
+	}
+
+	if duration <= 0 {
+		duration = s.config.Blocklist.Duration
+	}
+
+	result, err := s.Traffic.Ban.Import(name, networks, duration)
+	if err != nil {
+		s.logger.Warning(err)
+		return err
+	}
+
+	fmt.Printf("`%v` networks of %s: `%v` added, `%v` lifted\n", result.Total, name, result.Added, result.Lifted)
+
+	return nil
+}
+
 func (s *Service) SchedulerMinutely() error {
 	err := s.initModel()
 	if err != nil {
@@ -163,21 +280,112 @@ func (s *Service) ListenAMQP(quit chan bool) error {
 		return err
 	}
 
-	err = s.waitForAMQP()
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+		fmt.Println("Monitoring listener started")
+		dial := func() (*amqp.Connection, error) {
+			return amqp.Dial(s.config.RabbitMQ)
+		}
+		err := s.Traffic.Monitoring.Listen(dial, s.config.MonitoringQueue, s.config.Monitoring, quit)
+		if err != nil {
+			s.logger.Fatal(err)
+		}
+		fmt.Println("Monitoring listener stopped")
+	}()
+
+	if s.Traffic.Detector != nil {
+		s.waitGroup.Add(1)
+		go func() {
+			defer s.waitGroup.Done()
+			fmt.Println("Realtime detection started")
+			s.Traffic.RunDetections(quit)
+			fmt.Println("Realtime detection stopped")
+		}()
+	}
+
+	return nil
+}
+
+// SyncFiles keeps configured files of ban export up to date until quit
+func (s *Service) SyncFiles(quit chan bool) error {
+	if len(s.config.SyncFiles.Files) == 0 {
+		return fmt.Errorf("sync_files.files not configured")
+	}
+
+	err := s.initModel()
 	if err != nil {
 		return err
 	}
 
+	syncer := NewFileSyncer(s.Traffic.BanExport, s.config.SyncFiles, s.logger)
+
+	s.listenChanges(syncer)
+
 	s.waitGroup.Add(1)
 	go func() {
 		defer s.waitGroup.Done()
-		fmt.Println("Monitoring listener started")
-		err := s.Traffic.Monitoring.Listen(s.rabbitMQ, s.config.MonitoringQueue, quit)
+		fmt.Println("File sync started")
+		syncer.Run(quit)
+		fmt.Println("File sync stopped")
+	}()
+
+	return nil
+}
+
+// listenChanges passes change notifications of bans and whitelist to observers until service is closed
+func (s *Service) listenChanges(observers ...ChangeObserver) {
+	if !s.config.Notify.Enabled {
+		return
+	}
+
+	listener := NewChangeListener(func(ctx context.Context) (*pgx.Conn, error) {
+		return pgx.Connect(ctx, s.config.DSN)
+	}, s.logger)
+	for _, observer := range observers {
+		listener.AddObserver(observer)
+	}
+
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+		fmt.Println("Change listener started")
+		err := listener.Listen(s.config.Notify, s.quit)
 		if err != nil {
 			s.logger.Fatal(err)
 		}
-		fmt.Println("Monitoring listener stopped")
+		fmt.Println("Change listener stopped")
 	}()
+}
+
+// BanExport writes active bans minus whitelist in given format to file
+func (s *Service) BanExport(format string, name string, path string) error {
+	if !ValidExportFormat(format) {
+		return fmt.Errorf("unsupported format `%s`", format)
+	}
+
+	err := s.initModel()
+	if err != nil {
+		return err
+	}
+
+	networks, err := s.Traffic.BanExport()
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	err = RenderBanExport(&body, format, name, networks, 0)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(path, body.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("`%v` networks exported to %s\n", len(networks), path)
 
 	return nil
 }
@@ -227,39 +435,6 @@ func (s *Service) waitForDB() error {
 	return nil
 }
 
-func (s *Service) waitForAMQP() error {
-
-	if s.rabbitMQ != nil {
-		return nil
-	}
-
-	start := time.Now()
-	timeout := 60 * time.Second
-
-	fmt.Println("Waiting for rabbitMQ")
-
-	var rabbitMQ *amqp.Connection
-	var err error
-	for {
-		rabbitMQ, err = amqp.Dial(s.config.RabbitMQ)
-		if err == nil {
-			fmt.Println("Started.")
-			break
-		}
-
-		if time.Since(start) > timeout {
-			return err
-		}
-
-		fmt.Print(".")
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	s.rabbitMQ = rabbitMQ
-
-	return nil
-}
-
 func applyMigrations(config MigrationsConfig) error {
 	fmt.Println("Apply migrations")
 
@@ -297,18 +472,13 @@ func (s *Service) Close() {
 		}
 	}
 
+	close(s.quit)
+
 	s.waitGroup.Wait()
 
 	if s.db != nil {
 		s.db.Close()
 	}
-
-	if s.rabbitMQ != nil {
-		err := s.rabbitMQ.Close()
-		if err != nil {
-			s.logger.Warning(err)
-		}
-	}
 }
 
 // GetRouter GetRouter
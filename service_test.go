@@ -1,9 +1,13 @@
 package traffic
 
 import (
+	"context"
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMigrations(t *testing.T) {
@@ -15,3 +19,94 @@ func TestMigrations(t *testing.T) {
 		require.NoError(t, err)
 	}
 }
+
+// TestMigrationsFromScratch applies every migration to empty schema, so broken migration can't pass unnoticed
+// on database which is already migrated
+func TestMigrationsFromScratch(t *testing.T) {
+
+	config := LoadConfig()
+	ctx := context.Background()
+	const schema = "traffic_migrations_test"
+
+	pool, err := pgxpool.Connect(ctx, config.DSN)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Exec(ctx, "DROP SCHEMA IF EXISTS "+schema+" CASCADE")
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "CREATE SCHEMA "+schema)
+	require.NoError(t, err)
+	defer func() {
+		_, err := pool.Exec(ctx, "DROP SCHEMA IF EXISTS "+schema+" CASCADE")
+		require.NoError(t, err)
+	}()
+
+	separator := "?"
+	if strings.Contains(config.Migrations.DSN, "?") {
+		separator = "&"
+	}
+
+	m, err := migrate.New("file://"+config.Migrations.Dir, config.Migrations.DSN+separator+"search_path="+schema)
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.NoError(t, m.Migrate(3))
+
+	// both forms of IPv4 address stored before migration to networks
+	until := time.Now().Add(time.Hour)
+	_, err = pool.Exec(ctx, `
+		INSERT INTO `+schema+`.ip_ban (ip, until, reason) VALUES
+			('::ffff:192.0.2.1', $1::timestamptz + interval '1 hour', 'mapped'),
+			('192.0.2.1', $1, 'plain'),
+			('::ffff:192.0.2.2', $1, 'mapped only')
+	`, until)
+	require.NoError(t, err)
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO `+schema+`.ip_whitelist (ip, description) VALUES
+			('::ffff:192.0.2.3', 'msnbot autodetect'),
+			('192.0.2.3', 'plain'),
+			('::ffff:192.0.2.4', 'yandex.com ipv6 autodetect')
+	`)
+	require.NoError(t, err)
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO `+schema+`.ip_monitoring (ip, day_date, hour, tenminute, minute, count) VALUES
+			('::ffff:192.0.2.5', '2020-01-02', 10, 0, 5, 2),
+			('192.0.2.5', '2020-01-02', 10, 0, 5, 3)
+	`)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Up())
+
+	var reason string
+	var bans int
+	err = pool.QueryRow(ctx, `SELECT COUNT(1) FROM `+schema+`.ip_ban WHERE family(ip) = 6`).Scan(&bans)
+	require.NoError(t, err)
+	require.Zero(t, bans)
+
+	// longest of duplicate bans wins
+	err = pool.QueryRow(ctx, `SELECT reason FROM `+schema+`.ip_ban WHERE ip = '192.0.2.1'`).Scan(&reason)
+	require.NoError(t, err)
+	require.Equal(t, "mapped", reason)
+
+	err = pool.QueryRow(ctx, `SELECT reason FROM `+schema+`.ip_ban WHERE ip = '192.0.2.2'`).Scan(&reason)
+	require.NoError(t, err)
+	require.Equal(t, "mapped only", reason)
+
+	var description, source string
+	err = pool.QueryRow(ctx, `SELECT description, source FROM `+schema+`.ip_whitelist WHERE ip = '192.0.2.3'`).
+		Scan(&description, &source)
+	require.NoError(t, err)
+	require.Equal(t, "plain", description)
+	require.Equal(t, WhitelistSourceManual, source)
+
+	err = pool.QueryRow(ctx, `SELECT source FROM `+schema+`.ip_whitelist WHERE ip = '192.0.2.4'`).Scan(&source)
+	require.NoError(t, err)
+	require.Equal(t, WhitelistSourceAutodetect+"yandex", source)
+
+	var count int
+	err = pool.QueryRow(ctx, `SELECT SUM(count) FROM `+schema+`.ip_monitoring WHERE ip <<= '192.0.2.5'`).Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 5, count)
+}
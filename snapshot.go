@@ -0,0 +1,338 @@
+package traffic
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/autowp/traffic/util"
+)
+
+// snapshotChangesBatch limits number of changes read by single query
+const snapshotChangesBatch = 10000
+
+// prefixIndex counts networks by prefix length, so IP is looked up by masking it with each present length
+type prefixIndex struct {
+	ipv4 [net.IPv4len*8 + 1]int
+	ipv6 [net.IPv6len*8 + 1]int
+}
+
+func (p *prefixIndex) add(network Network, delta int) {
+	ones, bits := network.Mask.Size()
+	switch bits {
+	case net.IPv4len * 8:
+		p.ipv4[ones] += delta
+	case net.IPv6len * 8:
+		p.ipv6[ones] += delta
+	}
+}
+
+// lookup calls fn with keys of networks which may contain IP, from most specific one, until fn returns true
+func (p *prefixIndex) lookup(ip net.IP, fn func(key string) bool) {
+	ip = normalizeIP(ip)
+	bits := len(ip) * 8
+
+	counts := p.ipv4[:]
+	if len(ip) == net.IPv6len {
+		counts = p.ipv6[:]
+	}
+
+	for ones := bits; ones >= 0; ones-- {
+		if counts[ones] == 0 {
+			continue
+		}
+
+		mask := net.CIDRMask(ones, bits)
+		if fn(Network{net.IPNet{IP: ip.Mask(mask), Mask: mask}}.String()) {
+			return
+		}
+	}
+}
+
+// Snapshot in-memory copy of bans and whitelist which answers checks without queries.
+// It is refreshed incrementally from change logs of repositories, each followed by own cursor,
+// as soon as change is notified and periodically.
+// Change of transaction committed after changes with greater id is not seen by cursor,
+// so snapshot is also fully reloaded from time to time
+type Snapshot struct {
+	banRepository       BanRepository
+	whitelistRepository WhitelistRepository
+	logger              *util.Logger
+
+	// refreshMutex serializes loads and refreshes, it guards cursors
+	refreshMutex    sync.Mutex
+	banCursor       int64
+	whitelistCursor int64
+
+	// pending signals notified changes to Run, so bursts of notifications are applied by single refresh
+	pending chan struct{}
+
+	mutex             sync.RWMutex
+	loaded            bool
+	bans              map[string]BanItem
+	banPrefixes       prefixIndex
+	whitelist         map[string]WhitelistItem
+	whitelistPrefixes prefixIndex
+}
+
+// NewSnapshot constructor
+func NewSnapshot(banRepository BanRepository, whitelistRepository WhitelistRepository, logger *util.Logger) (*Snapshot, error) {
+	if banRepository == nil {
+		return nil, fmt.Errorf("ban repository is nil")
+	}
+
+	if whitelistRepository == nil {
+		return nil, fmt.Errorf("whitelist repository is nil")
+	}
+
+	return &Snapshot{
+		banRepository:       banRepository,
+		whitelistRepository: whitelistRepository,
+		logger:              logger,
+		bans:                make(map[string]BanItem),
+		whitelist:           make(map[string]WhitelistItem),
+		pending:             make(chan struct{}, 1),
+	}, nil
+}
+
+// Loaded reports that snapshot was loaded at least once
+func (s *Snapshot) Loaded() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.loaded
+}
+
+// Len numbers of bans and whitelist items in snapshot
+func (s *Snapshot) Len() (int, int) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.bans), len(s.whitelist)
+}
+
+// Load replaces snapshot with full copy of repositories
+func (s *Snapshot) Load() error {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	return s.load()
+}
+
+func (s *Snapshot) load() error {
+	// cursors are taken before lists, so changes made meanwhile are applied again by next refresh
+	banCursor, err := s.banRepository.LastChange()
+	if err != nil {
+		return err
+	}
+
+	whitelistCursor, err := s.whitelistRepository.LastChange()
+	if err != nil {
+		return err
+	}
+
+	bans, err := s.banRepository.List()
+	if err != nil {
+		return err
+	}
+
+	whitelist, err := s.whitelistRepository.List()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bans = make(map[string]BanItem, len(bans))
+	s.banPrefixes = prefixIndex{}
+	for _, item := range bans {
+		s.setBan(item.IP, &item)
+	}
+
+	s.whitelist = make(map[string]WhitelistItem, len(whitelist))
+	s.whitelistPrefixes = prefixIndex{}
+	for _, item := range whitelist {
+		s.setWhitelistItem(item.IP, &item)
+	}
+
+	s.banCursor = banCursor
+	s.whitelistCursor = whitelistCursor
+	s.loaded = true
+
+	return nil
+}
+
+// Refresh applies changes recorded since previous load or refresh. Snapshot is loaded when it is not yet
+func (s *Snapshot) Refresh() error {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	if !s.Loaded() {
+		return s.load()
+	}
+
+	for {
+		changes, err := s.banRepository.Changes(s.banCursor, snapshotChangesBatch)
+		if err != nil {
+			return err
+		}
+
+		if len(changes) == 0 {
+			break
+		}
+
+		s.mutex.Lock()
+		for _, change := range changes {
+			s.setBan(change.IP, change.Item)
+		}
+		s.mutex.Unlock()
+
+		s.banCursor = changes[len(changes)-1].ID
+
+		if len(changes) < snapshotChangesBatch {
+			break
+		}
+	}
+
+	for {
+		changes, err := s.whitelistRepository.Changes(s.whitelistCursor, snapshotChangesBatch)
+		if err != nil {
+			return err
+		}
+
+		if len(changes) == 0 {
+			break
+		}
+
+		s.mutex.Lock()
+		for _, change := range changes {
+			s.setWhitelistItem(change.IP, change.Item)
+		}
+		s.mutex.Unlock()
+
+		s.whitelistCursor = changes[len(changes)-1].ID
+
+		if len(changes) < snapshotChangesBatch {
+			break
+		}
+	}
+
+	return nil
+}
+
+// setBan stores or removes ban of network. Caller holds write lock
+func (s *Snapshot) setBan(network Network, item *BanItem) {
+	key := network.String()
+	_, exists := s.bans[key]
+
+	if item == nil {
+		if exists {
+			delete(s.bans, key)
+			s.banPrefixes.add(network, -1)
+		}
+		return
+	}
+
+	if !exists {
+		s.banPrefixes.add(network, 1)
+	}
+	s.bans[key] = *item
+}
+
+// setWhitelistItem stores or removes whitelist item of network. Caller holds write lock
+func (s *Snapshot) setWhitelistItem(network Network, item *WhitelistItem) {
+	key := network.String()
+	_, exists := s.whitelist[key]
+
+	if item == nil {
+		if exists {
+			delete(s.whitelist, key)
+			s.whitelistPrefixes.add(network, -1)
+		}
+		return
+	}
+
+	if !exists {
+		s.whitelistPrefixes.add(network, 1)
+	}
+	s.whitelist[key] = *item
+}
+
+// Check returns most specific ban which denies access of IP or nil when IP is allowed.
+// Not expired whitelist item takes precedence over bans
+func (s *Snapshot) Check(ip net.IP) *BanItem {
+	now := time.Now()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	whitelisted := false
+	s.whitelistPrefixes.lookup(ip, func(key string) bool {
+		item, ok := s.whitelist[key]
+		whitelisted = ok && !whitelistItemExpired(item, now)
+		return whitelisted
+	})
+	if whitelisted {
+		return nil
+	}
+
+	var result *BanItem
+	s.banPrefixes.lookup(ip, func(key string) bool {
+		item, ok := s.bans[key]
+		if !ok || item.Until.Before(now) {
+			return false
+		}
+
+		result = &item
+		return true
+	})
+
+	return result
+}
+
+// Resync implements ChangeObserver
+func (s *Snapshot) Resync() error {
+	return s.Load()
+}
+
+// Notify implements ChangeObserver. Change is applied by Run
+func (s *Snapshot) Notify(notification ChangeNotification) {
+	select {
+	case s.pending <- struct{}{}:
+	default:
+	}
+}
+
+// Run refreshes snapshot on notified changes and every config.RefreshInterval,
+// and reloads it every config.ResyncInterval until quit
+func (s *Snapshot) Run(config SnapshotConfig, quitChan chan bool) {
+	refreshTicker := time.NewTicker(config.RefreshInterval)
+	defer refreshTicker.Stop()
+
+	resyncTicker := time.NewTicker(config.ResyncInterval)
+	defer resyncTicker.Stop()
+
+	for {
+		select {
+		case <-s.pending:
+			err := s.Refresh()
+			if err != nil {
+				s.logger.Warningf("snapshot refresh: %v", err)
+			}
+		case <-refreshTicker.C:
+			err := s.Refresh()
+			if err != nil {
+				s.logger.Warningf("snapshot refresh: %v", err)
+			}
+		case <-resyncTicker.C:
+			err := s.Load()
+			if err != nil {
+				s.logger.Warningf("snapshot resync: %v", err)
+			}
+		case <-quitChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package traffic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/autowp/traffic/util"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseNetwork(t *testing.T, value string) Network {
+	network, err := ParseNetwork(value)
+	require.NoError(t, err)
+
+	return network
+}
+
+func createMemorySnapshot(t *testing.T) (*Snapshot, *MemoryBanRepository, *MemoryWhitelistRepository) {
+	banRepository := NewMemoryBanRepository()
+	whitelistRepository := NewMemoryWhitelistRepository()
+
+	snapshot, err := NewSnapshot(banRepository, whitelistRepository, util.NewLogger(LoadConfig().Sentry))
+	require.NoError(t, err)
+
+	return snapshot, banRepository, whitelistRepository
+}
+
+func TestSnapshotCheck(t *testing.T) {
+	snapshot, bans, whitelist := createMemorySnapshot(t)
+
+	now := time.Now()
+	require.NoError(t, bans.Add(mustParseNetwork(t, "192.0.2.0/24"), now.Add(time.Hour), 1, "subnet"))
+	require.NoError(t, bans.Add(mustParseNetwork(t, "192.0.2.7"), now.Add(2*time.Hour), 1, "host"))
+	require.NoError(t, bans.Add(mustParseNetwork(t, "192.0.2.8"), now.Add(-time.Minute), 1, "expired"))
+	require.NoError(t, bans.Add(mustParseNetwork(t, "2001:db8::/32"), now.Add(time.Hour), 1, "ipv6"))
+	require.NoError(t, whitelist.Add(WhitelistItem{IP: mustParseNetwork(t, "192.0.2.10"), Source: WhitelistSourceManual}))
+
+	expired := now.Add(-time.Minute)
+	require.NoError(t, whitelist.Add(WhitelistItem{
+		IP: mustParseNetwork(t, "192.0.2.11"), Source: WhitelistSourceManual, ExpiresAt: &expired,
+	}))
+
+	require.False(t, snapshot.Loaded())
+	require.NoError(t, snapshot.Load())
+	require.True(t, snapshot.Loaded())
+
+	ban := snapshot.Check(net.ParseIP("192.0.2.7"))
+	require.NotNil(t, ban)
+	require.Equal(t, "host", ban.Reason)
+
+	// expired ban of host falls back to ban of subnet
+	ban = snapshot.Check(net.ParseIP("192.0.2.8"))
+	require.NotNil(t, ban)
+	require.Equal(t, "subnet", ban.Reason)
+
+	ban = snapshot.Check(net.ParseIP("2001:db8::1"))
+	require.NotNil(t, ban)
+	require.Equal(t, "ipv6", ban.Reason)
+
+	require.Nil(t, snapshot.Check(net.ParseIP("192.0.2.10")))
+	require.NotNil(t, snapshot.Check(net.ParseIP("192.0.2.11")))
+	require.Nil(t, snapshot.Check(net.ParseIP("198.51.100.1")))
+	require.Nil(t, snapshot.Check(net.ParseIP("2001:db9::1")))
+}
+
+func TestSnapshotRefresh(t *testing.T) {
+	snapshot, bans, whitelist := createMemorySnapshot(t)
+
+	require.NoError(t, bans.Add(mustParseNetwork(t, "192.0.2.1"), time.Now().Add(time.Hour), 1, "test"))
+	require.NoError(t, snapshot.Refresh())
+	require.True(t, snapshot.Loaded())
+	require.NotNil(t, snapshot.Check(net.ParseIP("192.0.2.1")))
+
+	require.NoError(t, bans.Add(mustParseNetwork(t, "198.51.100.0/24"), time.Now().Add(time.Hour), 1, "test"))
+	require.NoError(t, whitelist.Add(WhitelistItem{IP: mustParseNetwork(t, "192.0.2.0/24"), Source: WhitelistSourceManual}))
+
+	// changes are not visible till refresh
+	require.Nil(t, snapshot.Check(net.ParseIP("198.51.100.1")))
+	require.NotNil(t, snapshot.Check(net.ParseIP("192.0.2.1")))
+
+	require.NoError(t, snapshot.Refresh())
+	require.NotNil(t, snapshot.Check(net.ParseIP("198.51.100.1")))
+	require.Nil(t, snapshot.Check(net.ParseIP("192.0.2.1")))
+
+	require.NoError(t, bans.Remove(mustParseNetwork(t, "198.51.100.0/24")))
+	require.NoError(t, whitelist.Remove(mustParseNetwork(t, "192.0.2.0/24")))
+	require.NoError(t, snapshot.Refresh())
+	require.Nil(t, snapshot.Check(net.ParseIP("198.51.100.1")))
+	require.NotNil(t, snapshot.Check(net.ParseIP("192.0.2.1")))
+
+	banCount, whitelistCount := snapshot.Len()
+	require.Equal(t, 1, banCount)
+	require.Equal(t, 0, whitelistCount)
+
+	deleted, err := bans.GCChanges(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), deleted)
+
+	changes, err := bans.Changes(0, snapshotChangesBatch)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func TestSnapshotRunAppliesNotifiedChanges(t *testing.T) {
+	snapshot, bans, _ := createMemorySnapshot(t)
+	require.NoError(t, snapshot.Resync())
+
+	quit := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		snapshot.Run(SnapshotConfig{RefreshInterval: time.Hour, ResyncInterval: time.Hour}, quit)
+		close(done)
+	}()
+
+	network := mustParseNetwork(t, "192.0.2.1")
+	require.NoError(t, bans.Add(network, time.Now().Add(time.Hour), 1, "test"))
+	snapshot.Notify(ChangeNotification{Table: "ip_ban", ID: 1, IP: network})
+
+	require.Eventually(t, func() bool {
+		return snapshot.Check(network.IP) != nil
+	}, time.Second, 10*time.Millisecond)
+
+	close(quit)
+	<-done
+}
@@ -0,0 +1,81 @@
+package traffic
+
+// maxSyncChanges limits number of changes of each list returned by single request
+const maxSyncChanges = 10000
+
+// SyncState full copy of bans and whitelist for clients which enforce bans themselves.
+// Cursors point to change logs, so client continues with SyncChanges from them
+type SyncState struct {
+	BanCursor       int64           `json:"ban_cursor"`
+	WhitelistCursor int64           `json:"whitelist_cursor"`
+	Bans            []BanItem       `json:"bans"`
+	Whitelist       []WhitelistItem `json:"whitelist"`
+}
+
+// SyncChanges changes of bans and whitelist recorded after cursors of client, and new cursors
+type SyncChanges struct {
+	BanCursor       int64             `json:"ban_cursor"`
+	WhitelistCursor int64             `json:"whitelist_cursor"`
+	Bans            []BanChange       `json:"bans"`
+	Whitelist       []WhitelistChange `json:"whitelist"`
+}
+
+// SyncState returns active bans and whitelist
+func (s *Traffic) SyncState() (SyncState, error) {
+	var state SyncState
+	var err error
+
+	// cursors are taken before lists, so changes made meanwhile are applied again by client
+	state.BanCursor, err = s.Ban.LastChange()
+	if err != nil {
+		return state, err
+	}
+
+	state.WhitelistCursor, err = s.Whitelist.LastChange()
+	if err != nil {
+		return state, err
+	}
+
+	state.Bans, err = s.Ban.List()
+	if err != nil {
+		return state, err
+	}
+
+	state.Whitelist, err = s.Whitelist.List()
+	if err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+// SyncChanges returns up to limit changes of each list recorded after given cursors
+func (s *Traffic) SyncChanges(banCursor int64, whitelistCursor int64, limit int) (SyncChanges, error) {
+	if limit <= 0 || limit > maxSyncChanges {
+		limit = maxSyncChanges
+	}
+
+	result := SyncChanges{
+		BanCursor:       banCursor,
+		WhitelistCursor: whitelistCursor,
+	}
+
+	var err error
+	result.Bans, err = s.Ban.Changes(banCursor, limit)
+	if err != nil {
+		return result, err
+	}
+	if len(result.Bans) > 0 {
+		result.BanCursor = result.Bans[len(result.Bans)-1].ID
+	}
+
+	result.Whitelist, err = s.Whitelist.Changes(whitelistCursor, limit)
+	if err != nil {
+		return result, err
+	}
+	if len(result.Whitelist) > 0 {
+		result.WhitelistCursor = result.Whitelist[len(result.Whitelist)-1].ID
+	}
+
+	return result, nil
+}
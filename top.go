@@ -0,0 +1,147 @@
+package traffic
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultTopLimit number of items of top when limit is not given
+	DefaultTopLimit = 50
+	// MaxTopLimit largest number of items of top
+	MaxTopLimit = 1000
+)
+
+// TopQuery period, grouping and filters of top of traffic
+type TopQuery struct {
+	Limit int
+	// Since and Until bound period of counted minutes, Until is exclusive
+	Since time.Time
+	Until time.Time
+	// IPv4Prefix and IPv6Prefix group requests by subnet of given prefix length. Zero means single IP
+	IPv4Prefix      int
+	IPv6Prefix      int
+	HideWhitelisted bool
+	HideBanned      bool
+}
+
+// TopRepository ranks networks by requests joined with their ban and whitelist state
+type TopRepository interface {
+	// Top returns networks with most requests, most active first.
+	// Offences of bans are counted since offencesSince
+	Top(query TopQuery, offencesSince time.Time) ([]TopItem, error)
+}
+
+// NewTopQuery query of current day grouped by IP
+func NewTopQuery() TopQuery {
+	today := startOfDay(time.Now())
+
+	return TopQuery{
+		Limit: DefaultTopLimit,
+		Since: today,
+		Until: today.AddDate(0, 0, 1),
+	}
+}
+
+// Validate checks that query can be evaluated
+func (q TopQuery) Validate() error {
+	if q.Limit <= 0 || q.Limit > MaxTopLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxTopLimit)
+	}
+
+	if !q.Since.Before(q.Until) {
+		return fmt.Errorf("empty period")
+	}
+
+	if q.IPv4Prefix < 0 || q.IPv4Prefix > net.IPv4len*8 {
+		return fmt.Errorf("ipv4_prefix must be between 0 and %d", net.IPv4len*8)
+	}
+
+	if q.IPv6Prefix < 0 || q.IPv6Prefix > net.IPv6len*8 {
+		return fmt.Errorf("ipv6_prefix must be between 0 and %d", net.IPv6len*8)
+	}
+
+	return nil
+}
+
+// Prefixes effective prefix lengths of IPv4 and IPv6 networks which requests are grouped by
+func (q TopQuery) Prefixes() (int, int) {
+	return aggregatePrefixes(q.IPv4Prefix, q.IPv6Prefix)
+}
+
+// Network which IP is grouped into
+func (q TopQuery) Network(ip net.IP) Network {
+	return aggregateNetwork(ip, q.IPv4Prefix, q.IPv6Prefix)
+}
+
+// Top networks with most requests during period of query with their ban and whitelist state
+func (s *Traffic) Top(query TopQuery) ([]TopItem, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s.top.Top(query, s.Ban.offencesSince())
+}
+
+// topQuery parses query of top request. Period is either window of last minutes or hours, e.g. 30m or 6h,
+// or day in YYYY-MM-DD form, current day by default
+func topQuery(c *gin.Context) (TopQuery, error) {
+	query := NewTopQuery()
+
+	var err error
+	if value := c.Query("limit"); len(value) > 0 {
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil {
+			return query, fmt.Errorf("invalid limit")
+		}
+	}
+
+	window, day := c.Query("window"), c.Query("day")
+	if len(window) > 0 && len(day) > 0 {
+		return query, fmt.Errorf("window and day can not be combined")
+	}
+
+	if len(window) > 0 {
+		duration, err := time.ParseDuration(window)
+		if err != nil || duration <= 0 {
+			return query, fmt.Errorf("invalid window, e.g. 30m or 6h expected")
+		}
+
+		// every minute overlapped by window is counted completely
+		now := time.Now()
+		query.Since = now.Add(-duration).Truncate(time.Minute)
+		query.Until = now.Truncate(time.Minute).Add(time.Minute)
+	}
+
+	if len(day) > 0 {
+		query.Since, err = time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil {
+			return query, fmt.Errorf("invalid day, YYYY-MM-DD expected")
+		}
+		query.Until = query.Since.AddDate(0, 0, 1)
+	}
+
+	for param, target := range map[string]*int{"ipv4_prefix": &query.IPv4Prefix, "ipv6_prefix": &query.IPv6Prefix} {
+		if value := c.Query(param); len(value) > 0 {
+			*target, err = strconv.Atoi(value)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s", param)
+			}
+		}
+	}
+
+	for param, target := range map[string]*bool{"hide_whitelisted": &query.HideWhitelisted, "hide_banned": &query.HideBanned} {
+		if value := c.Query(param); len(value) > 0 {
+			*target, err = strconv.ParseBool(value)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s", param)
+			}
+		}
+	}
+
+	return query, query.Validate()
+}
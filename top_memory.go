@@ -0,0 +1,92 @@
+package traffic
+
+import (
+	"sort"
+	"time"
+)
+
+// MemoryTopRepository ranks counters of memory monitoring repository joined with memory bans and whitelist
+type MemoryTopRepository struct {
+	monitoring *MemoryMonitoringRepository
+	ban        *MemoryBanRepository
+	whitelist  *MemoryWhitelistRepository
+}
+
+// NewMemoryTopRepository constructor
+func NewMemoryTopRepository(
+	monitoring *MemoryMonitoringRepository,
+	ban *MemoryBanRepository,
+	whitelist *MemoryWhitelistRepository,
+) *MemoryTopRepository {
+	return &MemoryTopRepository{
+		monitoring: monitoring,
+		ban:        ban,
+		whitelist:  whitelist,
+	}
+}
+
+// Top returns networks with most requests, most active first
+func (s *MemoryTopRepository) Top(query TopQuery, offencesSince time.Time) ([]TopItem, error) {
+	s.monitoring.mutex.RLock()
+	sums := s.monitoring.sumByIP(query.Since, query.Until)
+	s.monitoring.mutex.RUnlock()
+
+	items := make(map[string]*TopItem)
+	for _, sum := range sums {
+		network := query.Network(sum.IP)
+		key := network.String()
+		item, ok := items[key]
+		if !ok {
+			item = &TopItem{IP: network}
+			items[key] = item
+		}
+		item.Count += sum.Count
+	}
+
+	ranked := make([]TopItem, 0, len(items))
+	for _, item := range items {
+		ranked = append(ranked, *item)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return compareNetworks(ranked[i].IP, ranked[j].IP) < 0
+	})
+
+	result := make([]TopItem, 0, query.Limit)
+	for _, item := range ranked {
+		if len(result) >= query.Limit {
+			break
+		}
+
+		var err error
+		item.InWhitelist, err = s.whitelist.Exists(item.IP)
+		if err != nil {
+			return nil, err
+		}
+		if item.InWhitelist && query.HideWhitelisted {
+			continue
+		}
+
+		item.Ban, err = s.ban.Get(item.IP)
+		if err != nil {
+			return nil, err
+		}
+		if item.Ban != nil && query.HideBanned {
+			continue
+		}
+
+		if item.Ban != nil {
+			item.Ban.Offences, err = s.ban.Offences(item.Ban.IP, offencesSince)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, item)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,88 @@
+package traffic
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PostgresTopRepository ranks ip_monitoring counters joined with ip_ban and ip_whitelist
+type PostgresTopRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresTopRepository constructor
+func NewPostgresTopRepository(db *pgxpool.Pool) *PostgresTopRepository {
+	return &PostgresTopRepository{
+		db: db,
+	}
+}
+
+// Top returns networks with most requests, most active first.
+// Ban and whitelist state of all networks is fetched with the same query
+func (s *PostgresTopRepository) Top(query TopQuery, offencesSince time.Time) ([]TopItem, error) {
+	ipv4Prefix, ipv6Prefix := query.Prefixes()
+
+	rows, err := s.db.Query(context.Background(), `
+		WITH top AS (
+			SELECT network(set_masklen(ip, CASE WHEN family(ip) = 4 THEN $3::int ELSE $4::int END))::inet AS net,
+				SUM(count) AS c
+			FROM ip_monitoring
+			WHERE minute_at >= $1::timestamptz AND minute_at < $2::timestamptz
+			GROUP BY net
+		)
+		SELECT top.net, top.c, w.found IS NOT NULL, b.ip, b.until, b.reason, b.by_user_id,
+			(SELECT COUNT(1) FROM ip_ban_history h WHERE h.ip = b.ip AND h.created_at > $7)
+		FROM top
+			LEFT JOIN LATERAL (
+				SELECT true AS found
+				FROM ip_whitelist
+				WHERE ip >>= top.net AND (expires_at IS NULL OR expires_at > NOW())
+				LIMIT 1
+			) w ON true
+			LEFT JOIN LATERAL (
+				SELECT ip, until, reason, by_user_id
+				FROM ip_ban
+				WHERE ip >>= top.net AND until >= NOW()
+				ORDER BY masklen(ip) DESC
+				LIMIT 1
+			) b ON true
+		WHERE (NOT $5 OR w.found IS NULL) AND (NOT $6 OR b.ip IS NULL)
+		ORDER BY top.c DESC, top.net
+		LIMIT $8
+	`, query.Since, query.Until, ipv4Prefix, ipv6Prefix, query.HideWhitelisted, query.HideBanned, offencesSince, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]TopItem, 0)
+	for rows.Next() {
+		var item TopItem
+		var banIP pgtype.Inet
+		var until *time.Time
+		var reason *string
+		var byUserID *int
+		var offences int
+		err := rows.Scan(&item.IP.IPNet, &item.Count, &item.InWhitelist, &banIP, &until, &reason, &byUserID, &offences)
+		if err != nil {
+			return nil, err
+		}
+
+		if banIP.Status == pgtype.Present && until != nil {
+			item.Ban = &BanItem{IP: Network{*banIP.IPNet}, Until: *until, Offences: offences}
+			if reason != nil {
+				item.Ban.Reason = *reason
+			}
+			if byUserID != nil {
+				item.Ban.ByUserID = *byUserID
+			}
+		}
+
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,101 @@
+package traffic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func topIPs(items []TopItem) []string {
+	result := make([]string, len(items))
+	for idx, item := range items {
+		result[idx] = item.IP.String()
+	}
+
+	return result
+}
+
+func TestTopQueryValidate(t *testing.T) {
+	query := NewTopQuery()
+	require.NoError(t, query.Validate())
+
+	query.Limit = MaxTopLimit + 1
+	require.Error(t, query.Validate())
+
+	query = NewTopQuery()
+	query.Until = query.Since
+	require.Error(t, query.Validate())
+
+	query = NewTopQuery()
+	query.IPv4Prefix = 33
+	require.Error(t, query.Validate())
+
+	query = NewTopQuery()
+	query.IPv6Prefix = -1
+	require.Error(t, query.Validate())
+}
+
+func TestMemoryTopQuery(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	now := time.Now().Truncate(time.Minute)
+	require.NoError(t, s.Monitoring.AddBatch([]MonitoringBucket{
+		{IP: net.IPv4(192, 0, 2, 1), Minute: now, Count: 5},
+		{IP: net.IPv4(192, 0, 2, 2), Minute: now, Count: 4},
+		{IP: net.IPv4(198, 51, 100, 1), Minute: now, Count: 7},
+		{IP: net.ParseIP("2001:db8::1"), Minute: now, Count: 3},
+		{IP: net.ParseIP("2001:db8::2"), Minute: now.Add(-2 * time.Hour), Count: 100},
+	}))
+
+	require.NoError(t, s.Whitelist.Add(net.IPv4(198, 51, 100, 1), "Test"))
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "192.0.2.0/24"), time.Hour, 1, "Test"))
+
+	query := NewTopQuery()
+	query.Since = now.Add(-time.Hour)
+	query.Until = now.Add(time.Minute)
+
+	items, err := s.Top(query)
+	require.NoError(t, err)
+	require.Equal(t, []string{"198.51.100.1", "192.0.2.1", "192.0.2.2", "2001:db8::1"}, topIPs(items))
+	require.True(t, items[0].InWhitelist)
+	require.Nil(t, items[0].Ban)
+	require.False(t, items[1].InWhitelist)
+	require.NotNil(t, items[1].Ban)
+	require.Equal(t, "192.0.2.0/24", items[1].Ban.IP.String())
+	require.Equal(t, 1, items[1].Ban.Offences)
+
+	query.Limit = 2
+	items, err = s.Top(query)
+	require.NoError(t, err)
+	require.Equal(t, []string{"198.51.100.1", "192.0.2.1"}, topIPs(items))
+
+	query.HideWhitelisted = true
+	items, err = s.Top(query)
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, topIPs(items))
+
+	query.HideBanned = true
+	items, err = s.Top(query)
+	require.NoError(t, err)
+	require.Equal(t, []string{"2001:db8::1"}, topIPs(items))
+
+	query = NewTopQuery()
+	query.Since = now.Add(-time.Hour)
+	query.Until = now.Add(time.Minute)
+	query.IPv4Prefix = 24
+	query.IPv6Prefix = 64
+	items, err = s.Top(query)
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.0.2.0/24", "198.51.100.0/24", "2001:db8::/64"}, topIPs(items))
+	require.Equal(t, 9, items[0].Count)
+	require.NotNil(t, items[0].Ban)
+	require.False(t, items[1].InWhitelist)
+
+	query.Since = now.Add(-3 * time.Hour)
+	items, err = s.Top(query)
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::/64", items[0].IP.String())
+	require.Equal(t, 103, items[0].Count)
+}
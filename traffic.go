@@ -1,64 +1,130 @@
 package traffic
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"github.com/autowp/traffic/util"
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"io"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const banByUserID = 9
 
+// detectionQueueSize limits number of detections waiting for ban
+const detectionQueueSize = 1000
+
+// maxImportSize limits size of uploaded range or block list
+const maxImportSize = 32 << 20
+
 // Traffic Traffic
 type Traffic struct {
-	Monitoring *Monitoring
-	Whitelist  *Whitelist
-	Ban        *Ban
-	logger     *util.Logger
+	Monitoring      *Monitoring
+	Whitelist       *Whitelist
+	Ban             *Ban
+	Detector        *Detector
+	detections      chan detection
+	DNSCache        *CachingResolver
+	Snapshot        *Snapshot
+	top             TopRepository
+	logger          *util.Logger
+	autobanProfiles []AutobanProfile
+	banEscalation   BanEscalationConfig
+	dnsWorkers      int
 }
 
 // AutobanProfile AutobanProfile
 type AutobanProfile struct {
-	Limit  int
-	Reason string
-	Group  []string
-	Time   time.Duration
-}
-
-// AutobanProfiles AutobanProfiles
-var AutobanProfiles = []AutobanProfile{
-	{
-		Limit:  10000,
-		Reason: "daily limit",
-		Group:  []string{},
-		Time:   time.Hour * 10 * 24,
-	},
-	{
-		Limit:  3600,
-		Reason: "hourly limit",
-		Group:  []string{"hour"},
-		Time:   time.Hour * 5 * 24,
-	},
-	{
-		Limit:  1200,
-		Reason: "ten min limit",
-		Group:  []string{"hour", "tenminute"},
-		Time:   time.Hour * 24,
-	},
-	{
-		Limit:  700,
-		Reason: "min limit",
-		Group:  []string{"hour", "tenminute", "minute"},
-		Time:   time.Hour * 12,
-	},
+	Limit  int    `yaml:"limit"  mapstructure:"limit"`
+	Reason string `yaml:"reason" mapstructure:"reason"`
+	// Window rolling period in which more than Limit requests leads to ban
+	Window  time.Duration `yaml:"window"  mapstructure:"window"`
+	Time    time.Duration `yaml:"time"    mapstructure:"time"`
+	Enabled bool          `yaml:"enabled" mapstructure:"enabled"`
+	// IPv4Prefix and IPv6Prefix aggregate requests by subnet of given prefix length and ban whole subnet.
+	// Zero means single IP
+	IPv4Prefix int `yaml:"ipv4_prefix" mapstructure:"ipv4_prefix"`
+	IPv6Prefix int `yaml:"ipv6_prefix" mapstructure:"ipv6_prefix"`
+}
+
+// Validate checks that profile can be evaluated
+func (p AutobanProfile) Validate() error {
+	if p.Limit <= 0 {
+		return fmt.Errorf("limit must be positive")
+	}
+
+	if strings.TrimSpace(p.Reason) == "" {
+		return fmt.Errorf("reason not provided")
+	}
+
+	if p.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+
+	if p.Time <= 0 {
+		return fmt.Errorf("time must be positive")
+	}
+
+	if p.IPv4Prefix < 0 || p.IPv4Prefix > net.IPv4len*8 {
+		return fmt.Errorf("ipv4_prefix must be in range 0..%d", net.IPv4len*8)
+	}
+
+	if p.IPv6Prefix < 0 || p.IPv6Prefix > net.IPv6len*8 {
+		return fmt.Errorf("ipv6_prefix must be in range 0..%d", net.IPv6len*8)
+	}
+
+	return nil
+}
+
+// Prefixes effective prefix lengths of IPv4 and IPv6 networks which profile aggregates
+func (p AutobanProfile) Prefixes() (int, int) {
+	return aggregatePrefixes(p.IPv4Prefix, p.IPv6Prefix)
+}
+
+// Network which IP is aggregated into by profile
+func (p AutobanProfile) Network(ip net.IP) Network {
+	return aggregateNetwork(ip, p.IPv4Prefix, p.IPv6Prefix)
+}
+
+// aggregatePrefixes effective prefix lengths, zero means single IP
+func aggregatePrefixes(ipv4Prefix int, ipv6Prefix int) (int, int) {
+	if ipv4Prefix <= 0 {
+		ipv4Prefix = net.IPv4len * 8
+	}
+
+	if ipv6Prefix <= 0 {
+		ipv6Prefix = net.IPv6len * 8
+	}
+
+	return ipv4Prefix, ipv6Prefix
+}
+
+// aggregateNetwork subnet of IP of prefix length of its family
+func aggregateNetwork(ip net.IP, ipv4Prefix int, ipv6Prefix int) Network {
+	ip = normalizeIP(ip)
+	ipv4Prefix, ipv6Prefix = aggregatePrefixes(ipv4Prefix, ipv6Prefix)
+
+	bits := len(ip) * 8
+	ones := ipv4Prefix
+	if len(ip) == net.IPv6len {
+		ones = ipv6Prefix
+	}
+
+	mask := net.CIDRMask(ones, bits)
+
+	return Network{net.IPNet{IP: ip.Mask(mask), Mask: mask}}
 }
 
 // BanPOSTRequest BanPOSTRequest
 type BanPOSTRequest struct {
-	IP       net.IP        `json:"ip"`
+	IP       Network       `json:"ip"`
 	Duration time.Duration `json:"duration"`
 	ByUserID int           `json:"by_user_id"`
 	Reason   string        `json:"reason"`
@@ -66,68 +132,235 @@ type BanPOSTRequest struct {
 
 // WhitelistPOSTRequest WhitelistPOSTRequest
 type WhitelistPOSTRequest struct {
-	IP          net.IP `json:"ip"`
-	Description string `json:"description"`
+	IP          Network `json:"ip"`
+	Description string  `json:"description"`
+	// Duration after which item expires, zero means never
+	Duration time.Duration `json:"duration"`
 }
 
 // TopItem TopItem
 type TopItem struct {
-	IP          net.IP   `json:"ip"`
+	// IP single IP or subnet when grouped by prefix
+	IP          Network  `json:"ip"`
 	Count       int      `json:"count"`
 	Ban         *BanItem `json:"ban"`
 	InWhitelist bool     `json:"in_whitelist"`
 }
 
 // NewTraffic constructor
-func NewTraffic(pool *pgxpool.Pool, logger *util.Logger) (*Traffic, error) {
+func NewTraffic(repositories Repositories, logger *util.Logger, config Config) (*Traffic, error) {
+
+	ban, err := NewBan(repositories.Ban, logger)
+	if err != nil {
+		logger.Fatal(err)
+		return nil, err
+	}
 
-	ban, err := NewBan(pool, logger)
+	monitoring, err := NewMonitoring(repositories.Monitoring, logger)
 	if err != nil {
 		logger.Fatal(err)
 		return nil, err
 	}
 
-	monitoring, err := NewMonitoring(pool, logger)
+	whitelist, err := NewWhitelist(repositories.Whitelist)
 	if err != nil {
 		logger.Fatal(err)
 		return nil, err
 	}
+	whitelist.SetCrawlerRules(config.Crawlers)
+	whitelist.SetAutodetectTTL(config.Whitelist.AutodetectTTL)
 
-	whitelist, err := NewWhitelist(pool)
+	snapshot, err := NewSnapshot(repositories.Ban, repositories.Whitelist, logger)
 	if err != nil {
 		logger.Fatal(err)
 		return nil, err
 	}
 
+	if repositories.Top == nil {
+		err = fmt.Errorf("top repository is nil")
+		logger.Fatal(err)
+		return nil, err
+	}
+
 	s := &Traffic{
-		Monitoring: monitoring,
-		Whitelist:  whitelist,
-		Ban:        ban,
-		logger:     logger,
+		Monitoring:      monitoring,
+		Whitelist:       whitelist,
+		Ban:             ban,
+		Snapshot:        snapshot,
+		top:             repositories.Top,
+		logger:          logger,
+		autobanProfiles: config.AutobanProfiles,
+		banEscalation:   config.BanEscalation,
+		dnsWorkers:      config.DNS.Workers,
+	}
+
+	var resolver Resolver = NewDNSClient(config.DNS.Servers)
+	if config.DNS.CacheTTL > 0 {
+		s.DNSCache = NewCachingResolver(resolver, config.DNS.CacheTTL, config.DNS.NegativeTTL)
+		resolver = s.DNSCache
+	}
+	whitelist.SetResolver(resolver)
+
+	ban.SetOffenceCleanPeriod(config.BanEscalation.CleanPeriod)
+	ban.SetMinPrefixes(config.Ban.MinIPv4Prefix, config.Ban.MinIPv6Prefix)
+
+	retention := time.Duration(0)
+	for _, profile := range config.AutobanProfiles {
+		if profile.Enabled && profile.Window > retention {
+			retention = profile.Window
+		}
+	}
+	monitoring.SetRetention(retention)
+
+	if config.Monitoring.RealtimeDetection {
+		// counters of long windows are kept by scheduled autoban only
+		profiles := make([]AutobanProfile, 0, len(config.AutobanProfiles))
+		for _, profile := range config.AutobanProfiles {
+			if profile.Window <= config.Monitoring.RealtimeMaxWindow {
+				profiles = append(profiles, profile)
+			}
+		}
+
+		s.Detector = NewDetector(profiles)
+		s.Detector.SetMaxNetworks(config.Monitoring.RealtimeMaxNetworks)
+		s.detections = make(chan detection, detectionQueueSize)
+		monitoring.AddObserver(s.detect)
 	}
 
 	return s, nil
 }
 
-func (s *Traffic) AutoBanByProfile(profile AutobanProfile) error {
+// detection network which exceeded profile and waits for ban
+type detection struct {
+	network Network
+	profile AutobanProfile
+}
 
-	ips, err := s.Monitoring.ListByBanProfile(profile)
+// detect queues ban of IP as soon as request exceeds one of profiles.
+// Bans involve DNS lookups, so they are made by RunDetections instead of listener.
+// Detection is dropped when queue is full, scheduled AutoBan still catches the network
+func (s *Traffic) detect(message MonitoringInputMessage) {
+	for _, profile := range s.Detector.Hit(message.IP, message.Timestamp) {
+		item := detection{network: profile.Network(message.IP), profile: profile}
+		select {
+		case s.detections <- item:
+		default:
+			s.logger.Warningf("detection queue is full, %v of `%s` dropped", item.network, profile.Reason)
+		}
+	}
+}
+
+// RunDetections bans networks queued by realtime detection with concurrent workers until quit
+func (s *Traffic) RunDetections(quit chan bool) {
+	if s.detections == nil {
+		return
+	}
+
+	workers := s.dnsWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case item := <-s.detections:
+					err := s.banByProfile(item.network, item.profile)
+					if err != nil {
+						s.logger.Warning(err)
+					}
+				case <-quit:
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// banByProfile bans network unless it is whitelisted or banned already.
+// Ban time is escalated for repeat offenders
+func (s *Traffic) banByProfile(network Network, profile AutobanProfile) error {
+	exists, err := s.Whitelist.ExistsNetwork(network)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	exists, err = s.Ban.ExistsNetwork(network)
 	if err != nil {
 		return err
 	}
+	if exists {
+		return nil
+	}
 
-	for _, ip := range ips {
-		exists, err := s.Whitelist.Exists(ip)
+	// verified crawler is whitelisted instead of ban
+	if network.IsHost() {
+		match, err := s.Whitelist.Verify(network.IP)
 		if err != nil {
-			return err
+			s.logger.Warning(err)
 		}
-		if exists {
-			continue
+		if match != nil {
+			fmt.Printf("%v %s, whitelisted instead of ban\n", network, match.Description)
+			return s.whitelistCrawler(network.IP, match)
 		}
+	}
+
+	offences, err := s.Ban.OffencesNetwork(network)
+	if err != nil {
+		return err
+	}
 
-		fmt.Printf("%s %v\n", profile.Reason, ip)
+	duration := escalateBanTime(profile.Time, offences, s.banEscalation)
 
-		if err := s.Ban.Add(ip, profile.Time, banByUserID, profile.Reason); err != nil {
+	reason := profile.Reason
+	if !network.IsHost() {
+		reason = fmt.Sprintf("%s (subnet %v)", profile.Reason, network)
+	}
+
+	fmt.Printf("%s %v, offence %d, %v\n", profile.Reason, network, offences+1, duration)
+
+	return s.Ban.AddNetwork(network, duration, banByUserID, reason)
+}
+
+// escalateBanTime multiplies base time by factor for each previous offence up to config.MaxTime
+func escalateBanTime(base time.Duration, offences int, config BanEscalationConfig) time.Duration {
+	limit := time.Duration(math.MaxInt64)
+	if config.MaxTime > 0 {
+		limit = config.MaxTime
+	}
+	if limit < base {
+		limit = base
+	}
+
+	duration := float64(base)
+	for i := 0; i < offences && config.Factor > 1; i++ {
+		duration *= config.Factor
+		if duration >= float64(limit) {
+			return limit
+		}
+	}
+
+	return time.Duration(duration)
+}
+
+func (s *Traffic) AutoBanByProfile(profile AutobanProfile) error {
+
+	networks, err := s.Monitoring.ListByBanProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	for _, network := range networks {
+		if err := s.banByProfile(network, profile); err != nil {
 			return err
 		}
 	}
@@ -136,7 +369,11 @@ func (s *Traffic) AutoBanByProfile(profile AutobanProfile) error {
 }
 
 func (s *Traffic) AutoBan() error {
-	for _, profile := range AutobanProfiles {
+	for _, profile := range s.autobanProfiles {
+		if !profile.Enabled {
+			continue
+		}
+
 		if err := s.AutoBanByProfile(profile); err != nil {
 			return err
 		}
@@ -145,6 +382,62 @@ func (s *Traffic) AutoBan() error {
 	return nil
 }
 
+type crawlerVerification struct {
+	ip    net.IP
+	match *CrawlerMatch
+	err   error
+}
+
+// verifyCrawlers verifies IPs concurrently by pool of workers and passes results to callback one by one.
+// First error of callback is returned
+func (s *Traffic) verifyCrawlers(ips []net.IP, callback func(result crawlerVerification) error) error {
+	workers := s.dnsWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	queue := make(chan net.IP)
+	results := make(chan crawlerVerification)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range queue {
+				match, err := s.Whitelist.Verify(ip)
+				results <- crawlerVerification{ip: ip, match: match, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, ip := range ips {
+			queue <- ip
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	// results are drained even after error, so workers are not blocked
+	var firstErr error
+	for result := range results {
+		if firstErr != nil {
+			continue
+		}
+		firstErr = callback(result)
+	}
+
+	if s.DNSCache != nil {
+		stats := s.DNSCache.Stats()
+		fmt.Printf("DNS cache: %d hits, %d misses, %d entries\n", stats.Hits, stats.Misses, stats.Entries)
+	}
+
+	return firstErr
+}
+
+// AutoWhitelist whitelists verified crawlers among top IPs
 func (s *Traffic) AutoWhitelist() error {
 
 	items, err := s.Monitoring.ListOfTop(1000)
@@ -152,63 +445,247 @@ func (s *Traffic) AutoWhitelist() error {
 		return err
 	}
 
-	for _, item := range items {
-		fmt.Printf("Check IP %v\n", item.IP)
-		if err := s.AutoWhitelistIP(item.IP); err != nil {
-			return err
-		}
+	ips := make([]net.IP, len(items))
+	for idx, item := range items {
+		ips[idx] = item.IP
 	}
 
-	return nil
+	return s.verifyCrawlers(ips, s.applyCrawlerVerification)
 }
 
+// AutoWhitelistIP whitelists IP if it is verified crawler
 func (s *Traffic) AutoWhitelistIP(ip net.IP) error {
-	ipText := ip.String()
+	match, err := s.Whitelist.Verify(ip)
+
+	return s.applyCrawlerVerification(crawlerVerification{ip: ip, match: match, err: err})
+}
 
-	fmt.Print(ipText + ": ")
+func (s *Traffic) applyCrawlerVerification(result crawlerVerification) error {
+	if result.err != nil {
+		fmt.Printf("%v: %v\n", result.ip, result.err)
+		return nil
+	}
 
-	inWhitelist, err := s.Whitelist.Exists(ip)
+	if result.match == nil {
+		fmt.Printf("%v: not a crawler\n", result.ip)
+		return nil
+	}
+
+	inWhitelist, err := s.Whitelist.Exists(result.ip)
 	if err != nil {
 		return err
 	}
 
-	match, desc := s.Whitelist.MatchAuto(ip)
+	if inWhitelist {
+		fmt.Printf("%v: %s, whitelist, skip\n", result.ip, result.match.Description)
+		return s.unbanIP(result.ip)
+	}
 
-	if !match {
-		fmt.Println("")
-		return nil
+	if err := s.whitelistCrawler(result.ip, result.match); err != nil {
+		return err
 	}
 
-	if inWhitelist {
-		fmt.Println("whitelist, skip")
-	} else {
-		if err := s.Whitelist.Add(ip, desc); err != nil {
-			return err
+	fmt.Printf("%v: %s, whitelisted\n", result.ip, result.match.Description)
+
+	return nil
+}
+
+// ReverifyWhitelist verifies autodetected whitelist items again and removes ones which are not crawlers anymore.
+// Items which verification failed because of DNS errors are kept till expiry
+func (s *Traffic) ReverifyWhitelist() (int, int, error) {
+	items, err := s.Whitelist.List()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ips := make([]net.IP, 0)
+	for _, item := range items {
+		if item.IsAutodetected() && item.IP.IsHost() {
+			ips = append(ips, item.IP.IP)
 		}
 	}
 
-	if err := s.Ban.Remove(ip); err != nil {
+	verified := 0
+	removed := 0
+	err = s.verifyCrawlers(ips, func(result crawlerVerification) error {
+		if result.err != nil {
+			fmt.Printf("%v: %v, kept\n", result.ip, result.err)
+			return nil
+		}
+
+		if result.match == nil {
+			fmt.Printf("%v: not a crawler anymore, removed\n", result.ip)
+			removed++
+			return s.Whitelist.Remove(result.ip)
+		}
+
+		verified++
+		return s.Whitelist.AddAutodetected(result.ip, result.match)
+	})
+
+	return verified, removed, err
+}
+
+// whitelistCrawler adds verified crawler to whitelist and lifts its ban
+func (s *Traffic) whitelistCrawler(ip net.IP, match *CrawlerMatch) error {
+	if err := s.Whitelist.AddAutodetected(ip, match); err != nil {
 		return err
 	}
 
-	if err := s.Monitoring.ClearIP(ip); err != nil {
+	return s.unbanIP(ip)
+}
+
+// unbanIP removes ban of IP and forgets its requests
+func (s *Traffic) unbanIP(ip net.IP) error {
+	if err := s.Ban.Remove(ip); err != nil {
 		return err
 	}
 
-	fmt.Println(" whitelisted")
+	return s.Monitoring.ClearIP(ip)
+}
 
-	return nil
+// Check returns ban which denies access of IP, nil when IP is allowed. Whitelist takes precedence over bans.
+// In-memory snapshot answers once it is loaded, repositories are queried before that
+func (s *Traffic) Check(ip net.IP) (*BanItem, error) {
+	if s.Snapshot.Loaded() {
+		return s.Snapshot.Check(ip), nil
+	}
+
+	whitelisted, err := s.Whitelist.Exists(ip)
+	if err != nil || whitelisted {
+		return nil, err
+	}
+
+	return s.Ban.Get(ip)
+}
+
+// retryAfter seconds till ban ends, at least one
+func retryAfter(until time.Time) int {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+
+	return seconds
+}
+
+// listParams parses sort, cursor and limit of listing request
+func listParams(c *gin.Context, sorts []string) (ListSort, *ListCursor, int, error) {
+	sort, err := ParseListSort(c.Query("sort"), sorts...)
+	if err != nil {
+		return ListSort{}, nil, 0, err
+	}
+
+	cursor, err := ParseListCursor(c.Query("cursor"))
+	if err != nil {
+		return ListSort{}, nil, 0, err
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		return ListSort{}, nil, 0, fmt.Errorf("invalid limit")
+	}
+
+	return sort, cursor, limit, nil
+}
+
+// hasQueryParams reports that request has any of given query parameters
+func hasQueryParams(c *gin.Context, names ...string) bool {
+	for _, name := range names {
+		if _, ok := c.GetQuery(name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// banListQuery parses filter of bans listing request
+func banListQuery(c *gin.Context) (BanListQuery, error) {
+	query := BanListQuery{
+		Reason: c.Query("reason"),
+	}
+
+	var err error
+	query.Sort, query.After, query.Limit, err = listParams(c, BanSorts)
+	if err != nil {
+		return query, err
+	}
+
+	if value := c.Query("by_user_id"); len(value) > 0 {
+		byUserID, err := strconv.Atoi(value)
+		if err != nil {
+			return query, fmt.Errorf("invalid by_user_id")
+		}
+		query.ByUserID = &byUserID
+	}
+
+	if value := c.Query("expires_after"); len(value) > 0 {
+		query.ExpiresAfter, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("invalid expires_after, RFC 3339 expected")
+		}
+	}
+
+	if value := c.Query("expires_before"); len(value) > 0 {
+		query.ExpiresBefore, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("invalid expires_before, RFC 3339 expected")
+		}
+	}
+
+	if value := c.Query("contains"); len(value) > 0 {
+		network, err := ParseNetwork(value)
+		if err != nil {
+			return query, err
+		}
+		query.Contains = &network
+	}
+
+	if value := c.Query("within"); len(value) > 0 {
+		network, err := ParseNetwork(value)
+		if err != nil {
+			return query, err
+		}
+		query.Within = &network
+	}
+
+	return query, nil
 }
 
 func (s *Traffic) SetupRouter(r *gin.Engine) {
 	r.GET("/whitelist", func(c *gin.Context) {
-		list, err := s.Whitelist.List()
+		// request without listing parameters gets whole whitelist as plain array, as before paging was introduced
+		if !hasQueryParams(c, "q", "source", "sort", "cursor", "limit") {
+			list, err := s.Whitelist.List()
+			if err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			c.JSON(http.StatusOK, list)
+			return
+		}
+
+		query := WhitelistListQuery{
+			Description: c.Query("q"),
+			Source:      c.Query("source"),
+		}
+
+		var err error
+		query.Sort, query.After, query.Limit, err = listParams(c, WhitelistSorts)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		page, err := s.Whitelist.Find(query)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		c.JSON(http.StatusOK, list)
+		c.JSON(http.StatusOK, page)
 	})
 
 	r.POST("/whitelist", func(c *gin.Context) {
@@ -221,13 +698,28 @@ func (s *Traffic) SetupRouter(r *gin.Engine) {
 			return
 		}
 
-		err = s.Whitelist.Add(request.IP, request.Description)
+		if request.IP.IP == nil {
+			c.String(http.StatusBadRequest, "Invalid IP")
+			return
+		}
+
+		item := WhitelistItem{
+			IP:          request.IP,
+			Description: request.Description,
+			Source:      WhitelistSourceManual,
+		}
+		if request.Duration > 0 {
+			expiresAt := time.Now().Add(request.Duration)
+			item.ExpiresAt = &expiresAt
+		}
+
+		err = s.Whitelist.AddItem(item)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		err = s.Ban.Remove(request.IP)
+		err = s.Ban.RemoveNetwork(request.IP)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
@@ -238,14 +730,49 @@ func (s *Traffic) SetupRouter(r *gin.Engine) {
 		c.Status(http.StatusCreated)
 	})
 
-	r.GET("/whitelist/:ip", func(c *gin.Context) {
-		ip := net.ParseIP(c.Param("ip"))
-		if ip == nil {
+	r.POST("/whitelist/import/:name", func(c *gin.Context) {
+		body := io.Reader(c.Request.Body)
+
+		if strings.HasPrefix(c.ContentType(), "multipart/") {
+			fileHeader, err := c.FormFile("file")
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+
+			file, err := fileHeader.Open()
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			defer util.Close(file)
+
+			body = file
+		}
+
+		networks, err := ParseIPRanges(io.LimitReader(body, maxImportSize))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := s.ImportWhitelist(c.Param("name"), networks)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	getWhitelist := func(c *gin.Context) {
+		network, err := networkParam(c)
+		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid IP")
 			return
 		}
 
-		item, err := s.Whitelist.Get(ip)
+		item, err := s.Whitelist.GetNetwork(network)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
@@ -257,59 +784,157 @@ func (s *Traffic) SetupRouter(r *gin.Engine) {
 		}
 
 		c.JSON(http.StatusOK, item)
-	})
+	}
+	r.GET("/whitelist/:ip", getWhitelist)
+	r.GET("/whitelist/:ip/:mask", getWhitelist)
 
-	r.DELETE("/whitelist/:ip", func(c *gin.Context) {
-		ip := net.ParseIP(c.Param("ip"))
-		if ip == nil {
+	deleteWhitelist := func(c *gin.Context) {
+		network, err := networkParam(c)
+		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid IP")
 			return
 		}
 
-		err := s.Whitelist.Remove(ip)
+		err = s.Whitelist.RemoveNetwork(network)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
 		c.Status(http.StatusNoContent)
+	}
+	r.DELETE("/whitelist/:ip", deleteWhitelist)
+	r.DELETE("/whitelist/:ip/:mask", deleteWhitelist)
+
+	// check answers nginx auth_request subrequest: 204 when IP is allowed, 403 when it is banned.
+	// IP is taken from path, `ip` query parameter or X-Real-IP header
+	check := func(c *gin.Context) {
+		value := c.Param("ip")
+		if value == "" {
+			value = c.Query("ip")
+		}
+		if value == "" {
+			value = c.GetHeader("X-Real-IP")
+		}
+
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			c.String(http.StatusBadRequest, "Invalid IP")
+			return
+		}
+
+		ban, err := s.Check(ip)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if ban == nil {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		c.Header("Retry-After", strconv.Itoa(retryAfter(ban.Until)))
+		c.Status(http.StatusForbidden)
+	}
+	r.GET("/check", check)
+	r.GET("/check/:ip", check)
+
+	r.GET("/sync", func(c *gin.Context) {
+		state, err := s.SyncState()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, state)
 	})
 
-	r.GET("/top", func(c *gin.Context) {
+	r.GET("/sync/changes", func(c *gin.Context) {
+		banCursor, err := strconv.ParseInt(c.DefaultQuery("ban_cursor", "0"), 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid ban_cursor")
+			return
+		}
+
+		whitelistCursor, err := strconv.ParseInt(c.DefaultQuery("whitelist_cursor", "0"), 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid whitelist_cursor")
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid limit")
+			return
+		}
 
-		items, err := s.Monitoring.ListOfTop(50)
+		changes, err := s.SyncChanges(banCursor, whitelistCursor, limit)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, changes)
+	})
+
+	r.GET("/ip/:ip", func(c *gin.Context) {
+		ip := net.ParseIP(c.Param("ip"))
+		if ip == nil {
+			c.String(http.StatusBadRequest, "Invalid IP")
+			return
+		}
 
+		dossier, err := s.Dossier(ip)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		result := make([]TopItem, len(items))
-		for idx, item := range items {
+		c.JSON(http.StatusOK, dossier)
+	})
 
-			ban, err := s.Ban.Get(item.IP)
-			if err != nil {
-				c.String(http.StatusInternalServerError, err.Error())
-				return
-			}
+	r.GET("/dns/stats", func(c *gin.Context) {
+		stats := DNSCacheStats{}
+		if s.DNSCache != nil {
+			stats = s.DNSCache.Stats()
+		}
 
-			inWhitelist, err := s.Whitelist.Exists(item.IP)
-			if err != nil {
-				c.String(http.StatusInternalServerError, err.Error())
-				return
-			}
+		c.JSON(http.StatusOK, stats)
+	})
 
-			result[idx] = TopItem{
-				IP:          item.IP,
-				Count:       item.Count,
-				Ban:         ban,
-				InWhitelist: inWhitelist,
-			}
+	r.GET("/top", func(c *gin.Context) {
+		query, err := topQuery(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := s.Top(query)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
 		}
 
 		c.JSON(http.StatusOK, result)
 	})
 
+	r.GET("/ban", func(c *gin.Context) {
+		query, err := banListQuery(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		page, err := s.Ban.Find(query)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	})
+
 	r.POST("/ban", func(c *gin.Context) {
 
 		request := BanPOSTRequest{}
@@ -321,7 +946,17 @@ func (s *Traffic) SetupRouter(r *gin.Engine) {
 			return
 		}
 
-		err = s.Ban.Add(request.IP, request.Duration, request.ByUserID, request.Reason)
+		if request.IP.IP == nil {
+			c.String(http.StatusBadRequest, "Invalid IP")
+			return
+		}
+
+		if err := s.Ban.CheckNetwork(request.IP); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err = s.Ban.AddNetwork(request.IP, request.Duration, request.ByUserID, request.Reason)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
@@ -332,30 +967,84 @@ func (s *Traffic) SetupRouter(r *gin.Engine) {
 		c.Status(http.StatusCreated)
 	})
 
-	r.DELETE("/ban/:ip", func(c *gin.Context) {
-		ip := net.ParseIP(c.Param("ip"))
-		if ip == nil {
+	deleteBan := func(c *gin.Context) {
+		network, err := networkParam(c)
+		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid IP")
 			return
 		}
 
-		err := s.Ban.Remove(ip)
+		err = s.Ban.RemoveNetwork(network)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
 		c.Status(http.StatusNoContent)
-	})
+	}
+	r.DELETE("/ban/:ip", deleteBan)
+	r.DELETE("/ban/:ip/:mask", deleteBan)
+
+	// exportBans renders active bans minus whitelist, GET /ban/export shares route tree node with GET /ban/:ip
+	exportBans := func(c *gin.Context) {
+		format := c.DefaultQuery("format", ExportFormatPlain)
+		if !ValidExportFormat(format) {
+			c.String(http.StatusBadRequest, "Unsupported format. Supported: "+strings.Join(ExportFormats, ", "))
+			return
+		}
 
-	r.GET("/ban/:ip", func(c *gin.Context) {
-		ip := net.ParseIP(c.Param("ip"))
-		if ip == nil {
+		name := c.Query("name")
+		if !ValidExportName(name) {
+			c.String(http.StatusBadRequest, "Invalid name")
+			return
+		}
+
+		maxElem := 0
+		if value := c.Query("maxelem"); len(value) > 0 {
+			var err error
+			maxElem, err = strconv.Atoi(value)
+			if err != nil || maxElem <= 0 {
+				c.String(http.StatusBadRequest, "Invalid maxelem")
+				return
+			}
+		}
+
+		networks, err := s.BanExport()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var body bytes.Buffer
+		err = RenderBanExport(&body, format, name, networks, maxElem)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body.Bytes()))
+		c.Header("ETag", etag)
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", body.Bytes())
+	}
+
+	getBan := func(c *gin.Context) {
+		if c.Param("ip") == "export" && c.Param("mask") == "" {
+			exportBans(c)
+			return
+		}
+
+		network, err := networkParam(c)
+		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid IP")
 			return
 		}
 
-		ban, err := s.Ban.Get(ip)
+		ban, err := s.Ban.GetNetwork(network)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
@@ -367,5 +1056,29 @@ func (s *Traffic) SetupRouter(r *gin.Engine) {
 		}
 
 		c.JSON(http.StatusOK, ban)
-	})
+	}
+	r.GET("/ban/:ip", getBan)
+	r.GET("/ban/:ip/:mask", getBan)
+}
+
+// networkParam parses network from `/:ip` or `/:ip/:mask` route
+func networkParam(c *gin.Context) (Network, error) {
+	value := c.Param("ip")
+	if mask := c.Param("mask"); mask != "" {
+		value += "/" + mask
+	}
+
+	return ParseNetwork(value)
+}
+
+// etagMatches reports that If-None-Match header contains etag
+func etagMatches(header string, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+
+	return false
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/autowp/traffic/client"
 	"github.com/autowp/traffic/util"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -11,6 +13,8 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
@@ -25,12 +29,30 @@ func createTrafficService(t *testing.T) *Traffic {
 
 	logger := util.NewLogger(config.Sentry)
 
-	s, err := NewTraffic(pool, logger)
+	s, err := NewTraffic(NewPostgresRepositories(pool), logger, config)
 	require.NoError(t, err)
 
 	return s
 }
 
+func createMemoryTrafficService(t *testing.T) *Traffic {
+	s, _ := createMemoryTrafficServiceWithDNS(t)
+
+	return s
+}
+
+func createMemoryTrafficServiceWithDNS(t *testing.T) (*Traffic, *fakeDNS) {
+	dns := startFakeDNS(t)
+
+	config := LoadConfig()
+	config.DNS.Servers = []string{dns.Addr()}
+
+	s, err := NewTraffic(NewMemoryRepositories(), util.NewLogger(config.Sentry), config)
+	require.NoError(t, err)
+
+	return s, dns
+}
+
 func TestAutoWhitelist(t *testing.T) {
 
 	s := createTrafficService(t)
@@ -74,7 +96,7 @@ func TestAutoBanByProfile(t *testing.T) {
 	profile := AutobanProfile{
 		Limit:  3,
 		Reason: "Test",
-		Group:  []string{"hour", "tenminute", "minute"},
+		Window: time.Minute,
 		Time:   time.Hour,
 	}
 
@@ -117,7 +139,7 @@ func TestWhitelistedNotBanned(t *testing.T) {
 	profile := AutobanProfile{
 		Limit:  3,
 		Reason: "TestWhitelistedNotBanned",
-		Group:  []string{"hour", "tenminute", "minute"},
+		Window: time.Minute,
 		Time:   time.Hour,
 	}
 
@@ -212,3 +234,802 @@ func TestTop(t *testing.T) {
 	body, err := ioutil.ReadAll(w.Body)
 	require.Equal(t, `[{"ip":"::1","count":10,"ban":null,"in_whitelist":false},{"ip":"192.168.0.1","count":1,"ban":null,"in_whitelist":false}]`, string(body))
 }
+
+func TestAutobanProfileValidate(t *testing.T) {
+
+	profile := AutobanProfile{
+		Limit:  3,
+		Reason: "Test",
+		Window: time.Minute,
+		Time:   time.Hour,
+	}
+	require.NoError(t, profile.Validate())
+
+	profile.Window = 0
+	require.Error(t, profile.Validate())
+
+	profile.Window = time.Hour
+	profile.Limit = 0
+	require.Error(t, profile.Validate())
+}
+
+func TestMemoryAutoBan(t *testing.T) {
+
+	s := createMemoryTrafficService(t)
+
+	s.autobanProfiles = []AutobanProfile{
+		{Limit: 3, Reason: "Test", Window: time.Minute, Time: time.Hour, Enabled: true},
+		{Limit: 1, Reason: "Disabled", Window: 24 * time.Hour, Time: time.Hour, Enabled: false},
+	}
+
+	ip1 := net.IPv4(127, 0, 0, 1)
+	ip2 := net.IPv4(127, 0, 0, 2)
+	ip3 := net.IPv4(127, 0, 0, 3)
+
+	err := s.Whitelist.Add(ip3, "Test")
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Monitoring.Add(ip2, now))
+		require.NoError(t, s.Monitoring.Add(ip3, now))
+	}
+	require.NoError(t, s.Monitoring.Add(ip1, now))
+
+	err = s.AutoBan()
+	require.NoError(t, err)
+
+	ban, err := s.Ban.Get(ip2)
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+	require.Equal(t, "Test", ban.Reason)
+	require.Equal(t, banByUserID, ban.ByUserID)
+
+	exists, err := s.Ban.Exists(ip1)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = s.Ban.Exists(ip3)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestMemoryTop(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	now := time.Now()
+	err := s.Monitoring.Add(net.IPv4(192, 168, 0, 1), now)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = s.Monitoring.Add(net.IPv6loopback, now)
+		require.NoError(t, err)
+	}
+
+	err = s.Whitelist.Add(net.IPv4(192, 168, 0, 1), "Test")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/top", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `[{"ip":"::1","count":10,"ban":null,"in_whitelist":false},{"ip":"192.168.0.1","count":1,"ban":null,"in_whitelist":true}]`, w.Body.String())
+}
+
+func TestMemoryRealtimeDetection(t *testing.T) {
+
+	config := LoadConfig()
+	config.Monitoring.RealtimeDetection = true
+	config.AutobanProfiles = []AutobanProfile{
+		{Limit: 3, Reason: "Test", Window: time.Minute, Time: time.Hour, Enabled: true},
+		{Limit: 1, Reason: "Long", Window: 24 * time.Hour, Time: time.Hour, Enabled: true},
+	}
+
+	s, err := NewTraffic(NewMemoryRepositories(), util.NewLogger(config.Sentry), config)
+	require.NoError(t, err)
+
+	// profile of long window is left to scheduled autoban
+	require.Len(t, s.Detector.profiles, 1)
+
+	ip := net.IPv4(127, 0, 0, 2)
+	whitelisted := net.IPv4(127, 0, 0, 3)
+
+	err = s.Whitelist.Add(whitelisted, "Test")
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		s.detect(MonitoringInputMessage{IP: ip, Timestamp: now})
+		s.detect(MonitoringInputMessage{IP: whitelisted, Timestamp: now})
+	}
+
+	exists, err := s.Ban.Exists(ip)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	quit := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		s.RunDetections(quit)
+		close(done)
+	}()
+	defer func() {
+		close(quit)
+		<-done
+	}()
+
+	s.detect(MonitoringInputMessage{IP: ip, Timestamp: now})
+	s.detect(MonitoringInputMessage{IP: whitelisted, Timestamp: now})
+
+	require.Eventually(t, func() bool {
+		exists, err := s.Ban.Exists(ip)
+		require.NoError(t, err)
+		return exists
+	}, time.Second, 10*time.Millisecond)
+
+	exists, err = s.Ban.Exists(whitelisted)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestEscalateBanTime(t *testing.T) {
+
+	config := BanEscalationConfig{Factor: 2, MaxTime: 10 * time.Hour}
+
+	require.Equal(t, time.Hour, escalateBanTime(time.Hour, 0, config))
+	require.Equal(t, 2*time.Hour, escalateBanTime(time.Hour, 1, config))
+	require.Equal(t, 8*time.Hour, escalateBanTime(time.Hour, 3, config))
+	require.Equal(t, 10*time.Hour, escalateBanTime(time.Hour, 4, config))
+	require.Equal(t, 10*time.Hour, escalateBanTime(time.Hour, 1000, config))
+
+	// profile time is never reduced
+	require.Equal(t, 20*time.Hour, escalateBanTime(20*time.Hour, 3, config))
+
+	config = BanEscalationConfig{Factor: 1}
+	require.Equal(t, time.Hour, escalateBanTime(time.Hour, 5, config))
+}
+
+func TestAutobanProfileNetwork(t *testing.T) {
+
+	profile := AutobanProfile{IPv4Prefix: 24, IPv6Prefix: 64}
+	require.Equal(t, "192.168.5.0/24", profile.Network(net.IPv4(192, 168, 5, 77)).String())
+	require.Equal(t, "2001:db8:1:2::/64", profile.Network(net.ParseIP("2001:db8:1:2:3:4:5:6")).String())
+
+	profile = AutobanProfile{}
+	require.Equal(t, "192.168.5.77", profile.Network(net.IPv4(192, 168, 5, 77)).String())
+	require.Equal(t, "2001:db8:1:2:3:4:5:6", profile.Network(net.ParseIP("2001:db8:1:2:3:4:5:6")).String())
+
+	require.Error(t, AutobanProfile{Limit: 1, Reason: "Test", Window: time.Minute, Time: time.Hour, IPv4Prefix: 33}.Validate())
+	require.Error(t, AutobanProfile{Limit: 1, Reason: "Test", Window: time.Minute, Time: time.Hour, IPv6Prefix: -1}.Validate())
+}
+
+func TestMemorySubnetAutoBan(t *testing.T) {
+
+	s := createMemoryTrafficService(t)
+
+	s.autobanProfiles = []AutobanProfile{
+		{Limit: 5, Reason: "Subnet", Window: time.Minute, Time: time.Hour, Enabled: true, IPv4Prefix: 24, IPv6Prefix: 64},
+	}
+
+	now := time.Now()
+	for i := 1; i <= 6; i++ {
+		require.NoError(t, s.Monitoring.Add(net.IPv4(10, 0, 0, byte(i)), now))
+		require.NoError(t, s.Monitoring.Add(net.IPv4(10, 0, byte(i), 1), now))
+	}
+
+	err := s.AutoBan()
+	require.NoError(t, err)
+
+	ban, err := s.Ban.Get(net.IPv4(10, 0, 0, 200))
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+	require.Equal(t, "10.0.0.0/24", ban.IP.String())
+	require.Equal(t, "Subnet (subnet 10.0.0.0/24)", ban.Reason)
+
+	exists, err := s.Ban.Exists(net.IPv4(10, 0, 2, 1))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestMemoryAutoBanVerifiesCrawler(t *testing.T) {
+
+	s, dns := createMemoryTrafficServiceWithDNS(t)
+
+	s.autobanProfiles = []AutobanProfile{
+		{Limit: 3, Reason: "Test", Window: time.Minute, Time: time.Hour, Enabled: true},
+	}
+
+	google := net.IPv4(66, 249, 66, 1)
+	dns.AddPTR(google, "crawl-66-249-66-1.googlebot.com")
+	dns.AddIP("crawl-66-249-66-1.googlebot.com", google)
+
+	spoofed := net.IPv4(66, 249, 66, 2)
+	dns.AddPTR(spoofed, "crawl-66-249-66-2.googlebot.com")
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Monitoring.Add(google, now))
+		require.NoError(t, s.Monitoring.Add(spoofed, now))
+	}
+
+	err := s.AutoBan()
+	require.NoError(t, err)
+
+	exists, err := s.Ban.Exists(google)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	item, err := s.Whitelist.Get(google)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	require.Equal(t, "googlebot autodetect", item.Description)
+
+	exists, err = s.Ban.Exists(spoofed)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = s.Whitelist.Exists(spoofed)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestMemoryAutoWhitelist(t *testing.T) {
+
+	s, dns := createMemoryTrafficServiceWithDNS(t)
+
+	now := time.Now()
+	var crawlers []net.IP
+	for i := 1; i <= 20; i++ {
+		ip := net.IPv4(66, 249, 66, byte(i))
+		require.NoError(t, s.Monitoring.Add(ip, now))
+		require.NoError(t, s.Monitoring.Add(net.IPv4(192, 0, 2, byte(i)), now))
+
+		if i%2 == 0 {
+			host := fmt.Sprintf("crawl-66-249-66-%d.googlebot.com", i)
+			dns.AddPTR(ip, host)
+			dns.AddIP(host, ip)
+			crawlers = append(crawlers, ip)
+		}
+	}
+
+	require.NoError(t, s.Ban.Add(crawlers[0], time.Hour, banByUserID, "Test"))
+
+	err := s.AutoWhitelist()
+	require.NoError(t, err)
+
+	list, err := s.Whitelist.List()
+	require.NoError(t, err)
+	require.Len(t, list, len(crawlers))
+
+	exists, err := s.Ban.Exists(crawlers[0])
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/dns/stats", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var stats DNSCacheStats
+	err = json.Unmarshal(w.Body.Bytes(), &stats)
+	require.NoError(t, err)
+	require.Equal(t, uint64(50), stats.Misses)
+}
+
+func TestMemoryReverifyWhitelist(t *testing.T) {
+
+	s, dns := createMemoryTrafficServiceWithDNS(t)
+
+	moved := net.IPv4(66, 249, 66, 1)
+	genuine := net.IPv4(66, 249, 66, 2)
+	manual := net.IPv4(192, 0, 2, 1)
+
+	for _, ip := range []net.IP{moved, genuine} {
+		host := fmt.Sprintf("crawl-66-249-66-%d.googlebot.com", ip.To4()[3])
+		dns.AddPTR(ip, host)
+		if ip.Equal(genuine) {
+			dns.AddIP(host, ip)
+		}
+
+		err := s.Whitelist.AddItem(WhitelistItem{
+			IP:          NetworkFromIP(ip),
+			Description: "googlebot autodetect",
+			Source:      WhitelistSourceAutodetect + "googlebot",
+			CreatedAt:   time.Now().Add(-24 * time.Hour),
+		})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, s.Whitelist.Add(manual, "manual"))
+
+	verified, removed, err := s.ReverifyWhitelist()
+	require.NoError(t, err)
+	require.Equal(t, 1, verified)
+	require.Equal(t, 1, removed)
+
+	exists, err := s.Whitelist.Exists(moved)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = s.Whitelist.Exists(manual)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	item, err := s.Whitelist.Get(genuine)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	require.Equal(t, "autodetect:googlebot", item.Source)
+	require.NotNil(t, item.VerifiedAt)
+	require.WithinDuration(t, time.Now(), *item.VerifiedAt, time.Minute)
+	require.NotNil(t, item.ExpiresAt)
+	require.WithinDuration(t, time.Now().Add(7*24*time.Hour), *item.ExpiresAt, time.Minute)
+	require.WithinDuration(t, time.Now().Add(-24*time.Hour), item.CreatedAt, time.Minute)
+}
+
+func TestMemoryRepeatOffender(t *testing.T) {
+
+	s := createMemoryTrafficService(t)
+	s.banEscalation = BanEscalationConfig{Factor: 3, MaxTime: 100 * time.Hour, CleanPeriod: time.Hour}
+	s.Ban.SetOffenceCleanPeriod(s.banEscalation.CleanPeriod)
+
+	profile := AutobanProfile{Limit: 1, Reason: "Test", Window: time.Minute, Time: time.Hour, Enabled: true}
+	ip := net.IPv4(127, 0, 0, 2)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	for offence := 1; offence <= 3; offence++ {
+		err := s.banByProfile(NetworkFromIP(ip), profile)
+		require.NoError(t, err)
+
+		// already banned IP is not escalated
+		err = s.banByProfile(NetworkFromIP(ip), profile)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/ban/127.0.0.2", nil)
+		require.NoError(t, err)
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var item BanItem
+		err = json.Unmarshal(w.Body.Bytes(), &item)
+		require.NoError(t, err)
+		require.Equal(t, offence, item.Offences)
+
+		expected := time.Now().Add(escalateBanTime(profile.Time, offence-1, s.banEscalation))
+		require.WithinDuration(t, expected, item.Until, time.Minute)
+
+		err = s.Ban.Remove(ip)
+		require.NoError(t, err)
+	}
+
+	s.Ban.SetOffenceCleanPeriod(time.Nanosecond)
+	affected, err := s.Ban.GCHistory()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), affected)
+
+	offences, err := s.Ban.Offences(ip)
+	require.NoError(t, err)
+	require.Zero(t, offences)
+}
+
+func TestMemoryHttpNetworks(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	b, err := json.Marshal(map[string]interface{}{
+		"ip":         "10.0.0.0/24",
+		"duration":   60 * 1000 * 1000 * 1000,
+		"by_user_id": 4,
+		"reason":     "Test",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/ban", bytes.NewBuffer(b))
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "/ban/10.0.0.0/24", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/ban/10.0.0.5", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var ban BanItem
+	err = json.Unmarshal(w.Body.Bytes(), &ban)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.0/24", ban.IP.String())
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/ban/10.0.1.5", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	b, err = json.Marshal(map[string]interface{}{
+		"ip":          "10.0.0.0/16",
+		"description": "Office",
+	})
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/whitelist", bytes.NewBuffer(b))
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	// ban of subnet is lifted by whitelisting of network
+	exists, err := s.Ban.Exists(net.IPv4(10, 0, 0, 5))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/whitelist/10.0.0.0/24", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var item WhitelistItem
+	err = json.Unmarshal(w.Body.Bytes(), &item)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.0/16", item.IP.String())
+	require.Equal(t, "Office", item.Description)
+	require.Equal(t, WhitelistSourceManual, item.Source)
+	require.Nil(t, item.ExpiresAt)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/whitelist/10.0.0.0/16", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	exists, err = s.Whitelist.Exists(net.IPv4(10, 0, 0, 5))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/whitelist/10.0.0.0/33", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// networks shorter than minimal prefix of ban are rejected
+	for _, network := range []string{"0.0.0.0/0", "::/0", "2001:db8::/16"} {
+		b, err = json.Marshal(map[string]interface{}{
+			"ip":       network,
+			"duration": 60 * 1000 * 1000 * 1000,
+			"reason":   "Test",
+		})
+		require.NoError(t, err)
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/ban", bytes.NewBuffer(b))
+		require.NoError(t, err)
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code, network)
+		require.Contains(t, w.Body.String(), "wider than")
+	}
+
+	exists, err = s.Ban.Exists(net.IPv4(192, 0, 2, 1))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestMemoryHttpCheck(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	check := func(url string, header string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		if header != "" {
+			req.Header.Set("X-Real-IP", header)
+		}
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	err := s.Ban.AddNetwork(mustParseNetwork(t, "192.0.2.0/24"), time.Hour, 1, "Test")
+	require.NoError(t, err)
+
+	// repositories answer till snapshot is loaded
+	require.Equal(t, http.StatusForbidden, check("/check/192.0.2.1", "").Code)
+
+	require.NoError(t, s.Snapshot.Load())
+
+	w := check("/check", "192.0.2.1")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	require.InDelta(t, 3600, retryAfter, 5)
+
+	require.Equal(t, http.StatusNoContent, check("/check?ip=198.51.100.1", "").Code)
+	require.Equal(t, http.StatusBadRequest, check("/check", "").Code)
+	require.Equal(t, http.StatusBadRequest, check("/check/example.com", "").Code)
+
+	err = s.Whitelist.AddNetwork(mustParseNetwork(t, "192.0.2.1"), "Test")
+	require.NoError(t, err)
+	require.NoError(t, s.Snapshot.Refresh())
+
+	require.Equal(t, http.StatusNoContent, check("/check/192.0.2.1", "").Code)
+	require.Equal(t, http.StatusForbidden, check("/check/192.0.2.2", "").Code)
+}
+
+func TestMemoryHttpBanExport(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "192.0.2.0/30"), time.Hour, 1, "Test"))
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "198.51.100.7"), time.Hour, 1, "Test"))
+	require.NoError(t, s.Whitelist.AddNetwork(mustParseNetwork(t, "192.0.2.1"), "Test"))
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/ban/export?format=nginx", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "deny 192.0.2.0;\ndeny 192.0.2.2/31;\ndeny 198.51.100.7;\n", w.Body.String())
+
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/ban/export?format=nginx", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", etag)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "203.0.113.1"), time.Hour, 1, "Test"))
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/ban/export?format=nginx", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", etag)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/ban/export?format=iptables", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// ban of IP is still served by the same route node
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/ban/198.51.100.7", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMemoryHttpSyncClient(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "192.0.2.0/24"), time.Hour, 1, "Test"))
+
+	c, err := client.New(client.Config{URL: server.URL})
+	require.NoError(t, err)
+	require.NoError(t, c.Load(context.Background()))
+
+	ban, err := c.Check(net.ParseIP("192.0.2.1"))
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+	require.Equal(t, "Test", ban.Reason)
+
+	require.NoError(t, s.Whitelist.AddNetwork(mustParseNetwork(t, "192.0.2.1"), "Test"))
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "198.51.100.1"), time.Hour, 1, "Test"))
+	require.NoError(t, c.Refresh(context.Background()))
+
+	ban, err = c.Check(net.ParseIP("192.0.2.1"))
+	require.NoError(t, err)
+	require.Nil(t, ban)
+
+	ban, err = c.Check(net.ParseIP("198.51.100.1"))
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+
+	require.NoError(t, s.Ban.Remove(net.ParseIP("198.51.100.1")))
+	require.NoError(t, c.Refresh(context.Background()))
+
+	ban, err = c.Check(net.ParseIP("198.51.100.1"))
+	require.NoError(t, err)
+	require.Nil(t, ban)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/sync/changes?ban_cursor=x", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestMemoryHttpLists(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	get := func(url string, result interface{}) int {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		r.ServeHTTP(w, req)
+
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+		}
+
+		return w.Code
+	}
+
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "192.0.2.0/24"), time.Hour, 1, "Test"))
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "192.0.2.1"), 2*time.Hour, 2, "Test"))
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "198.51.100.1"), 3*time.Hour, 1, "Other"))
+
+	var bans BanListPage
+	require.Equal(t, http.StatusOK, get("/ban?sort=-up_to&limit=2", &bans))
+	require.Equal(t, 3, bans.Total)
+	require.Len(t, bans.Items, 2)
+	require.Equal(t, "198.51.100.1", bans.Items[0].IP.String())
+	require.NotEmpty(t, bans.NextCursor)
+
+	var next BanListPage
+	require.Equal(t, http.StatusOK, get("/ban?sort=-up_to&limit=2&cursor="+bans.NextCursor, &next))
+	require.Len(t, next.Items, 1)
+	require.Equal(t, "192.0.2.0/24", next.Items[0].IP.String())
+	require.Empty(t, next.NextCursor)
+
+	bans = BanListPage{}
+	require.Equal(t, http.StatusOK, get("/ban?reason=Test&by_user_id=1&contains=192.0.2.5", &bans))
+	require.Equal(t, 1, bans.Total)
+	require.Equal(t, "192.0.2.0/24", bans.Items[0].IP.String())
+
+	bans = BanListPage{}
+	expiresBefore := time.Now().Add(90 * time.Minute).Format(time.RFC3339)
+	require.Equal(t, http.StatusOK, get("/ban?within=192.0.2.0/24&expires_before="+url.QueryEscape(expiresBefore), &bans))
+	require.Equal(t, 1, bans.Total)
+
+	require.Equal(t, http.StatusBadRequest, get("/ban?sort=reason", nil))
+	require.Equal(t, http.StatusBadRequest, get("/ban?cursor=garbage!", nil))
+	require.Equal(t, http.StatusBadRequest, get("/ban?by_user_id=x", nil))
+	require.Equal(t, http.StatusBadRequest, get("/ban?expires_after=tomorrow", nil))
+	require.Equal(t, http.StatusBadRequest, get("/ban?within=example.com", nil))
+
+	require.NoError(t, s.Whitelist.AddNetwork(mustParseNetwork(t, "192.0.2.10"), "Office"))
+	require.NoError(t, s.Whitelist.AddItem(WhitelistItem{
+		IP:          mustParseNetwork(t, "192.0.2.11"),
+		Description: "googlebot",
+		Source:      "autodetect:google",
+	}))
+
+	// plain array without listing parameters
+	var list []WhitelistItem
+	require.Equal(t, http.StatusOK, get("/whitelist", &list))
+	require.Len(t, list, 2)
+
+	var whitelist WhitelistListPage
+	require.Equal(t, http.StatusOK, get("/whitelist?limit=10", &whitelist))
+	require.Equal(t, 2, whitelist.Total)
+	require.Len(t, whitelist.Items, 2)
+
+	whitelist = WhitelistListPage{}
+	require.Equal(t, http.StatusOK, get("/whitelist?q=office&source=manual&sort=-created_at", &whitelist))
+	require.Equal(t, 1, whitelist.Total)
+	require.Equal(t, "192.0.2.10", whitelist.Items[0].IP.String())
+
+	require.Equal(t, http.StatusBadRequest, get("/whitelist?limit=x", nil))
+}
+
+func TestMemoryHttpDossier(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	require.NoError(t, s.Ban.AddNetwork(mustParseNetwork(t, "2001:db8::/32"), time.Hour, 1, "Test"))
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/ip/2001:db8::1", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var dossier IPDossier
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &dossier))
+	require.Equal(t, "2001:db8::1", dossier.IP.String())
+	require.NotNil(t, dossier.Ban)
+	require.Equal(t, "2001:db8::/32", dossier.Ban.IP.String())
+	require.Len(t, dossier.BanHistory, 1)
+	require.Len(t, dossier.Histogram.Minute, dossierMinutes)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/ip/example.com", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestMemoryHttpTopParams(t *testing.T) {
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	now := time.Now()
+	require.NoError(t, s.Monitoring.AddBatch([]MonitoringBucket{
+		{IP: net.IPv4(192, 0, 2, 1), Minute: now, Count: 2},
+		{IP: net.IPv4(192, 0, 2, 2), Minute: now, Count: 1},
+		{IP: net.IPv4(198, 51, 100, 1), Minute: now.Add(-2 * time.Hour), Count: 5},
+		{IP: net.IPv4(203, 0, 113, 1), Minute: startOfDay(now).AddDate(0, 0, -2).Add(12 * time.Hour), Count: 9},
+	}))
+	require.NoError(t, s.Ban.Add(net.IPv4(192, 0, 2, 1), time.Hour, 1, "Test"))
+
+	get := func(url string) ([]TopItem, int) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		r.ServeHTTP(w, req)
+
+		var result []TopItem
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+
+		return result, w.Code
+	}
+
+	items, code := get("/top?window=1h")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, items, 2)
+	require.Equal(t, "192.0.2.1", items[0].IP.String())
+	require.NotNil(t, items[0].Ban)
+
+	items, code = get("/top?window=1h&hide_banned=1")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, items, 1)
+	require.Equal(t, "192.0.2.2", items[0].IP.String())
+
+	items, code = get("/top?window=3h&ipv4_prefix=24&limit=1")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, items, 1)
+	require.Equal(t, "198.51.100.0/24", items[0].IP.String())
+	require.Equal(t, 5, items[0].Count)
+
+	items, code = get("/top?day=" + now.AddDate(0, 0, -2).Format("2006-01-02"))
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, items, 1)
+	require.Equal(t, "203.0.113.1", items[0].IP.String())
+
+	for _, query := range []string{"limit=0", "limit=1001", "window=abc", "window=-1h", "day=yesterday",
+		"window=1h&day=2020-01-01", "ipv4_prefix=33", "ipv6_prefix=x", "hide_banned=maybe"} {
+		_, code = get("/top?" + query)
+		require.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
@@ -2,148 +2,326 @@ package traffic
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
-	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"net"
 	"strings"
+	"time"
 )
 
+const (
+	// WhitelistSourceManual source of items added by user
+	WhitelistSourceManual = "manual"
+	// WhitelistSourceAutodetect prefix of source of items added by crawler rule, followed by name of rule
+	WhitelistSourceAutodetect = "autodetect:"
+)
+
+// WhitelistSortCreatedAt orders whitelist by time of creation of item
+const WhitelistSortCreatedAt = "created_at"
+
+// WhitelistSorts fields whitelist can be listed by, first one is default
+var WhitelistSorts = []string{ListSortIP, WhitelistSortCreatedAt}
+
+// WhitelistListQuery filter, order and page of whitelist listing. Only not expired items are listed
+type WhitelistListQuery struct {
+	// Description selects items which description contains text, case insensitive
+	Description string
+	Source      string
+	Sort        ListSort
+	After       *ListCursor
+	Limit       int
+}
+
+// WhitelistListPage page of whitelist listing
+type WhitelistListPage struct {
+	Items []WhitelistItem `json:"items"`
+	// Total number of items matching filter
+	Total int `json:"total"`
+	// NextCursor continues listing, empty on last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// WhitelistRepository storage of whitelisted IPs
+type WhitelistRepository interface {
+	// Add inserts item or replaces existing item of the same network keeping its creation time
+	Add(item WhitelistItem) error
+	// Get returns most specific not expired item which contains network or nil
+	Get(network Network) (*WhitelistItem, error)
+	// List returns not expired items
+	List() ([]WhitelistItem, error)
+	// Find returns up to query.Limit items matching query after its cursor and total number of matching items
+	Find(query WhitelistListQuery) ([]WhitelistItem, int, error)
+	// Exists reports not expired item which contains network
+	Exists(network Network) (bool, error)
+	// Remove deletes item of exactly this network
+	Remove(network Network) error
+	// GC deletes expired items
+	GC() (int64, error)
+	// LastChange returns cursor of most recent change
+	LastChange() (int64, error)
+	// Changes returns up to limit changes recorded after cursor in order of recording
+	Changes(cursor int64, limit int) ([]WhitelistChange, error)
+	// GCChanges deletes changes recorded before given time
+	GCChanges(before time.Time) (int64, error)
+}
+
+// WhitelistChange change of whitelist item of network recorded by repository
+type WhitelistChange struct {
+	ID int64   `json:"id"`
+	IP Network `json:"ip"`
+	// Item current item of network, nil when it is removed
+	Item *WhitelistItem `json:"item"`
+}
+
 // Whitelist Main Object
 type Whitelist struct {
-	db *pgxpool.Pool
+	repository    WhitelistRepository
+	resolver      Resolver
+	crawlers      []CrawlerRule
+	autodetectTTL time.Duration
 }
 
 // WhitelistItem WhitelistItem
 type WhitelistItem struct {
-	IP          net.IP `json:"ip"`
-	Description string `json:"description"`
+	IP          Network `json:"ip"`
+	Description string  `json:"description"`
+	// Source manual or autodetect rule which added item
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+	// VerifiedAt last time autodetected item was verified
+	VerifiedAt *time.Time `json:"verified_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// IsAutodetected item is added by crawler rule
+func (i WhitelistItem) IsAutodetected() bool {
+	return strings.HasPrefix(i.Source, WhitelistSourceAutodetect)
 }
 
 // NewWhitelist constructor
-func NewWhitelist(db *pgxpool.Pool) (*Whitelist, error) {
+func NewWhitelist(repository WhitelistRepository) (*Whitelist, error) {
+	if repository == nil {
+		return nil, fmt.Errorf("whitelist repository is nil")
+	}
+
 	return &Whitelist{
-		db: db,
+		repository: repository,
+		resolver:   net.DefaultResolver,
 	}, nil
 }
 
-// MatchAuto MatchAuto
+// SetResolver replaces resolver used for crawlers detection
+func (s *Whitelist) SetResolver(resolver Resolver) {
+	s.resolver = resolver
+}
+
+// SetCrawlerRules sets rules of crawlers detection
+func (s *Whitelist) SetCrawlerRules(rules []CrawlerRule) {
+	s.crawlers = rules
+}
+
+// SetAutodetectTTL sets how long autodetected item is kept after last verification, zero means forever
+func (s *Whitelist) SetAutodetectTTL(ttl time.Duration) {
+	s.autodetectTTL = ttl
+}
+
+// MatchAuto detects known crawler by PTR record of IP.
+// Hostname must resolve back to the IP (forward-confirmed reverse DNS)
 func (s *Whitelist) MatchAuto(ip net.IP) (bool, string) {
+	match, _ := s.Verify(ip)
+	if match == nil {
+		return false, ""
+	}
 
-	ipText := ip.String()
-	ipWithDashes := strings.Replace(ipText, ".", "-", -1)
+	return true, match.Description
+}
 
-	msnHost := "msnbot-" + ipWithDashes + ".search.msn.com."
-	yandexComHost := ipWithDashes + ".spider.yandex.com."
-	googlebotHost := "crawl-" + ipWithDashes + ".googlebot.com."
+// Hostnames PTR records of IP, answered from DNS cache when it is enabled
+func (s *Whitelist) Hostnames(ip net.IP) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
 
-	isIPv6 := len(ip) == net.IPv6len
-	ip16 := ip.To16()
+	hosts, err := s.resolver.LookupAddr(ctx, ip.String())
+	if err != nil && isDNSNotFound(err) {
+		return nil, nil
+	}
 
-	yandexComIPv6Host := hex.EncodeToString(ip16[12:14]) + "-" + hex.EncodeToString(ip16[14:16]) + ".spider.yandex.com."
+	return hosts, err
+}
 
-	hosts, err := net.LookupAddr(ipText)
+// Verify detects known crawler by forward-confirmed reverse DNS.
+// Error means lookup failed and it is unknown whether IP is crawler
+func (s *Whitelist) Verify(ip net.IP) (*CrawlerMatch, error) {
+
+	if len(s.crawlers) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	hosts, err := s.resolver.LookupAddr(ctx, ip.String())
 	if err != nil {
-		return false, ""
+		if isDNSNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
 	}
 
+	var lastErr error
 	for _, host := range hosts {
+		for _, rule := range s.crawlers {
+			if !rule.Match(host, ip) {
+				continue
+			}
 
-		fmt.Print(host + " ")
+			confirmed, err := forwardConfirmed(ctx, s.resolver, host, ip)
+			if err != nil {
+				lastErr = err
+				break
+			}
 
-		if host == msnHost {
-			return true, "msnbot autodetect"
-		}
-		if host == yandexComHost {
-			return true, "yandex.com autodetect"
-		}
-		if host == googlebotHost {
-			return true, "googlebot autodetect"
-		}
-		if isIPv6 && host == yandexComIPv6Host {
-			return true, "yandex.com ipv6 autodetect"
+			if confirmed {
+				return &CrawlerMatch{
+					Rule:        rule,
+					Host:        host,
+					Description: rule.Describe(host, ip),
+				}, nil
+			}
+
+			break
 		}
 	}
 
-	return false, ""
+	return nil, lastErr
 }
 
 // Add IP to whitelist
 func (s *Whitelist) Add(ip net.IP, desc string) error {
-	_, err := s.db.Exec(context.Background(), `
-		INSERT INTO ip_whitelist (ip, description)
-		VALUES ($1, $2)
-		ON CONFLICT (ip) DO UPDATE SET description=EXCLUDED.description
-	`, ip, desc)
+	return s.AddNetwork(NetworkFromIP(ip), desc)
+}
 
-	return err
+// AddNetwork adds network to whitelist
+func (s *Whitelist) AddNetwork(network Network, desc string) error {
+	return s.AddItem(WhitelistItem{
+		IP:          network,
+		Description: desc,
+		Source:      WhitelistSourceManual,
+	})
 }
 
-// Get whitelist item
-func (s *Whitelist) Get(ip net.IP) (*WhitelistItem, error) {
-	var item WhitelistItem
-	err := s.db.QueryRow(context.Background(), `
-		SELECT ip, description
-		FROM ip_whitelist
-		WHERE ip = $1
-	`, ip).Scan(&item.IP, item.Description)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil
-		}
+// AddItem adds item to whitelist. Source defaults to manual
+func (s *Whitelist) AddItem(item WhitelistItem) error {
+	if len(item.Source) == 0 {
+		item.Source = WhitelistSourceManual
+	}
 
-		return nil, err
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+
+	return s.repository.Add(item)
+}
+
+// AddAutodetected adds verified crawler to whitelist
+func (s *Whitelist) AddAutodetected(ip net.IP, match *CrawlerMatch) error {
+	now := time.Now()
+
+	item := WhitelistItem{
+		IP:          NetworkFromIP(ip),
+		Description: match.Description,
+		Source:      match.Source(),
+		CreatedAt:   now,
+		VerifiedAt:  &now,
+	}
+
+	if s.autodetectTTL > 0 {
+		expiresAt := now.Add(s.autodetectTTL)
+		item.ExpiresAt = &expiresAt
 	}
 
-	return &item, nil
+	return s.AddItem(item)
+}
+
+// Get whitelist item which contains IP
+func (s *Whitelist) Get(ip net.IP) (*WhitelistItem, error) {
+	return s.GetNetwork(NetworkFromIP(ip))
+}
+
+// GetNetwork most specific whitelist item which contains network
+func (s *Whitelist) GetNetwork(network Network) (*WhitelistItem, error) {
+	return s.repository.Get(network)
 }
 
 // List whitelist items
 func (s *Whitelist) List() ([]WhitelistItem, error) {
-	result := make([]WhitelistItem, 0)
-	rows, err := s.db.Query(context.Background(), `
-		SELECT ip, description
-		FROM ip_whitelist
-	`)
-	if err != nil {
-		return nil, err
+	return s.repository.List()
+}
+
+// Find page of whitelist items matching query
+func (s *Whitelist) Find(query WhitelistListQuery) (WhitelistListPage, error) {
+	if len(query.Sort.Field) == 0 {
+		query.Sort.Field = ListSortIP
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var item WhitelistItem
-		if err := rows.Scan(&item.IP, &item.Description); err != nil {
-			return nil, err
-		}
+	limit := listLimit(query.Limit)
+	// one more item tells whether next page exists
+	query.Limit = limit + 1
 
-		result = append(result, item)
+	items, total, err := s.repository.Find(query)
+	if err != nil {
+		return WhitelistListPage{}, err
+	}
+
+	page := WhitelistListPage{Items: items, Total: total}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = whitelistListKey(items[limit-1]).String()
 	}
 
-	return result, nil
+	return page, nil
+}
+
+// whitelistListKey sort key of whitelist item
+func whitelistListKey(item WhitelistItem) ListCursor {
+	return ListCursor{IP: item.IP, Time: item.CreatedAt}
 }
 
 // Exists whitelist already contains IP
 func (s *Whitelist) Exists(ip net.IP) (bool, error) {
-	var exists bool
-	err := s.db.QueryRow(context.Background(), `
-		SELECT true
-		FROM ip_whitelist
-		WHERE ip = $1
-	`, ip).Scan(&exists)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return false, nil
-		}
-
-		return false, err
-	}
+	return s.ExistsNetwork(NetworkFromIP(ip))
+}
 
-	return true, nil
+// ExistsNetwork whitelist contains whole network
+func (s *Whitelist) ExistsNetwork(network Network) (bool, error) {
+	return s.repository.Exists(network)
 }
 
 // Remove IP from whitelist
 func (s *Whitelist) Remove(ip net.IP) error {
-	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_whitelist WHERE ip = $1", ip)
-	return err
+	return s.RemoveNetwork(NetworkFromIP(ip))
+}
+
+// RemoveNetwork removes entry of network from whitelist
+func (s *Whitelist) RemoveNetwork(network Network) error {
+	return s.repository.Remove(network)
+}
+
+// GC deletes expired items
+func (s *Whitelist) GC() (int64, error) {
+	return s.repository.GC()
+}
+
+// LastChange cursor of most recent change of whitelist
+func (s *Whitelist) LastChange() (int64, error) {
+	return s.repository.LastChange()
+}
+
+// Changes of whitelist recorded after cursor
+func (s *Whitelist) Changes(cursor int64, limit int) ([]WhitelistChange, error) {
+	return s.repository.Changes(cursor, limit)
+}
+
+// GCChanges deletes change log recorded before given time
+func (s *Whitelist) GCChanges(before time.Time) (int64, error) {
+	return s.repository.GCChanges(before)
 }
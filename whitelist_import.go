@@ -0,0 +1,127 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// WhitelistSourceImport prefix of source of items imported from range file, followed by name of import
+const WhitelistSourceImport = "import:"
+
+// ipRangesFile published crawler ranges, e.g. https://developers.google.com/search/apis/ipranges/googlebot.json
+type ipRangesFile struct {
+	Prefixes []struct {
+		IPv4Prefix string `json:"ipv4Prefix"`
+		IPv6Prefix string `json:"ipv6Prefix"`
+	} `json:"prefixes"`
+}
+
+// WhitelistImportResult WhitelistImportResult
+type WhitelistImportResult struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Total   int `json:"total"`
+}
+
+// ParseIPRanges parses JSON range file with prefixes[].ipv4Prefix/ipv6Prefix
+func ParseIPRanges(r io.Reader) ([]Network, error) {
+	var file ipRangesFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	result := make([]Network, 0, len(file.Prefixes))
+	for idx, prefix := range file.Prefixes {
+		value := prefix.IPv4Prefix
+		if len(value) == 0 {
+			value = prefix.IPv6Prefix
+		}
+
+		network, err := ParseNetwork(value)
+		if err != nil {
+			return nil, fmt.Errorf("prefixes[%d]: %v", idx, err)
+		}
+
+		result = append(result, network)
+	}
+
+	return result, nil
+}
+
+// Import reconciles whitelist items of named source with networks: adds new ones and removes not listed anymore.
+// Items of other sources are left untouched. Added networks are returned
+func (s *Whitelist) Import(name string, networks []Network) ([]Network, WhitelistImportResult, error) {
+	result := WhitelistImportResult{Total: len(networks)}
+
+	if len(name) == 0 {
+		return nil, result, fmt.Errorf("name of import is required")
+	}
+
+	source := WhitelistSourceImport + name
+
+	items, err := s.List()
+	if err != nil {
+		return nil, result, err
+	}
+
+	existing := make(map[string]WhitelistItem, len(items))
+	for _, item := range items {
+		existing[item.IP.String()] = item
+	}
+
+	listed := make(map[string]bool, len(networks))
+	added := make([]Network, 0)
+	for _, network := range networks {
+		key := network.String()
+		if listed[key] {
+			continue
+		}
+		listed[key] = true
+
+		if _, ok := existing[key]; ok {
+			continue
+		}
+
+		err := s.AddItem(WhitelistItem{
+			IP:          network,
+			Description: name + " import",
+			Source:      source,
+		})
+		if err != nil {
+			return nil, result, err
+		}
+
+		added = append(added, network)
+	}
+	result.Added = len(added)
+
+	for key, item := range existing {
+		if item.Source != source || listed[key] {
+			continue
+		}
+
+		if err := s.RemoveNetwork(item.IP); err != nil {
+			return nil, result, err
+		}
+		result.Removed++
+	}
+
+	return added, result, nil
+}
+
+// ImportWhitelist imports networks into whitelist under named source and lifts bans of added networks
+func (s *Traffic) ImportWhitelist(name string, networks []Network) (WhitelistImportResult, error) {
+	added, result, err := s.Whitelist.Import(name, networks)
+	if err != nil {
+		return result, err
+	}
+
+	for _, network := range added {
+		if err := s.Ban.RemoveNetwork(network); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,146 @@
+package traffic
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+const googlebotRanges = `{
+  "creationTime": "2021-01-18T23:00:00.000000",
+  "prefixes": [
+    {"ipv6Prefix": "2001:4860:4801:10::/64"},
+    {"ipv4Prefix": "66.249.64.0/27"},
+    {"ipv4Prefix": "66.249.64.32/27"}
+  ]
+}`
+
+func TestParseIPRanges(t *testing.T) {
+
+	networks, err := ParseIPRanges(strings.NewReader(googlebotRanges))
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+	require.Equal(t, "2001:4860:4801:10::/64", networks[0].String())
+	require.Equal(t, "66.249.64.0/27", networks[1].String())
+
+	_, err = ParseIPRanges(strings.NewReader(`{"prefixes": [{"ipv4Prefix": "66.249.64.0/33"}]}`))
+	require.Error(t, err)
+
+	_, err = ParseIPRanges(strings.NewReader(`{"prefixes": [{}]}`))
+	require.Error(t, err)
+
+	_, err = ParseIPRanges(strings.NewReader(`not json`))
+	require.Error(t, err)
+}
+
+func TestMemoryWhitelistImport(t *testing.T) {
+
+	s := createMemoryTrafficService(t)
+
+	manual, err := ParseNetwork("66.249.64.0/27")
+	require.NoError(t, err)
+	require.NoError(t, s.Whitelist.AddNetwork(manual, "Office"))
+
+	bing, err := ParseNetwork("157.55.39.0/24")
+	require.NoError(t, err)
+	_, _, err = s.Whitelist.Import("bingbot", []Network{bing})
+	require.NoError(t, err)
+
+	banned := net.IPv4(66, 249, 64, 40)
+	require.NoError(t, s.Ban.Add(banned, time.Hour, banByUserID, "Test"))
+
+	networks, err := ParseIPRanges(strings.NewReader(googlebotRanges))
+	require.NoError(t, err)
+
+	result, err := s.ImportWhitelist("googlebot", networks)
+	require.NoError(t, err)
+	require.Equal(t, WhitelistImportResult{Added: 2, Removed: 0, Total: 3}, result)
+
+	exists, err := s.Ban.Exists(banned)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	item, err := s.Whitelist.Get(banned)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	require.Equal(t, "import:googlebot", item.Source)
+
+	// manual item is not taken over
+	item, err = s.Whitelist.GetNetwork(manual)
+	require.NoError(t, err)
+	require.Equal(t, WhitelistSourceManual, item.Source)
+
+	// range is not listed anymore
+	result, err = s.ImportWhitelist("googlebot", networks[:2])
+	require.NoError(t, err)
+	require.Equal(t, WhitelistImportResult{Added: 0, Removed: 1, Total: 2}, result)
+
+	exists, err = s.Whitelist.Exists(banned)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = s.Whitelist.ExistsNetwork(manual)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = s.Whitelist.ExistsNetwork(bing)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	_, _, err = s.Whitelist.Import("", networks)
+	require.Error(t, err)
+}
+
+func TestMemoryHttpWhitelistImport(t *testing.T) {
+
+	s := createMemoryTrafficService(t)
+
+	r := gin.New()
+	s.SetupRouter(r)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/whitelist/import/googlebot", strings.NewReader(googlebotRanges))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result WhitelistImportResult
+	err = json.Unmarshal(w.Body.Bytes(), &result)
+	require.NoError(t, err)
+	require.Equal(t, WhitelistImportResult{Added: 3, Total: 3}, result)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "googlebot.json")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(`{"prefixes": [{"ipv4Prefix": "66.249.64.0/27"}]}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/whitelist/import/googlebot", body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	err = json.Unmarshal(w.Body.Bytes(), &result)
+	require.NoError(t, err)
+	require.Equal(t, WhitelistImportResult{Added: 0, Removed: 2, Total: 1}, result)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/whitelist/import/googlebot", strings.NewReader("broken"))
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,200 @@
+package traffic
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryWhitelistRepository keeps whitelist in process memory
+type MemoryWhitelistRepository struct {
+	mutex   sync.RWMutex
+	items   map[string]WhitelistItem
+	changes memoryChangeLog
+}
+
+// NewMemoryWhitelistRepository constructor
+func NewMemoryWhitelistRepository() *MemoryWhitelistRepository {
+	return &MemoryWhitelistRepository{
+		items: make(map[string]WhitelistItem),
+	}
+}
+
+// Add item to whitelist
+func (s *MemoryWhitelistRepository) Add(item WhitelistItem) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := item.IP.String()
+	if existing, ok := s.items[key]; ok {
+		item.CreatedAt = existing.CreatedAt
+	}
+	s.items[key] = item
+	s.changes.record(item.IP)
+
+	return nil
+}
+
+func whitelistItemExpired(item WhitelistItem, now time.Time) bool {
+	return item.ExpiresAt != nil && !item.ExpiresAt.After(now)
+}
+
+// Get most specific whitelist item which contains network
+func (s *MemoryWhitelistRepository) Get(network Network) (*WhitelistItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	var result *WhitelistItem
+	resultOnes := -1
+	for _, item := range s.items {
+		if !item.IP.ContainsNetwork(network) || whitelistItemExpired(item, now) {
+			continue
+		}
+
+		ones, _ := item.IP.Mask.Size()
+		if ones > resultOnes {
+			match := item
+			result = &match
+			resultOnes = ones
+		}
+	}
+
+	return result, nil
+}
+
+// List whitelist items
+func (s *MemoryWhitelistRepository) List() ([]WhitelistItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	result := make([]WhitelistItem, 0, len(s.items))
+	for _, item := range s.items {
+		if !whitelistItemExpired(item, now) {
+			result = append(result, item)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].IP.IP.To16(), result[j].IP.IP.To16()) < 0
+	})
+
+	return result, nil
+}
+
+// Find returns page of items matching query and total number of matching items
+func (s *MemoryWhitelistRepository) Find(query WhitelistListQuery) ([]WhitelistItem, int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	description := strings.ToLower(query.Description)
+	matched := make([]WhitelistItem, 0)
+	for _, item := range s.items {
+		if whitelistItemExpired(item, now) {
+			continue
+		}
+		if len(description) > 0 && !strings.Contains(strings.ToLower(item.Description), description) {
+			continue
+		}
+		if len(query.Source) > 0 && item.Source != query.Source {
+			continue
+		}
+		matched = append(matched, item)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return compareListKeys(query.Sort, whitelistListKey(matched[i]), whitelistListKey(matched[j])) < 0
+	})
+
+	result := make([]WhitelistItem, 0, query.Limit)
+	for _, item := range matched {
+		if len(result) >= query.Limit {
+			break
+		}
+		if query.After != nil && compareListKeys(query.Sort, whitelistListKey(item), *query.After) <= 0 {
+			continue
+		}
+		result = append(result, item)
+	}
+
+	return result, len(matched), nil
+}
+
+// Exists whitelist contains network
+func (s *MemoryWhitelistRepository) Exists(network Network) (bool, error) {
+	item, err := s.Get(network)
+	if err != nil {
+		return false, err
+	}
+
+	return item != nil, nil
+}
+
+// Remove network from whitelist
+func (s *MemoryWhitelistRepository) Remove(network Network) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := network.String()
+	if item, ok := s.items[key]; ok {
+		delete(s.items, key)
+		s.changes.record(item.IP)
+	}
+
+	return nil
+}
+
+// GC deletes expired items
+func (s *MemoryWhitelistRepository) GC() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	var affected int64
+	for key, item := range s.items {
+		if whitelistItemExpired(item, now) {
+			delete(s.items, key)
+			s.changes.record(item.IP)
+			affected++
+		}
+	}
+
+	return affected, nil
+}
+
+// LastChange returns cursor of most recent change
+func (s *MemoryWhitelistRepository) LastChange() (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.changes.last, nil
+}
+
+// Changes returns up to limit changes recorded after cursor
+func (s *MemoryWhitelistRepository) Changes(cursor int64, limit int) ([]WhitelistChange, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entries := s.changes.since(cursor, limit)
+	result := make([]WhitelistChange, len(entries))
+	for idx, entry := range entries {
+		result[idx] = WhitelistChange{ID: entry.id, IP: entry.network}
+		if item, ok := s.items[entry.network.String()]; ok {
+			result[idx].Item = &item
+		}
+	}
+
+	return result, nil
+}
+
+// GCChanges deletes changes recorded before given time
+func (s *MemoryWhitelistRepository) GCChanges(before time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.changes.gc(before), nil
+}
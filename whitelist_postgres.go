@@ -0,0 +1,233 @@
+package traffic
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PostgresWhitelistRepository stores whitelist in ip_whitelist table
+type PostgresWhitelistRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresWhitelistRepository constructor
+func NewPostgresWhitelistRepository(db *pgxpool.Pool) *PostgresWhitelistRepository {
+	return &PostgresWhitelistRepository{
+		db: db,
+	}
+}
+
+const whitelistColumns = "ip, description, source, created_at, verified_at, expires_at"
+
+func scanWhitelistItem(row pgx.Row) (WhitelistItem, error) {
+	var item WhitelistItem
+	err := row.Scan(&item.IP.IPNet, &item.Description, &item.Source, &item.CreatedAt, &item.VerifiedAt, &item.ExpiresAt)
+
+	return item, err
+}
+
+// Add item to whitelist
+func (s *PostgresWhitelistRepository) Add(item WhitelistItem) error {
+	_, err := s.db.Exec(context.Background(), `
+		INSERT INTO ip_whitelist (ip, description, source, created_at, verified_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (ip) DO UPDATE SET
+			description=EXCLUDED.description,
+			source=EXCLUDED.source,
+			verified_at=EXCLUDED.verified_at,
+			expires_at=EXCLUDED.expires_at
+	`, item.IP, item.Description, item.Source, item.CreatedAt, item.VerifiedAt, item.ExpiresAt)
+
+	return err
+}
+
+// Get most specific whitelist item which contains network
+func (s *PostgresWhitelistRepository) Get(network Network) (*WhitelistItem, error) {
+	item, err := scanWhitelistItem(s.db.QueryRow(context.Background(), `
+		SELECT `+whitelistColumns+`
+		FROM ip_whitelist
+		WHERE ip >>= $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY masklen(ip) DESC
+		LIMIT 1
+	`, network))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// List whitelist items
+func (s *PostgresWhitelistRepository) List() ([]WhitelistItem, error) {
+	result := make([]WhitelistItem, 0)
+	rows, err := s.db.Query(context.Background(), `
+		SELECT `+whitelistColumns+`
+		FROM ip_whitelist
+		WHERE expires_at IS NULL OR expires_at > NOW()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanWhitelistItem(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+// Find returns page of items matching query and total number of matching items
+func (s *PostgresWhitelistRepository) Find(query WhitelistListQuery) ([]WhitelistItem, int, error) {
+	filter := postgresFilter{}
+	filter.where("(expires_at IS NULL OR expires_at > NOW())")
+
+	if len(query.Description) > 0 {
+		filter.where("description ILIKE '%' || " + filter.arg(escapeLike(query.Description)) + " || '%'")
+	}
+
+	if len(query.Source) > 0 {
+		filter.where("source = " + filter.arg(query.Source))
+	}
+
+	var total int
+	err := s.db.QueryRow(context.Background(), "SELECT COUNT(1) FROM ip_whitelist "+filter.sql(), filter.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filter.after(query.Sort, "created_at", query.After)
+
+	rows, err := s.db.Query(context.Background(), `
+		SELECT `+whitelistColumns+`
+		FROM ip_whitelist
+		`+filter.sql()+`
+		`+postgresOrderBy(query.Sort, "created_at")+`
+		LIMIT `+filter.arg(query.Limit), filter.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := make([]WhitelistItem, 0)
+	for rows.Next() {
+		item, err := scanWhitelistItem(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		result = append(result, item)
+	}
+
+	return result, total, rows.Err()
+}
+
+// Exists whitelist contains network
+func (s *PostgresWhitelistRepository) Exists(network Network) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(context.Background(), `
+		SELECT true
+		FROM ip_whitelist
+		WHERE ip >>= $1 AND (expires_at IS NULL OR expires_at > NOW())
+		LIMIT 1
+	`, network).Scan(&exists)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Remove network from whitelist
+func (s *PostgresWhitelistRepository) Remove(network Network) error {
+	_, err := s.db.Exec(context.Background(), "DELETE FROM ip_whitelist WHERE ip = $1", network)
+	return err
+}
+
+// GC deletes expired items
+func (s *PostgresWhitelistRepository) GC() (int64, error) {
+	ct, err := s.db.Exec(context.Background(), "DELETE FROM ip_whitelist WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, err
+	}
+
+	return ct.RowsAffected(), nil
+}
+
+// LastChange returns cursor of most recent change
+func (s *PostgresWhitelistRepository) LastChange() (int64, error) {
+	var cursor int64
+	err := s.db.QueryRow(context.Background(), "SELECT COALESCE(MAX(id), 0) FROM ip_whitelist_change").Scan(&cursor)
+
+	return cursor, err
+}
+
+// Changes returns up to limit changes recorded after cursor joined with current item of network
+func (s *PostgresWhitelistRepository) Changes(cursor int64, limit int) ([]WhitelistChange, error) {
+	rows, err := s.db.Query(context.Background(), `
+		SELECT c.id, c.ip, w.description, w.source, w.created_at, w.verified_at, w.expires_at
+		FROM ip_whitelist_change c
+			LEFT JOIN ip_whitelist w ON w.ip = c.ip
+		WHERE c.id > $1
+		ORDER BY c.id
+		LIMIT $2
+	`, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]WhitelistChange, 0)
+	for rows.Next() {
+		var change WhitelistChange
+		var description, source *string
+		var createdAt *time.Time
+		item := WhitelistItem{}
+		err := rows.Scan(&change.ID, &change.IP.IPNet, &description, &source, &createdAt, &item.VerifiedAt, &item.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if createdAt != nil {
+			item.IP = change.IP
+			item.CreatedAt = *createdAt
+			if description != nil {
+				item.Description = *description
+			}
+			if source != nil {
+				item.Source = *source
+			}
+			change.Item = &item
+		}
+
+		result = append(result, change)
+	}
+
+	return result, rows.Err()
+}
+
+// GCChanges deletes changes recorded before given time
+func (s *PostgresWhitelistRepository) GCChanges(before time.Time) (int64, error) {
+	ct, err := s.db.Exec(context.Background(), "DELETE FROM ip_whitelist_change WHERE created_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return ct.RowsAffected(), nil
+}
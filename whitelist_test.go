@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"net"
 	"testing"
+	"time"
 )
 
 func createWhitelistService(t *testing.T) *Whitelist {
@@ -14,8 +15,9 @@ func createWhitelistService(t *testing.T) *Whitelist {
 	pool, err := pgxpool.Connect(context.Background(), config.DSN)
 	require.NoError(t, err)
 
-	s, err := NewWhitelist(pool)
+	s, err := NewWhitelist(NewPostgresWhitelistRepository(pool))
 	require.NoError(t, err)
+	s.SetCrawlerRules(config.Crawlers)
 
 	return s
 }
@@ -42,6 +44,67 @@ func TestMatchAuto(t *testing.T) {
 
 }
 
+func TestMatchAutoForwardConfirmed(t *testing.T) {
+
+	dns := startFakeDNS(t)
+
+	s, err := NewWhitelist(NewMemoryWhitelistRepository())
+	require.NoError(t, err)
+	s.SetResolver(dns.Resolver())
+	s.SetCrawlerRules(LoadConfig().Crawlers)
+
+	// genuine crawler
+	google := net.IPv4(66, 249, 66, 1)
+	dns.AddPTR(google, "crawl-66-249-66-1.googlebot.com")
+	dns.AddIP("crawl-66-249-66-1.googlebot.com", google)
+
+	match, desc := s.MatchAuto(google)
+	require.True(t, match)
+	require.Equal(t, "googlebot autodetect", desc)
+
+	// PTR record set by owner of reverse zone without forward record
+	spoofed := net.IPv4(1, 2, 3, 4)
+	dns.AddPTR(spoofed, "crawl-1-2-3-4.googlebot.com")
+
+	match, _ = s.MatchAuto(spoofed)
+	require.False(t, match)
+
+	// forward record points to another address
+	other := net.IPv4(157, 55, 39, 127)
+	dns.AddPTR(other, "msnbot-157-55-39-127.search.msn.com")
+	dns.AddIP("msnbot-157-55-39-127.search.msn.com", net.IPv4(157, 55, 39, 128))
+
+	match, _ = s.MatchAuto(other)
+	require.False(t, match)
+
+	yandex := net.ParseIP("2a02:6b8:b010:a2fa:feaa::8d08:8eb7")
+	dns.AddPTR(yandex, "8d08-8eb7.spider.yandex.com")
+	dns.AddIP("8d08-8eb7.spider.yandex.com", yandex)
+
+	match, desc = s.MatchAuto(yandex)
+	require.True(t, match)
+	require.Equal(t, "yandex autodetect", desc)
+
+	apple := net.IPv4(17, 58, 101, 179)
+	dns.AddPTR(apple, "17-58-101-179.applebot.apple.com")
+	dns.AddIP("17-58-101-179.applebot.apple.com", apple)
+
+	match, desc = s.MatchAuto(apple)
+	require.True(t, match)
+	require.Equal(t, "applebot autodetect", desc)
+
+	// unknown hostname is not verified at all
+	unknown := net.IPv4(192, 0, 2, 1)
+	dns.AddPTR(unknown, "host.example.com")
+	dns.AddIP("host.example.com", unknown)
+
+	match, _ = s.MatchAuto(unknown)
+	require.False(t, match)
+
+	match, _ = s.MatchAuto(net.IPv4(127, 0, 0, 1))
+	require.False(t, match)
+}
+
 func TestContains(t *testing.T) {
 
 	s := createWhitelistService(t)
@@ -56,3 +119,114 @@ func TestContains(t *testing.T) {
 	require.True(t, exists)
 
 }
+
+func TestMemoryWhitelist(t *testing.T) {
+
+	s, err := NewWhitelist(NewMemoryWhitelistRepository())
+	require.NoError(t, err)
+
+	ip := net.IPv4(66, 249, 73, 139)
+
+	err = s.Add(ip, "test")
+	require.NoError(t, err)
+
+	item, err := s.Get(ip)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	require.Equal(t, NetworkFromIP(ip), item.IP)
+	require.Equal(t, "test", item.Description)
+	require.Equal(t, WhitelistSourceManual, item.Source)
+	require.WithinDuration(t, time.Now(), item.CreatedAt, time.Minute)
+	require.False(t, item.IsAutodetected())
+
+	list, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	err = s.Remove(ip)
+	require.NoError(t, err)
+
+	exists, err := s.Exists(ip)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestMemoryWhitelistExpiry(t *testing.T) {
+
+	s, err := NewWhitelist(NewMemoryWhitelistRepository())
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Minute)
+	err = s.AddItem(WhitelistItem{IP: NetworkFromIP(net.IPv4(192, 0, 2, 1)), Description: "test", ExpiresAt: &expired})
+	require.NoError(t, err)
+
+	future := time.Now().Add(time.Hour)
+	err = s.AddItem(WhitelistItem{IP: NetworkFromIP(net.IPv4(192, 0, 2, 2)), Description: "test", ExpiresAt: &future})
+	require.NoError(t, err)
+
+	exists, err := s.Exists(net.IPv4(192, 0, 2, 1))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = s.Exists(net.IPv4(192, 0, 2, 2))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	list, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	deleted, err := s.GC()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+}
+
+func TestMemoryWhitelistFind(t *testing.T) {
+
+	s, err := NewWhitelist(NewMemoryWhitelistRepository())
+	require.NoError(t, err)
+
+	now := time.Now()
+	items := []WhitelistItem{
+		{IP: mustParseNetwork(t, "192.0.2.1"), Description: "Office", CreatedAt: now.Add(-time.Hour)},
+		{IP: mustParseNetwork(t, "192.0.2.2"), Description: "googlebot", Source: "autodetect:google", CreatedAt: now.Add(-3 * time.Hour)},
+		{IP: mustParseNetwork(t, "192.0.2.3"), Description: "Googlebot 100%", Source: "autodetect:google", CreatedAt: now.Add(-2 * time.Hour)},
+	}
+	for _, item := range items {
+		require.NoError(t, s.AddItem(item))
+	}
+
+	find := func(query WhitelistListQuery) ([]string, WhitelistListPage) {
+		page, err := s.Find(query)
+		require.NoError(t, err)
+
+		result := make([]string, len(page.Items))
+		for idx, item := range page.Items {
+			result[idx] = item.IP.String()
+		}
+
+		return result, page
+	}
+
+	ips, page := find(WhitelistListQuery{})
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, ips)
+	require.Equal(t, 3, page.Total)
+
+	query := WhitelistListQuery{Sort: ListSort{Field: WhitelistSortCreatedAt}, Limit: 2}
+	ips, page = find(query)
+	require.Equal(t, []string{"192.0.2.2", "192.0.2.3"}, ips)
+	require.Equal(t, 3, page.Total)
+
+	query.After, err = ParseListCursor(page.NextCursor)
+	require.NoError(t, err)
+	ips, page = find(query)
+	require.Equal(t, []string{"192.0.2.1"}, ips)
+	require.Empty(t, page.NextCursor)
+
+	ips, page = find(WhitelistListQuery{Description: "GOOGLE"})
+	require.Equal(t, []string{"192.0.2.2", "192.0.2.3"}, ips)
+	require.Equal(t, 2, page.Total)
+
+	ips, _ = find(WhitelistListQuery{Source: WhitelistSourceManual})
+	require.Equal(t, []string{"192.0.2.1"}, ips)
+}